@@ -72,13 +72,13 @@ func registerBlockGen(m map[string]setupFunc, root *kingpin.CmdClause) {
 
 			var (
 				upload bool
-				bkt    objstore.InstrumentedBucket
+				bkt    objstore.Bucket
 			)
 			if len(objStoreContentYaml) == 0 {
 				level.Info(logger).Log("msg", "no supported bucket was configured, uploads will be disabled")
 			} else {
 				upload = true
-				bkt, err = client.NewBucket(logger, objStoreContentYaml, nil, "blockgen")
+				bkt, err = client.NewBucket(logger, objStoreContentYaml, "blockgen")
 				if err != nil {
 					return err
 				}
@@ -92,20 +92,27 @@ func registerBlockGen(m map[string]setupFunc, root *kingpin.CmdClause) {
 				}
 				for _, b := range bs {
 					level.Info(logger).Log("msg", "generating block", "spec", printBlocks(b))
-					id, err := blockgen.Generate(ctx, logger, goroutines, *outputDir, b)
+					ids, err := blockgen.Generate(ctx, logger, goroutines, *outputDir, b)
 					if err != nil {
 						return errors.Wrap(err, "generate")
 					}
 					n++
-					blockDir := path.Join(*outputDir, id.String())
-					level.Info(logger).Log("msg", "generated block", "path", blockDir, "count", n)
 					runtime.GC()
 
-					if upload {
-						if err := block.Upload(ctx, logger, bkt, blockDir, metadata.NoneFunc); err != nil {
-							return errors.Wrapf(err, "upload block %s", id)
+					for _, id := range ids {
+						blockDir := path.Join(*outputDir, id.String())
+						bytesPerSample, err := blockgen.BytesPerSample(logger, blockDir)
+						if err != nil {
+							return errors.Wrapf(err, "bytes per sample of block %s", id)
+						}
+						level.Info(logger).Log("msg", "generated block", "path", blockDir, "count", n, "bytes_per_sample", fmt.Sprintf("%.3f", bytesPerSample))
+
+						if upload {
+							if err := block.Upload(ctx, logger, bkt, blockDir, metadata.NoneFunc); err != nil {
+								return errors.Wrapf(err, "upload block %s", id)
+							}
+							level.Info(logger).Log("msg", "uploaded block to object storage", "path", blockDir)
 						}
-						level.Info(logger).Log("msg", "uploaded block to object storage", "path", blockDir)
 					}
 				}
 				return ctx.Err()
@@ -125,20 +132,27 @@ func registerBlockGen(m map[string]setupFunc, root *kingpin.CmdClause) {
 				}
 
 				level.Info(logger).Log("msg", "generating block", "spec", printBlocks(b))
-				id, err := blockgen.Generate(ctx, logger, goroutines, *outputDir, b)
+				ids, err := blockgen.Generate(ctx, logger, goroutines, *outputDir, b)
 				if err != nil {
 					return errors.Wrap(err, "generate")
 				}
 				n++
-				blockDir := path.Join(*outputDir, id.String())
-				level.Info(logger).Log("msg", "generated block", "path", blockDir, "count", n)
 				runtime.GC()
 
-				if upload {
-					if err := block.Upload(ctx, logger, bkt, blockDir, metadata.NoneFunc); err != nil {
-						return errors.Wrapf(err, "upload block %s", id)
+				for _, id := range ids {
+					blockDir := path.Join(*outputDir, id.String())
+					bytesPerSample, err := blockgen.BytesPerSample(logger, blockDir)
+					if err != nil {
+						return errors.Wrapf(err, "bytes per sample of block %s", id)
+					}
+					level.Info(logger).Log("msg", "generated block", "path", blockDir, "count", n, "bytes_per_sample", fmt.Sprintf("%.3f", bytesPerSample))
+
+					if upload {
+						if err := block.Upload(ctx, logger, bkt, blockDir, metadata.NoneFunc); err != nil {
+							return errors.Wrapf(err, "upload block %s", id)
+						}
+						level.Info(logger).Log("msg", "uploaded block to object storage", "path", blockDir)
 					}
-					level.Info(logger).Log("msg", "uploaded block to object storage", "path", blockDir)
 				}
 			}
 			return ctx.Err()
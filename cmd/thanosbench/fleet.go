@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	extflag "github.com/efficientgo/tools/extkingpin"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/thanosbench/pkg/exporter"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+func registerFleet(m map[string]setupFunc, app *kingpin.Application) {
+	cmd := app.Command("fleet", "Serves fleet of fake exporters on distinct paths, published by HTTP service discovery on /sd and optionally file service discovery.")
+	config := extflag.RegisterPathOrContent(cmd, "config", "YAML for exporter.FleetConfig.", extflag.WithRequired(), extflag.WithEnvSubstitution())
+	httpAddr := cmd.Flag("http-address", "Listen host:port for HTTP endpoints.").Default("0.0.0.0:8080").String()
+	address := cmd.Flag("advertise-address", "host:port of targets in service discovery. Defaults to hostname and port of http-address.").String()
+	seed := cmd.Flag("seed", "Seed of random generators, so fleets with different seeds serve different targets. Defaults to hostname.").String()
+	fileSD := cmd.Flag("file-sd", "Path of the file for Prometheus file service discovery, not written if empty.").String()
+	refresh := cmd.Flag("refresh-interval", "Interval of replacing targets and writing file service discovery.").Default("5s").Duration()
+
+	m["fleet"] = func(g *run.Group, logger log.Logger) error {
+		cfg, err := config.Content()
+		if err != nil {
+			return err
+		}
+		var c exporter.FleetConfig
+		if err := yaml.UnmarshalStrict(cfg, &c); err != nil {
+			return errors.Wrap(err, "parse config")
+		}
+
+		hostname, err := os.Hostname()
+		if err != nil {
+			return errors.Wrap(err, "get hostname")
+		}
+		if *seed == "" {
+			*seed = hostname
+		}
+		if *address == "" {
+			_, port, err := net.SplitHostPort(*httpAddr)
+			if err != nil {
+				return errors.Wrap(err, "parse http address")
+			}
+			*address = net.JoinHostPort(hostname, port)
+		}
+
+		f, err := exporter.NewFleet(c, *address, labels.FromStrings("seed", *seed))
+		if err != nil {
+			return err
+		}
+
+		srv := &http.Server{Addr: *httpAddr, Handler: f.Handler()}
+		g.Add(func() error {
+			level.Info(logger).Log("msg", "serving fleet of targets", "address", *httpAddr, "targets", c.Targets, "seed", *seed)
+			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		}, func(error) {
+			_ = srv.Shutdown(context.Background())
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			t := time.NewTicker(*refresh)
+			defer t.Stop()
+			for {
+				if err := f.Refresh(); err != nil {
+					return errors.Wrap(err, "refresh targets")
+				}
+				if *fileSD != "" {
+					if err := f.WriteFileSD(*fileSD); err != nil {
+						return errors.Wrap(err, "write file service discovery")
+					}
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-t.C:
+				}
+			}
+		}, func(error) {
+			cancel()
+		})
+		return nil
+	}
+}
@@ -46,6 +46,9 @@ func main() {
 	registerWalgen(cmds, app)
 	registerBlock(cmds, app)
 	registerStress(cmds, app)
+	registerServe(cmds, app)
+	registerFleet(cmds, app)
+	registerRemoteWrite(cmds, app)
 
 	cmd, err := app.Parse(os.Args[1:])
 	if err != nil {
@@ -83,7 +86,7 @@ func main() {
 	}
 
 	loggerAdapter := func(template string, args ...interface{}) {
-		level.Debug(logger).Log("msg", fmt.Sprintf(template, args))
+		level.Debug(logger).Log("msg", fmt.Sprintf(template, args...))
 	}
 
 	// Running in container with limits but with empty/wrong value of GOMAXPROCS env var could lead to throttling by cpu
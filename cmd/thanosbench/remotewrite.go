@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	extflag "github.com/efficientgo/tools/extkingpin"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/thanos-io/thanosbench/pkg/remotewrite"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+func registerRemoteWrite(m map[string]setupFunc, app *kingpin.Application) {
+	cmd := app.Command("remote-write", "Writes generated series to a Prometheus remote write endpoint, in real time or as fast as possible for backfill.")
+	config := extflag.RegisterPathOrContent(cmd, "config", "YAML for remotewrite.Config.", extflag.WithRequired(), extflag.WithEnvSubstitution())
+	url := cmd.Flag("url", "URL of the remote write endpoint, e.g http://receive:19291/api/v1/receive, or OTLP endpoint if configured.").Required().String()
+	seed := cmd.Flag("seed", "Seed of random generators, so writers with different seeds write different values. Defaults to hostname.").String()
+	backfill := cmd.Flag("backfill", "Write samples of the given time range before now as fast as possible instead of in real time.").Duration()
+	duration := cmd.Flag("duration", "How long to write in real time. Writes until interrupted if 0.").Duration()
+	statsInterval := cmd.Flag("stats-interval", "Interval of logging request statistics.").Default("10s").Duration()
+
+	m["remote-write"] = func(g *run.Group, logger log.Logger) error {
+		cfg, err := config.Content()
+		if err != nil {
+			return err
+		}
+		var c remotewrite.Config
+		if err := yaml.UnmarshalStrict(cfg, &c); err != nil {
+			return errors.Wrap(err, "parse config")
+		}
+
+		if *seed == "" {
+			if *seed, err = os.Hostname(); err != nil {
+				return errors.Wrap(err, "get hostname")
+			}
+		}
+		w, err := remotewrite.New(logger, c, *url, labels.FromStrings("seed", *seed))
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			level.Info(logger).Log("msg", "writing generated series", "url", *url, "backfill", *backfill, "seed", *seed)
+			if *backfill > 0 {
+				now := timestamp.FromTime(time.Now())
+				err = w.Backfill(ctx, now-backfill.Milliseconds(), now)
+			} else {
+				var until time.Time
+				if *duration > 0 {
+					until = time.Now().Add(*duration)
+				}
+				err = w.RealTime(ctx, until)
+			}
+			if err != nil && ctx.Err() == nil {
+				return errors.Wrap(err, "remote write")
+			}
+			level.Info(logger).Log("msg", "writing done", "stats", w.Stats())
+			return nil
+		}, func(error) {
+			cancel()
+		})
+
+		statsCtx, statsCancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			t := time.NewTicker(*statsInterval)
+			defer t.Stop()
+			for {
+				select {
+				case <-statsCtx.Done():
+					return nil
+				case <-t.C:
+					level.Info(logger).Log("msg", "remote write statistics", "stats", w.Stats())
+				}
+			}
+		}, func(error) {
+			statsCancel()
+		})
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	extflag "github.com/efficientgo/tools/extkingpin"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/thanosbench/pkg/exporter"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+)
+
+func registerServe(m map[string]setupFunc, app *kingpin.Application) {
+	cmd := app.Command("serve", "Serves generated series on /metrics endpoint as a fake exporter. Values advance in wall-clock time.")
+	config := extflag.RegisterPathOrContent(cmd, "config", "YAML for exporter.Config.", extflag.WithRequired(), extflag.WithEnvSubstitution())
+	httpAddr := cmd.Flag("http-address", "Listen host:port for HTTP endpoints.").Default("0.0.0.0:8080").String()
+	seed := cmd.Flag("seed", "Seed of random generators, so exporters with different seeds serve different values. Defaults to hostname.").String()
+
+	m["serve"] = func(g *run.Group, logger log.Logger) error {
+		cfg, err := config.Content()
+		if err != nil {
+			return err
+		}
+		var c exporter.Config
+		if err := yaml.UnmarshalStrict(cfg, &c); err != nil {
+			return errors.Wrap(err, "parse config")
+		}
+
+		if *seed == "" {
+			if *seed, err = os.Hostname(); err != nil {
+				return errors.Wrap(err, "get hostname")
+			}
+		}
+		e, err := exporter.New(c, labels.FromStrings("seed", *seed))
+		if err != nil {
+			return err
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", e.Handler())
+		srv := &http.Server{Addr: *httpAddr, Handler: mux}
+		g.Add(func() error {
+			level.Info(logger).Log("msg", "serving generated series", "address", *httpAddr, "seed", *seed)
+			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		}, func(error) {
+			_ = srv.Shutdown(context.Background())
+		})
+		return nil
+	}
+}
@@ -0,0 +1,103 @@
+package k8s
+
+import (
+	"fmt"
+
+	"github.com/bwplotka/mimic"
+	"github.com/bwplotka/mimic/encoding"
+	"github.com/go-openapi/swag"
+	"github.com/thanos-io/thanosbench/configs/abstractions/dockerimage"
+	"github.com/thanos-io/thanosbench/pkg/exporter"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+type FakeExporterOpts struct {
+	Namespace string
+	Name      string
+	Replicas  int32
+
+	Config         exporter.Config
+	ThanosbenchImg dockerimage.Image
+	Resources      corev1.ResourceRequirements
+}
+
+// GenFakeExporter generates deployment of thanosbench serve, exposing generated series on port named http, so pods
+// are scraped by kubernetes-pods job of the monitor. Every replica serves different values, seeded by its pod name.
+func GenFakeExporter(gen *mimic.Generator, opts FakeExporterOpts) {
+	const httpPort = 8080
+
+	container := corev1.Container{
+		Name:    "exporter",
+		Image:   opts.ThanosbenchImg.String(),
+		Command: []string{"/bin/thanosbench"},
+		Args: []string{
+			"serve",
+			fmt.Sprintf("--config=%s", string(genInPlace(encoding.YAML(opts.Config)))),
+			fmt.Sprintf("--http-address=0.0.0.0:%d", httpPort),
+			"--seed=$(POD_NAME)",
+		},
+		Env: []corev1.EnvVar{
+			{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{
+					FieldPath: "metadata.name",
+				},
+			}},
+		},
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          "http",
+				ContainerPort: httpPort,
+			},
+		},
+		ReadinessProbe: &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Port: intstr.FromInt(httpPort),
+					Path: "metrics",
+				},
+			},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsNonRoot: swag.Bool(false),
+			RunAsUser:    swag.Int64(1000),
+		},
+		Resources: opts.Resources,
+	}
+
+	deployment := appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Deployment",
+			APIVersion: "apps/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      opts.Name,
+			Namespace: opts.Namespace,
+			Labels: map[string]string{
+				selectorName: opts.Name,
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: swag.Int32(opts.Replicas),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						selectorName: opts.Name,
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers:                    []corev1.Container{container},
+					TerminationGracePeriodSeconds: swag.Int64(30),
+				},
+			},
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					selectorName: opts.Name,
+				},
+			},
+		},
+	}
+	gen.Add(opts.Name+".yaml", encoding.GhodssYAML(deployment))
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/rand"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/cespare/xxhash/v2"
@@ -13,8 +14,14 @@ import (
 	"github.com/go-kit/log"
 	"github.com/oklog/ulid"
 	"github.com/prometheus/prometheus/model/labels"
+	promMetadata "github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/model/textparse"
 	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/runutil"
 	"github.com/thanos-io/thanosbench/pkg/seriesgen"
 )
 
@@ -23,8 +30,9 @@ type Writer interface {
 	storage.Appendable
 
 	// Flush writes current block to disk.
-	// The block will contain values accumulated by `Write`.
-	Flush() (ulid.ULID, error)
+	// The block will contain values accumulated by `Write`. Out-of-order samples are written into separate,
+	// overlapping blocks, same as Prometheus does, returned after the in-order block.
+	Flush() ([]ulid.ULID, error)
 }
 
 // TODO(bwplotka): Add option to create downsampled blocks.
@@ -39,9 +47,33 @@ const (
 	Random  GenType = "RANDOM"
 	Counter GenType = "COUNTER"
 	Gauge   GenType = "GAUGE"
+	// Sine, Sawtooth and Square are gauges with periodic shape, e.g daily or weekly seasonality.
+	Sine     GenType = "SINE"
+	Sawtooth GenType = "SAWTOOTH"
+	Square   GenType = "SQUARE"
+	// LinearTrend and ExponentialTrend are gauges constantly growing (or decreasing), e.g disk filling up.
+	LinearTrend      GenType = "LINEAR_TREND"
+	ExponentialTrend GenType = "EXPONENTIAL_TREND"
+	// Histogram expands into classic histogram series: cumulative `le` buckets, `_sum` and `_count`.
+	Histogram GenType = "HISTOGRAM"
+	// NativeHistogram generates native (sparse) histogram samples.
+	NativeHistogram GenType = "NATIVE_HISTOGRAM"
+	// Summary expands into summary series: `quantile` gauges, `_sum` and `_count`.
+	Summary GenType = "SUMMARY"
+	// Replay replays samples recorded in a file, e.g query_range result captured during incident.
+	Replay GenType = "REPLAY"
+	// Info generates constant 1, as info metrics like node_info used for joins.
+	Info GenType = "INFO"
 )
 
 func (g GenType) Create(random *rand.Rand, mint, maxt int64, opts seriesgen.Characteristics) (seriesgen.SeriesIterator, error) {
+	switch g {
+	case Random, Counter, Gauge:
+		if err := opts.Distribution.Validate(); err != nil {
+			return nil, errors.Wrap(err, "distribution")
+		}
+	}
+
 	switch g {
 	case Random:
 		return seriesgen.NewValGen(random, mint, maxt, opts), nil
@@ -49,17 +81,190 @@ func (g GenType) Create(random *rand.Rand, mint, maxt int64, opts seriesgen.Char
 		return seriesgen.NewCounterGen(random, mint, maxt, opts), nil
 	case Gauge:
 		return seriesgen.NewGaugeGen(random, mint, maxt, opts), nil
+	case Sine:
+		return seriesgen.NewSineGen(random, mint, maxt, opts), nil
+	case Sawtooth:
+		return seriesgen.NewSawtoothGen(random, mint, maxt, opts), nil
+	case Square:
+		return seriesgen.NewSquareGen(random, mint, maxt, opts), nil
+	case LinearTrend:
+		return seriesgen.NewLinearTrendGen(random, mint, maxt, opts), nil
+	case ExponentialTrend:
+		return seriesgen.NewExponentialTrendGen(random, mint, maxt, opts), nil
+	case Info:
+		return seriesgen.NewConstGen(mint, maxt, 1, opts), nil
+	case NativeHistogram:
+		if err := opts.NativeHistogram.Observations.Validate(); err != nil {
+			return nil, errors.Wrap(err, "native histogram observations")
+		}
+		return seriesgen.NewNativeHistogramGen(random, mint, maxt, opts), nil
+	case Replay:
+		if opts.Replay.File == "" {
+			return nil, errors.New("replay: no file with recorded samples specified")
+		}
+		m, err := seriesgen.LoadRecording(opts.Replay.File)
+		if err != nil {
+			return nil, err
+		}
+		// Targets replaying file with multiple series replay all of them in turn.
+		return seriesgen.NewReplayGen(random, mint, maxt, m[opts.Replay.Target%len(m)].Values, opts), nil
+	case Histogram, Summary:
+		return nil, errors.Errorf("type %s generates multiple series, use CreateSeries", string(g))
 	default:
 		return nil, errors.Errorf("unknown type: %s", string(g))
 	}
 }
 
+// Metadata returns metric metadata of series generated by this type. Help defaults to the type description.
+func (g GenType) Metadata(opts seriesgen.Characteristics) promMetadata.Metadata {
+	m := promMetadata.Metadata{
+		Type: textparse.MetricTypeGauge,
+		Help: opts.Help,
+		Unit: opts.Unit,
+	}
+	switch g {
+	case Random, Replay:
+		m.Type = textparse.MetricTypeUnknown
+	case Counter:
+		m.Type = textparse.MetricTypeCounter
+	case Histogram, NativeHistogram:
+		m.Type = textparse.MetricTypeHistogram
+	case Summary:
+		m.Type = textparse.MetricTypeSummary
+	case Info:
+		m.Type = textparse.MetricTypeInfo
+	}
+	if m.Help == "" {
+		m.Help = fmt.Sprintf("Artificial %s series generated by thanosbench.", strings.ToLower(string(g)))
+	}
+	return m
+}
+
+// CreateSeries creates all series generated for given labels. Most types generate just one series, but some
+// (e.g HISTOGRAM, SUMMARY) expand into multiple series that share the same seed to stay consistent.
+func (g GenType) CreateSeries(lset labels.Labels, seed int64, mint, maxt int64, opts seriesgen.Characteristics) ([]seriesgen.Series, error) {
+	series, opts, err := g.createRaw(lset, seed, mint, maxt, opts)
+	if err != nil {
+		return nil, err
+	}
+	return g.Wrap(series, seed, mint, maxt, opts), nil
+}
+
+// createRaw creates series as CreateSeries does, but without characteristics applied by Wrap. Characteristics are
+// returned calibrated, if bytesPerSample is configured.
+func (g GenType) createRaw(lset labels.Labels, seed int64, mint, maxt int64, opts seriesgen.Characteristics) ([]seriesgen.Series, seriesgen.Characteristics, error) {
+	for _, a := range opts.Anomalies {
+		if err := a.Validate(); err != nil {
+			return nil, opts, err
+		}
+	}
+	if err := opts.SpecialValues.Validate(); err != nil {
+		return nil, opts, err
+	}
+	if opts.BytesPerSample > 0 {
+		if g != Gauge {
+			return nil, opts, errors.Errorf("bytesPerSample is supported only by %s type, got %s", Gauge, g)
+		}
+		opts, _ = seriesgen.CalibrateBytesPerSample(opts)
+	}
+
+	switch g {
+	case Histogram:
+		if err := opts.Histogram.Validate(); err != nil {
+			return nil, opts, err
+		}
+		if err := opts.Histogram.Observations.Validate(); err != nil {
+			return nil, opts, errors.Wrap(err, "histogram observations")
+		}
+		return seriesgen.NewHistogramSeries(lset, seed, mint, maxt, opts), opts, nil
+	case Summary:
+		if err := opts.Summary.Observations.Validate(); err != nil {
+			return nil, opts, errors.Wrap(err, "summary observations")
+		}
+		return seriesgen.NewSummarySeries(lset, seed, mint, maxt, opts), opts, nil
+	default:
+		iter, err := g.Create(rand.New(rand.NewSource(seed)), mint, maxt, opts)
+		if err != nil {
+			return nil, opts, err
+		}
+		return []seriesgen.Series{seriesgen.NewSeriesGen(lset, iter)}, opts, nil
+	}
+}
+
+// Wrap applies characteristics common for all types (e.g anomalies, timestamp jitter, gaps) and metadata to the given
+// series generated with the given seed.
+func (g GenType) Wrap(series []seriesgen.Series, seed int64, mint, maxt int64, opts seriesgen.Characteristics) []seriesgen.Series {
+	if len(opts.Anomalies) > 0 {
+		for i, s := range series {
+			// The same seed for all series, so all series of the target have the same events.
+			series[i] = seriesgen.NewSeriesGen(s.Labels(), seriesgen.NewAnomalyIterator(rand.New(rand.NewSource(seed)), mint, s.Iterator(), opts.Anomalies))
+		}
+	}
+	if opts.SpecialValues.Enabled() {
+		for i, s := range series {
+			series[i] = seriesgen.NewSeriesGen(s.Labels(), seriesgen.NewSpecialValuesIterator(rand.New(rand.NewSource(seed)), s.Iterator(), opts.SpecialValues))
+		}
+	}
+	if opts.ScrapeOffset || opts.TimestampJitter > 0 {
+		var offset time.Duration
+		if opts.ScrapeOffset {
+			offset = seriesgen.ScrapeOffset(uint64(seed), opts.ScrapeInterval)
+		}
+		for i, s := range series {
+			// The same seed for all series, so they have the same timestamps as if scraped from the same target.
+			series[i] = seriesgen.NewSeriesGen(s.Labels(), seriesgen.NewTimestampIterator(rand.New(rand.NewSource(seed)), mint, maxt, s.Iterator(), offset, opts.TimestampJitter, opts.ScrapeInterval))
+		}
+	}
+	if opts.Exemplars.Enabled() {
+		for i, s := range series {
+			series[i] = seriesgen.NewSeriesGen(s.Labels(), seriesgen.NewExemplarIterator(rand.New(rand.NewSource(seed)), s.Iterator(), opts.Exemplars))
+		}
+	}
+	if opts.Gaps.Enabled() {
+		for i, s := range series {
+			// The same seed for all series, so they miss the same scrapes.
+			series[i] = seriesgen.NewSeriesGen(s.Labels(), seriesgen.NewGapsIterator(rand.New(rand.NewSource(seed)), mint, s.Iterator(), opts.Gaps))
+		}
+	}
+	if opts.OutOfOrder.Enabled() {
+		for i, s := range series {
+			// The same seed for all series, so all series of the target are delayed together.
+			series[i] = seriesgen.NewSeriesGen(s.Labels(), seriesgen.NewOutOfOrderIterator(rand.New(rand.NewSource(seed)), s.Iterator(), opts.OutOfOrder))
+		}
+	}
+
+	meta := g.Metadata(opts)
+	for i, s := range series {
+		series[i] = seriesgen.NewSeriesGenWithMetadata(s.Labels(), s.Iterator(), meta)
+	}
+	return series
+}
+
 type SeriesSpec struct {
 	Labels labels.Labels `yaml:"labels"`
 
 	// Targets multiples labels by given targets.
 	Targets int `yaml:"targets"`
 
+	// Family groups consecutive series describing the same targets, e.g usage and limit of the same pods. Series of
+	// the family are generated together for every target and share target labels, so joins between them match. All
+	// series of the family have to have the same Targets.
+	Family string `yaml:"family"`
+	// Relation derives values of the series from the first series of the family, e.g limit from usage. Type is
+	// used only for metadata then. The base series is generated again for every derived series of every target
+	// instead of buffering its samples, so every derived series costs as much CPU as its base on top of its own.
+	Relation seriesgen.Relation `yaml:"relation"`
+	// Cardinality multiplies series of every target by label combinations, e.g to reproduce cardinality of real
+	// clusters.
+	Cardinality CardinalityModel `yaml:"cardinality"`
+	// TargetLabels are added to series of every target instead of the __blockgen_target__ label. Series of the family
+	// use target labels of the first series. Target labels should identify the target, otherwise targets produce
+	// the same series.
+	TargetLabels []TargetLabel `yaml:"targetLabels"`
+	// Churn replaces targets over time with targets with fresh label values. Series of the family use churn of the
+	// first series.
+	Churn Churn `yaml:"churn"`
+
 	Type GenType `yaml:"type"`
 
 	MinTime, MaxTime int64
@@ -72,10 +277,18 @@ func durToMilis(t time.Duration) int64 {
 }
 
 // Generate creates a block from given spec using given go routines in a given directory.
-func Generate(ctx context.Context, logger log.Logger, goroutines int, dir string, block BlockSpec) (ulid.ULID, error) {
-	w, err := NewTSDBBlockWriter(logger, dir)
+// If any series has out-of-order samples, additional blocks overlapping the first one are created.
+func Generate(ctx context.Context, logger log.Logger, goroutines int, dir string, block BlockSpec) ([]ulid.ULID, error) {
+	newWriter := NewTSDBBlockWriter
+	for _, s := range block.Series {
+		if s.OutOfOrder.Enabled() {
+			newWriter = NewTSDBBlockWriterWithOutOfOrder
+			break
+		}
+	}
+	w, err := newWriter(logger, dir)
 	if err != nil {
-		return ulid.ULID{}, err
+		return nil, err
 	}
 
 	extLset := block.Thanos.Labels
@@ -84,51 +297,253 @@ func Generate(ctx context.Context, logger log.Logger, goroutines int, dir string
 	}
 	set := &blockSeriesSet{config: block, extLset: labels.FromMap(extLset)}
 	if err := seriesgen.Append(ctx, goroutines, w, set); err != nil {
-		return ulid.ULID{}, errors.Wrap(err, "append")
+		return nil, errors.Wrap(err, "append")
 	}
-	id, err := w.Flush()
+	ids, err := w.Flush()
 	if err != nil {
-		return ulid.ULID{}, errors.Wrap(err, "flush")
+		return nil, errors.Wrap(err, "flush")
 	}
 
-	bdir := path.Join(dir, id.String())
-	meta, err := metadata.ReadFromDir(bdir)
+	for _, id := range ids {
+		bdir := path.Join(dir, id.String())
+		meta, err := metadata.ReadFromDir(bdir)
+		if err != nil {
+			return nil, errors.Wrap(err, "meta read")
+		}
+		meta.Thanos = block.Thanos
+		if err := meta.WriteToDir(logger, bdir); err != nil {
+			return nil, errors.Wrap(err, "meta write")
+		}
+	}
+	return ids, nil
+}
+
+// BytesPerSample returns average size of chunk data per sample of the block in the given directory. Only encoded
+// chunk data is counted, without chunk headers and index, so it can be compared with CalibrateBytesPerSample targets.
+func BytesPerSample(logger log.Logger, dir string) (float64, error) {
+	b, err := tsdb.OpenBlock(logger, dir, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "open block")
+	}
+	defer runutil.CloseWithLogOnErr(logger, b, "close block")
+
+	ir, err := b.Index()
 	if err != nil {
-		return ulid.ULID{}, errors.Wrap(err, "meta read")
+		return 0, errors.Wrap(err, "index reader")
 	}
-	meta.Thanos = block.Thanos
-	if err := meta.WriteToDir(logger, bdir); err != nil {
-		return ulid.ULID{}, errors.Wrap(err, "meta write")
+	defer runutil.CloseWithLogOnErr(logger, ir, "close index reader")
+
+	cr, err := b.Chunks()
+	if err != nil {
+		return 0, errors.Wrap(err, "chunk reader")
+	}
+	defer runutil.CloseWithLogOnErr(logger, cr, "close chunk reader")
+
+	p, err := ir.Postings(index.AllPostingsKey())
+	if err != nil {
+		return 0, errors.Wrap(err, "postings")
 	}
-	return id, nil
+
+	var (
+		builder labels.ScratchBuilder
+		chks    []chunks.Meta
+		bytes   int
+		samples int
+	)
+	for p.Next() {
+		if err := ir.Series(p.At(), &builder, &chks); err != nil {
+			return 0, errors.Wrap(err, "series")
+		}
+		for _, c := range chks {
+			chk, err := cr.Chunk(c)
+			if err != nil {
+				return 0, errors.Wrapf(err, "chunk %d", c.Ref)
+			}
+			bytes += len(chk.Bytes())
+			samples += chk.NumSamples()
+		}
+	}
+	if err := p.Err(); err != nil {
+		return 0, errors.Wrap(err, "iterate postings")
+	}
+	if samples == 0 {
+		return 0, nil
+	}
+	return float64(bytes) / float64(samples), nil
+}
+
+// NewSeriesSet returns series of given specs, the same as generated into blocks with given external labels. External
+// labels only seed random generators, they are not added to series.
+func NewSeriesSet(series []SeriesSpec, extLset labels.Labels) seriesgen.SeriesSet {
+	return &blockSeriesSet{config: BlockSpec{Series: series}, extLset: extLset}
 }
 
 type blockSeriesSet struct {
 	config  BlockSpec
 	extLset labels.Labels
-	i       int
-	target  int
 	err     error
 
+	// Series specs [i, end) are the current group, e.g family, generated for the current target. member is the next
+	// spec of the group to generate and combo its next label combination.
+	i, end, member, combo int
+	target                int
+	// lives are lifetimes of the current target within the block, life is the current one.
+	lives []Lifetime
+	life  int
+	// combos are label combinations of specs of the current group.
+	combos []*labelCombinations
+	// targetLset are target labels of the current target, generated by targetGen if the group has any.
+	targetGen  *targetLabelsGen
+	targetLset labels.Labels
+
 	curr seriesgen.Series
+	// pending are remaining series expanded from the current spec and target.
+	pending []seriesgen.Series
+}
+
+// groupEnd returns end of the group of series specs starting at i. Consecutive series of the same family are
+// generated together.
+func (s *blockSeriesSet) groupEnd(i int) int {
+	end := i + 1
+	if s.config.Series[i].Family == "" {
+		return end
+	}
+	for end < len(s.config.Series) && s.config.Series[end].Family == s.config.Series[i].Family {
+		end++
+	}
+	return end
 }
 
 func (s *blockSeriesSet) Next() bool {
-	if s.target > 0 {
-		s.target--
+	if len(s.pending) > 0 {
+		s.curr, s.pending = s.pending[0], s.pending[1:]
+		return true
 	}
-	if s.target <= 0 && s.i >= len(s.config.Series) {
+
+	if s.member >= s.end {
+		// All series of the current target are done.
+		if s.life+1 < len(s.lives) {
+			// Target was replaced.
+			s.life++
+			s.member = s.i
+		} else if s.target > 1 {
+			s.target--
+			s.member = s.i
+			s.lives = nil
+		} else {
+			if s.end >= len(s.config.Series) {
+				return false
+			}
+			s.i, s.end = s.end, s.groupEnd(s.end)
+			s.member = s.i
+			s.target = s.config.Series[s.i].Targets
+			s.lives = nil
+			for _, series := range s.config.Series[s.i+1 : s.end] {
+				if series.Targets != s.target {
+					s.err = errors.Errorf("family %s: all series have to have the same targets, got %d and %d", series.Family, s.target, series.Targets)
+					return false
+				}
+			}
+
+			s.combos = s.combos[:0]
+			for _, series := range s.config.Series[s.i:s.end] {
+				if series.Relation.Enabled() && len(series.Cardinality.Labels) > 0 {
+					s.err = errors.Errorf("family %s: relation can't be used together with cardinality model", series.Family)
+					return false
+				}
+				// Combinations are the same for all targets.
+				c, err := newLabelCombinations(s.seed(series.Labels), series.Cardinality)
+				if err != nil {
+					s.err = err
+					return false
+				}
+				s.combos = append(s.combos, c)
+			}
+
+			if c := s.config.Series[s.i].Churn; c.Enabled() {
+				if err := c.Validate(); err != nil {
+					s.err = err
+					return false
+				}
+			}
+
+			s.targetGen = nil
+			if tls := s.config.Series[s.i].TargetLabels; len(tls) > 0 {
+				g, err := newTargetLabelsGen(tls)
+				if err != nil {
+					s.err = err
+					return false
+				}
+				s.targetGen = g
+			}
+		}
+	}
+
+	if s.member == s.i && s.combo == 0 {
+		if err := s.nextTarget(); err != nil {
+			s.err = err
+			return false
+		}
+	}
+
+	gen, err := s.createSeries(s.member, s.combo)
+	if s.combo++; s.combo >= s.combos[s.member-s.i].Len() {
+		s.combo = 0
+		s.member++
+	}
+	if err != nil {
+		s.err = err
 		return false
 	}
+	s.curr, s.pending = gen[0], gen[1:]
+	return true
+}
 
-	if s.target <= 0 {
-		s.i++
-		s.target = s.config.Series[s.i-1].Targets
+// nextTarget generates target labels for the current target and its lifetime. All series of the group share them.
+func (s *blockSeriesSet) nextTarget() error {
+	base := s.config.Series[s.i]
+	lset := labels.Labels(append([]labels.Label{{Name: "__blockgen_target__", Value: fmt.Sprintf("%v", s.target)}}, base.Labels...))
+	if s.lives == nil {
+		s.lives = base.Churn.Lifetimes(s.seed(lset), base.MinTime, base.MaxTime, base.ScrapeInterval)
+		if len(s.lives) == 0 {
+			// Empty time range, series have no samples anyway.
+			s.lives = []Lifetime{foreverLifetime}
+		}
+		s.life = 0
+	}
+	if base.Churn.Enabled() {
+		// Replaced target gets fresh labels.
+		lset[0].Value = fmt.Sprintf("%v-%v", s.target, s.lives[s.life].Start)
+	}
+	if s.targetGen == nil {
+		s.targetLset = lset[:1]
+		return nil
 	}
 
-	series := s.config.Series[s.i-1]
-	lset := labels.Labels(append([]labels.Label{{Name: "__blockgen_target__", Value: fmt.Sprintf("%v", s.target)}}, series.Labels...))
+	// Target index counts from 0, while target counts down.
+	tlset, err := s.targetGen.Labels(s.seed(lset), base.Targets-s.target)
+	if err != nil {
+		return err
+	}
+	s.targetLset = tlset
+	return nil
+}
+
+// targetLabels returns labels of the series for the current target.
+func (s *blockSeriesSet) targetLabels(series SeriesSpec) labels.Labels {
+	if s.targetGen == nil {
+		return append(labels.Labels{s.targetLset[0]}, series.Labels...)
+	}
+	// Series labels take precedence over target labels.
+	b := labels.NewBuilder(s.targetLset)
+	for _, l := range series.Labels {
+		b.Set(l.Name, l.Value)
+	}
+	return b.Labels()
+}
 
+// seed returns stable random seed for given series labels.
+func (s *blockSeriesSet) seed(lset labels.Labels) int64 {
 	b := make([]byte, 0, 1024)
 	for _, v := range lset {
 		b = append(b, v.Name...)
@@ -142,20 +557,51 @@ func (s *blockSeriesSet) Next() bool {
 		b = append(b, v.Value...)
 		b = append(b, '\xff')
 	}
+	return int64(xxhash.Sum64(b))
+}
 
+// createSeries creates series of the i-th spec for the current target and given label combination.
+func (s *blockSeriesSet) createSeries(i, combo int) ([]seriesgen.Series, error) {
+	series := s.config.Series[i]
+	lset := s.targetLabels(series)
+	if c := s.combos[i-s.i]; c != nil {
+		b := labels.NewBuilder(lset)
+		c.Set(b, combo)
+		lset = b.Labels()
+	}
 	// Stable random per series name.
-	iter, err := series.Type.Create(
-		rand.New(rand.NewSource(int64(xxhash.Sum64(b)))),
-		series.MinTime,
-		series.MaxTime,
-		series.Characteristics,
-	)
+	seed := s.seed(lset)
+	// Target index counts from 0, while target counts down.
+	target := s.config.Series[s.i].Targets - s.target
+
+	life := s.lives[s.life]
+	if !series.Relation.Enabled() || i == s.i {
+		mint, maxt, opts := life.Window(series.MinTime, series.MaxTime, series.Characteristics)
+		opts.Replay.Target = target
+		return series.Type.CreateSeries(lset, seed, mint, maxt, opts)
+	}
+
+	if err := series.Relation.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "family %s", series.Family)
+	}
+	// Base series is generated again with the same seed, so derived values follow it exactly. This is cheaper in
+	// memory than keeping samples of the base, as the exporter keeps all series of its window live at once.
+	// Characteristics of the base applied on top of values (e.g gaps, exemplars) are not, derived series has only its
+	// own.
+	base := s.config.Series[s.i]
+	baseLset := s.targetLabels(base)
+	mint, maxt, opts := life.Window(base.MinTime, base.MaxTime, base.Characteristics)
+	opts.Replay.Target = target
+	gen, _, err := base.Type.createRaw(baseLset, s.seed(baseLset), mint, maxt, opts)
 	if err != nil {
-		s.err = err
-		return false
+		return nil, errors.Wrapf(err, "family %s: base series", series.Family)
 	}
-	s.curr = seriesgen.NewSeriesGen(lset, iter)
-	return true
+	if len(gen) != 1 {
+		return nil, errors.Errorf("family %s: base series of %s type generates %d series, relation requires one", series.Family, base.Type, len(gen))
+	}
+	derived := seriesgen.NewSeriesGen(lset, seriesgen.NewRelationIterator(rand.New(rand.NewSource(seed)), gen[0].Iterator(), series.Relation))
+	mint, maxt, opts = life.Window(series.MinTime, series.MaxTime, series.Characteristics)
+	return series.Type.Wrap([]seriesgen.Series{derived}, seed, mint, maxt, opts), nil
 }
 
 func (s *blockSeriesSet) At() seriesgen.Series { return s.curr }
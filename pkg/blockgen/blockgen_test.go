@@ -1 +1,735 @@
 package blockgen
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	promMetadata "github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanosbench/pkg/seriesgen"
+)
+
+// readBlock returns number of samples of given block per metric name and value type.
+func readBlock(t *testing.T, dir string) map[string]map[chunkenc.ValueType]int {
+	t.Helper()
+
+	b, err := tsdb.OpenBlock(log.NewNopLogger(), dir, nil)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, b.Close()) }()
+
+	q, err := tsdb.NewBlockQuerier(b, b.MinTime(), b.MaxTime())
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res := map[string]map[chunkenc.ValueType]int{}
+	set := q.Select(false, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+	for set.Next() {
+		s := set.At()
+		name := s.Labels().Get(labels.MetricName)
+		if res[name] == nil {
+			res[name] = map[chunkenc.ValueType]int{}
+		}
+		it := s.Iterator(nil)
+		for typ := it.Next(); typ != chunkenc.ValNone; typ = it.Next() {
+			res[name][typ]++
+		}
+		testutil.Ok(t, it.Err())
+	}
+	testutil.Ok(t, set.Err())
+	return res
+}
+
+func testBlockSpec(series ...SeriesSpec) BlockSpec {
+	for i := range series {
+		series[i].MinTime = 0
+		series[i].MaxTime = durToMilis(2*time.Hour) - 1
+		series[i].ScrapeInterval = 15 * time.Second
+	}
+	return BlockSpec{
+		Meta: metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{MinTime: 0, MaxTime: durToMilis(2 * time.Hour)},
+			Thanos:    metadata.Thanos{Labels: map[string]string{"cluster": "test"}, Source: "blockgen"},
+		},
+		Series: series,
+	}
+}
+
+func TestGenerate_NativeHistograms(t *testing.T) {
+	dir := t.TempDir()
+
+	ids, err := Generate(context.Background(), log.NewNopLogger(), 2, dir, testBlockSpec(
+		SeriesSpec{
+			Labels:          labels.FromStrings(labels.MetricName, "gauge"),
+			Targets:         2,
+			Type:            Gauge,
+			Characteristics: seriesgen.Characteristics{Min: 10, Max: 100},
+		},
+		SeriesSpec{
+			Labels:  labels.FromStrings(labels.MetricName, "int_histogram"),
+			Targets: 2,
+			Type:    NativeHistogram,
+			Characteristics: seriesgen.Characteristics{NativeHistogram: seriesgen.NativeHistogramCharacteristics{
+				Schema:       3,
+				Observations: seriesgen.Distribution{Type: seriesgen.Exponential, Mean: 1},
+			}},
+		},
+		SeriesSpec{
+			Labels:  labels.FromStrings(labels.MetricName, "float_histogram"),
+			Targets: 2,
+			Type:    NativeHistogram,
+			Characteristics: seriesgen.Characteristics{NativeHistogram: seriesgen.NativeHistogramCharacteristics{
+				Schema:       0,
+				Float:        true,
+				Observations: seriesgen.Distribution{Type: seriesgen.Normal, Mean: 0, StdDev: 5},
+			}},
+		},
+	))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(ids))
+
+	samples := int((2 * time.Hour) / (15 * time.Second))
+	testutil.Equals(t, map[string]map[chunkenc.ValueType]int{
+		"gauge":           {chunkenc.ValFloat: 2 * samples},
+		"int_histogram":   {chunkenc.ValHistogram: 2 * samples},
+		"float_histogram": {chunkenc.ValFloatHistogram: 2 * samples},
+	}, readBlock(t, filepath.Join(dir, ids[0].String())))
+}
+
+func TestGenerate_OutOfOrder(t *testing.T) {
+	dir := t.TempDir()
+
+	ids, err := Generate(context.Background(), log.NewNopLogger(), 2, dir, testBlockSpec(
+		SeriesSpec{
+			Labels:  labels.FromStrings(labels.MetricName, "counter"),
+			Targets: 10,
+			Type:    Counter,
+			Characteristics: seriesgen.Characteristics{
+				Min:        10,
+				Max:        100,
+				OutOfOrder: seriesgen.OutOfOrder{Fraction: 0.1, Window: 10 * time.Minute},
+			},
+		},
+	))
+	testutil.Ok(t, err)
+	// Block with in-order samples and one overlapping block with out-of-order samples, as Prometheus does.
+	testutil.Equals(t, 2, len(ids))
+
+	inOrder, err := metadata.ReadFromDir(filepath.Join(dir, ids[0].String()))
+	testutil.Ok(t, err)
+	testutil.Assert(t, !inOrder.Compaction.FromOutOfOrder(), "first block should contain in-order samples")
+
+	ooo, err := metadata.ReadFromDir(filepath.Join(dir, ids[1].String()))
+	testutil.Ok(t, err)
+	testutil.Assert(t, ooo.Compaction.FromOutOfOrder(), "second block should contain out-of-order samples")
+	testutil.Equals(t, "test", ooo.Thanos.Labels["cluster"])
+	testutil.Assert(t, ooo.MinTime < inOrder.MaxTime && inOrder.MinTime < ooo.MaxTime, "blocks should overlap")
+
+	inOrderSamples := readBlock(t, filepath.Join(dir, ids[0].String()))["counter"][chunkenc.ValFloat]
+	oooSamples := readBlock(t, filepath.Join(dir, ids[1].String()))["counter"][chunkenc.ValFloat]
+	testutil.Assert(t, oooSamples > 0, "expected out-of-order samples")
+	testutil.Equals(t, 10*int((2*time.Hour)/(15*time.Second)), inOrderSamples+oooSamples)
+}
+
+func TestBlockWriter_OutOfOrder(t *testing.T) {
+	lset := labels.FromStrings(labels.MetricName, "a")
+
+	// Writer of specs without out-of-order samples rejects them, so generator bugs are not hidden in OOO blocks.
+	w, err := NewTSDBBlockWriter(log.NewNopLogger(), t.TempDir())
+	testutil.Ok(t, err)
+	app := w.Appender(context.Background())
+	_, err = app.Append(0, lset, 2000, 1)
+	testutil.Ok(t, err)
+	testutil.Ok(t, app.Commit())
+	app = w.Appender(context.Background())
+	_, err = app.Append(0, lset, 1000, 1)
+	testutil.Equals(t, storage.ErrOutOfOrderSample, err)
+	testutil.Ok(t, app.Rollback())
+
+	w, err = NewTSDBBlockWriterWithOutOfOrder(log.NewNopLogger(), t.TempDir())
+	testutil.Ok(t, err)
+	app = w.Appender(context.Background())
+	_, err = app.Append(0, lset, 2000, 1)
+	testutil.Ok(t, err)
+	testutil.Ok(t, app.Commit())
+	app = w.Appender(context.Background())
+	_, err = app.Append(0, lset, 1000, 1)
+	testutil.Ok(t, err)
+	testutil.Ok(t, app.Commit())
+	ids, err := w.Flush()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(ids))
+}
+
+func TestGenType_CreateSeries_Metadata(t *testing.T) {
+	series, err := Histogram.CreateSeries(labels.FromStrings(labels.MetricName, "latency"), 1, 0, 60000, seriesgen.Characteristics{
+		ScrapeInterval: 15 * time.Second,
+		Unit:           "seconds",
+		Gaps:           seriesgen.Gaps{StaleAtEnd: true},
+	})
+	testutil.Ok(t, err)
+	for _, s := range series {
+		ms, ok := s.(seriesgen.MetadataSeries)
+		testutil.Assert(t, ok, "series should carry metadata")
+		testutil.Equals(t, promMetadata.Metadata{
+			Type: textparse.MetricTypeHistogram,
+			Help: "Artificial histogram series generated by thanosbench.",
+			Unit: "seconds",
+		}, ms.Metadata())
+	}
+
+	series, err = Counter.CreateSeries(labels.FromStrings(labels.MetricName, "requests"), 1, 0, 60000, seriesgen.Characteristics{
+		ScrapeInterval: 15 * time.Second,
+		Help:           "Requests handled.",
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(series))
+	testutil.Equals(t, promMetadata.Metadata{Type: textparse.MetricTypeCounter, Help: "Requests handled."}, series[0].(seriesgen.MetadataSeries).Metadata())
+}
+
+func TestGenerate_Replay(t *testing.T) {
+	dir := t.TempDir()
+	recording := filepath.Join(t.TempDir(), "recording.json")
+	testutil.Ok(t, os.WriteFile(recording, []byte(`{"resultType":"matrix","result":[
+		{"metric":{"__name__":"a","pod":"1"},"values":[[1600000000,"1"],[1600000030,"2"],[1600000060,"3"]]},
+		{"metric":{"__name__":"a","pod":"2"},"values":[[1600000000,"10"],[1600000030,"20"],[1600000060,"30"]]}
+	]}`), 0600))
+
+	_, err := Replay.CreateSeries(labels.FromStrings(labels.MetricName, "replayed"), 1, 0, 1000, seriesgen.Characteristics{})
+	testutil.NotOk(t, err)
+
+	spec := testBlockSpec(
+		SeriesSpec{
+			Labels:  labels.FromStrings(labels.MetricName, "replayed"),
+			Targets: 4,
+			Type:    Replay,
+			Characteristics: seriesgen.Characteristics{
+				Replay: seriesgen.ReplayCharacteristics{File: recording, Shift: true, Loop: true},
+			},
+		},
+	)
+	ids, err := Generate(context.Background(), log.NewNopLogger(), 2, dir, spec)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(ids))
+
+	// Recording is looped every 90s across the whole block.
+	testutil.Equals(t, map[string]map[chunkenc.ValueType]int{
+		"replayed": {chunkenc.ValFloat: 4 * int((2*time.Hour)/(30*time.Second))},
+	}, readBlock(t, filepath.Join(dir, ids[0].String())))
+
+	// Targets replay recorded series in turn.
+	first := map[string]float64{}
+	set := &blockSeriesSet{config: spec}
+	for set.Next() {
+		it := set.At().Iterator()
+		testutil.Assert(t, it.Next(), "expected samples")
+		_, v := it.At()
+		first[set.At().Labels().Get("__blockgen_target__")] = v
+	}
+	testutil.Ok(t, set.Err())
+	testutil.Equals(t, map[string]float64{"4": 1, "3": 10, "2": 1, "1": 10}, first)
+}
+
+func TestGenerate_BytesPerSample(t *testing.T) {
+	_, err := Counter.CreateSeries(labels.FromStrings(labels.MetricName, "counter"), 1, 0, 1000, seriesgen.Characteristics{
+		ScrapeInterval: 15 * time.Second,
+		BytesPerSample: 1,
+	})
+	testutil.NotOk(t, err)
+
+	for _, target := range []float64{1.3, 4} {
+		dir := t.TempDir()
+		ids, err := Generate(context.Background(), log.NewNopLogger(), 2, dir, testBlockSpec(
+			SeriesSpec{
+				Labels:  labels.FromStrings(labels.MetricName, "gauge"),
+				Targets: 20,
+				Type:    Gauge,
+				Characteristics: seriesgen.Characteristics{
+					Min:            100,
+					Max:            200,
+					BytesPerSample: target,
+				},
+			},
+		))
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, len(ids))
+
+		got, err := BytesPerSample(log.NewNopLogger(), filepath.Join(dir, ids[0].String()))
+		testutil.Ok(t, err)
+		testutil.Assert(t, math.Abs(got-target) < 0.1*target, "bytes per sample %v too far from target %v", got, target)
+	}
+}
+
+func TestGenerate_SpecialValues(t *testing.T) {
+	dir := t.TempDir()
+	spec := testBlockSpec(
+		SeriesSpec{
+			Labels:  labels.FromStrings(labels.MetricName, "gauge"),
+			Targets: 5,
+			Type:    Gauge,
+			Characteristics: seriesgen.Characteristics{
+				Min:           10,
+				Max:           100,
+				SpecialValues: seriesgen.SpecialValues{Probability: 0.2},
+			},
+		},
+		SeriesSpec{
+			Labels:  labels.FromStrings(labels.MetricName, "counter"),
+			Targets: 5,
+			Type:    Counter,
+			Characteristics: seriesgen.Characteristics{
+				Min:           10,
+				Max:           100,
+				SpecialValues: seriesgen.SpecialValues{Probability: 0.05, Kinds: []seriesgen.SpecialValue{seriesgen.PosInf, seriesgen.NegZero}},
+			},
+		},
+	)
+	ids, err := Generate(context.Background(), log.NewNopLogger(), 2, dir, spec)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(ids))
+
+	// Generated series are the same for the same spec, so expected samples can be generated again.
+	expected := map[string][]uint64{}
+	set := &blockSeriesSet{config: spec, extLset: labels.FromStrings("cluster", "test")}
+	for set.Next() {
+		s := set.At()
+		it := s.Iterator()
+		for it.Next() {
+			_, v := it.At()
+			expected[s.Labels().String()] = append(expected[s.Labels().String()], math.Float64bits(v))
+		}
+		testutil.Ok(t, it.Err())
+	}
+	testutil.Ok(t, set.Err())
+
+	b, err := tsdb.OpenBlock(log.NewNopLogger(), filepath.Join(dir, ids[0].String()), nil)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, b.Close()) }()
+
+	q, err := tsdb.NewBlockQuerier(b, b.MinTime(), b.MaxTime())
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	got := map[string][]uint64{}
+	special := 0
+	ss := q.Select(false, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+	for ss.Next() {
+		s := ss.At()
+		it := s.Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			_, v := it.At()
+			if math.IsNaN(v) || math.IsInf(v, 0) || (v == 0 && math.Signbit(v)) {
+				special++
+			}
+			got[s.Labels().String()] = append(got[s.Labels().String()], math.Float64bits(v))
+		}
+		testutil.Ok(t, it.Err())
+	}
+	testutil.Ok(t, ss.Err())
+
+	testutil.Assert(t, special > 0, "expected special values in the block")
+	testutil.Equals(t, expected, got)
+}
+
+func TestBlockSeriesSet_Family(t *testing.T) {
+	spec := testBlockSpec(
+		SeriesSpec{
+			Labels:          labels.FromStrings(labels.MetricName, "usage"),
+			Targets:         3,
+			Family:          "pod",
+			Type:            Gauge,
+			Characteristics: seriesgen.Characteristics{Min: 100, Max: 200, Jitter: 20, ChangeInterval: time.Minute},
+		},
+		SeriesSpec{
+			Labels:   labels.FromStrings(labels.MetricName, "limit"),
+			Targets:  3,
+			Family:   "pod",
+			Type:     Gauge,
+			Relation: seriesgen.Relation{Min: 1.5, Max: 2},
+		},
+		SeriesSpec{
+			Labels:  labels.FromStrings(labels.MetricName, "pod_info", "node", "a"),
+			Targets: 3,
+			Family:  "pod",
+			Type:    Info,
+		},
+		SeriesSpec{
+			Labels:  labels.FromStrings(labels.MetricName, "other"),
+			Targets: 2,
+			Type:    Counter,
+		},
+	)
+
+	var (
+		order  []string
+		values = map[string]map[string][]float64{}
+	)
+	set := &blockSeriesSet{config: spec}
+	for set.Next() {
+		s := set.At()
+		name, target := s.Labels().Get(labels.MetricName), s.Labels().Get("__blockgen_target__")
+		order = append(order, name+"/"+target)
+
+		if values[target] == nil {
+			values[target] = map[string][]float64{}
+		}
+		for it := s.Iterator(); it.Next(); {
+			_, v := it.At()
+			values[target][name] = append(values[target][name], v)
+		}
+	}
+	testutil.Ok(t, set.Err())
+
+	// Series of the family are generated together for every target.
+	testutil.Equals(t, []string{
+		"usage/3", "limit/3", "pod_info/3",
+		"usage/2", "limit/2", "pod_info/2",
+		"usage/1", "limit/1", "pod_info/1",
+		"other/2", "other/1",
+	}, order)
+
+	for _, target := range []string{"1", "2", "3"} {
+		usage, limit := values[target]["usage"], values[target]["limit"]
+		testutil.Equals(t, len(usage), len(limit))
+		ratio := limit[0] / usage[0]
+		testutil.Assert(t, ratio >= 1.5 && ratio <= 2, "ratio %v out of bounds", ratio)
+		for i := range usage {
+			testutil.Assert(t, math.Abs(limit[i]/usage[i]-ratio) < 1e-9, "ratio should be constant")
+		}
+		for _, v := range values[target]["pod_info"] {
+			testutil.Equals(t, 1.0, v)
+		}
+	}
+
+	spec.Series[1].Targets = 2
+	set = &blockSeriesSet{config: spec}
+	for set.Next() {
+	}
+	testutil.NotOk(t, set.Err())
+}
+
+func TestBlockSeriesSet_FamilyBaseCharacteristics(t *testing.T) {
+	spec := testBlockSpec(
+		SeriesSpec{
+			Labels:  labels.FromStrings(labels.MetricName, "usage"),
+			Targets: 1,
+			Family:  "pod",
+			Type:    Gauge,
+			Characteristics: seriesgen.Characteristics{
+				Min: 100, Max: 200, Jitter: 20, ChangeInterval: time.Minute,
+				Gaps:      seriesgen.Gaps{ScrapeFailureProbability: 0.3},
+				Exemplars: seriesgen.Exemplars{Rate: 1},
+			},
+		},
+		SeriesSpec{
+			Labels:   labels.FromStrings(labels.MetricName, "limit"),
+			Targets:  1,
+			Family:   "pod",
+			Type:     Gauge,
+			Relation: seriesgen.Relation{Min: 2, Max: 2},
+		},
+	)
+
+	samples := map[string]map[int64]float64{}
+	exemplars := map[string]int{}
+	set := &blockSeriesSet{config: spec}
+	for set.Next() {
+		s := set.At()
+		name := s.Labels().Get(labels.MetricName)
+		samples[name] = map[int64]float64{}
+		it := s.Iterator()
+		eit, _ := it.(seriesgen.ExemplarSeriesIterator)
+		for it.Next() {
+			ts, v := it.At()
+			samples[name][ts] = v
+			if eit != nil {
+				if _, ok := eit.AtExemplar(); ok {
+					exemplars[name]++
+				}
+			}
+		}
+		testutil.Ok(t, it.Err())
+	}
+	testutil.Ok(t, set.Err())
+
+	// Derived series follows values of the base, but not its gaps and exemplars.
+	testutil.Equals(t, 480, len(samples["limit"]))
+	testutil.Assert(t, len(samples["usage"]) < 480, "expected gaps in base series")
+	testutil.Assert(t, exemplars["usage"] > 0, "expected exemplars of base series")
+	testutil.Equals(t, 0, exemplars["limit"])
+	for ts, v := range samples["usage"] {
+		testutil.Assert(t, math.Abs(samples["limit"][ts]-2*v) < 1e-9, "limit at %v should be twice the usage", ts)
+	}
+}
+
+func TestBlockSeriesSet_TargetLabels(t *testing.T) {
+	spec := testBlockSpec(
+		SeriesSpec{
+			Labels:  labels.FromStrings(labels.MetricName, "usage"),
+			Targets: 300,
+			Family:  "pod",
+			Type:    Gauge,
+			TargetLabels: []TargetLabel{
+				{Name: "instance", Template: "10.0.{{ div .i 256 }}.{{ mod .i 256 }}:9100"},
+				{Name: "pod", Template: "app-{{ hash 5 }}"},
+				{Name: "namespace", Values: []string{"default", "monitoring", "kube-system"}, ZipfExponent: 2},
+			},
+			Characteristics: seriesgen.Characteristics{Min: 100, Max: 200},
+		},
+		SeriesSpec{
+			Labels:  labels.FromStrings(labels.MetricName, "limit"),
+			Targets: 300,
+			Family:  "pod",
+			Type:    Gauge,
+			// Ignored, series of the family use target labels of the first series.
+			TargetLabels:    []TargetLabel{{Name: "ignored", Values: []string{"a"}}},
+			Characteristics: seriesgen.Characteristics{Min: 100, Max: 200},
+		},
+	)
+
+	read := func() []labels.Labels {
+		var res []labels.Labels
+		set := &blockSeriesSet{config: spec}
+		for set.Next() {
+			res = append(res, set.At().Labels())
+		}
+		testutil.Ok(t, set.Err())
+		return res
+	}
+
+	lsets := read()
+	testutil.Equals(t, 600, len(lsets))
+	// Target labels are stable.
+	testutil.Equals(t, lsets, read())
+
+	var (
+		pods       = map[string]struct{}{}
+		namespaces = map[string]int{}
+	)
+	testutil.Equals(t, labels.FromStrings(labels.MetricName, "usage", "instance", "10.0.0.0:9100", "namespace", lsets[0].Get("namespace"), "pod", lsets[0].Get("pod")), lsets[0])
+	testutil.Equals(t, "10.0.1.43:9100", lsets[598].Get("instance"))
+	for i := 0; i < len(lsets); i += 2 {
+		usage, limit := lsets[i], lsets[i+1]
+		testutil.Equals(t, "limit", limit.Get(labels.MetricName))
+		testutil.Equals(t, usage.Get("instance"), limit.Get("instance"))
+		testutil.Equals(t, usage.Get("pod"), limit.Get("pod"))
+		testutil.Equals(t, usage.Get("namespace"), limit.Get("namespace"))
+		testutil.Equals(t, "", limit.Get("ignored"))
+		testutil.Equals(t, "", usage.Get("__blockgen_target__"))
+
+		testutil.Assert(t, regexp.MustCompile("^app-[bcdfghjklmnpqrstvwxz2456789]{5}$").MatchString(usage.Get("pod")), "unexpected pod %v", usage.Get("pod"))
+		pods[usage.Get("pod")] = struct{}{}
+		namespaces[usage.Get("namespace")]++
+	}
+	testutil.Assert(t, len(pods) > 295, "pods should be random, got %v distinct", len(pods))
+	testutil.Assert(t, namespaces["default"] > namespaces["monitoring"] && namespaces["monitoring"] > namespaces["kube-system"], "unexpected namespaces %v", namespaces)
+
+	g, err := newTargetLabelsGen([]TargetLabel{{Name: "instance", Template: "{{ .unknown }}"}})
+	testutil.Ok(t, err)
+	_, err = g.Labels(1, 0)
+	testutil.NotOk(t, err)
+	_, err = newTargetLabelsGen([]TargetLabel{{Name: "instance", Template: "{{ div .i }"}})
+	testutil.NotOk(t, err)
+	_, err = newTargetLabelsGen([]TargetLabel{{Name: "namespace"}})
+	testutil.NotOk(t, err)
+	_, err = newTargetLabelsGen([]TargetLabel{{Name: "pod", Template: "a-{{ .i }}"}, {Name: "pod", Values: []string{"b"}}})
+	testutil.NotOk(t, err)
+}
+
+func TestBlockSeriesSet_Cardinality(t *testing.T) {
+	read := func(spec BlockSpec) ([]labels.Labels, error) {
+		var res []labels.Labels
+		set := &blockSeriesSet{config: spec}
+		for set.Next() {
+			res = append(res, set.At().Labels())
+		}
+		return res, set.Err()
+	}
+
+	t.Run("cross product", func(t *testing.T) {
+		lsets, err := read(testBlockSpec(SeriesSpec{
+			Labels:  labels.FromStrings(labels.MetricName, "requests_total"),
+			Targets: 2,
+			Type:    Counter,
+			Cardinality: CardinalityModel{Labels: []CardinalityLabel{
+				{Name: "code", Values: 3},
+				{Name: "path", Values: 2, Length: 12},
+			}},
+			Characteristics: seriesgen.Characteristics{Min: 100, Max: 200},
+		}))
+		testutil.Ok(t, err)
+		testutil.Equals(t, 12, len(lsets))
+
+		distinct := map[string]struct{}{}
+		for _, lset := range lsets {
+			distinct[lset.String()] = struct{}{}
+			testutil.Equals(t, 12, len(lset.Get("path")))
+			testutil.Assert(t, regexp.MustCompile("^path-[01]-[a-z0-9]{5}$").MatchString(lset.Get("path")), "unexpected path %v", lset.Get("path"))
+		}
+		testutil.Equals(t, 12, len(distinct))
+		testutil.Equals(t, "code-0", lsets[0].Get("code"))
+		testutil.Equals(t, "code-2", lsets[5].Get("code"))
+		testutil.Equals(t, lsets[0].Get("__blockgen_target__"), lsets[5].Get("__blockgen_target__"))
+		testutil.Assert(t, lsets[5].Get("__blockgen_target__") != lsets[6].Get("__blockgen_target__"), "second target expected")
+	})
+
+	t.Run("sampled", func(t *testing.T) {
+		spec := testBlockSpec(SeriesSpec{
+			Labels:  labels.FromStrings(labels.MetricName, "requests_total"),
+			Targets: 1,
+			Type:    Counter,
+			Cardinality: CardinalityModel{
+				Labels: []CardinalityLabel{
+					{Name: "user", Values: 100_000, ZipfExponent: 1.5},
+					{Name: "code", Values: 5},
+				},
+				MaxSeries: 500,
+			},
+			Characteristics: seriesgen.Characteristics{Min: 100, Max: 200},
+		})
+		lsets, err := read(spec)
+		testutil.Ok(t, err)
+		testutil.Assert(t, len(lsets) > 0 && len(lsets) <= 500, "unexpected number of series %v", len(lsets))
+
+		// Sampled combinations are stable.
+		again, err := read(spec)
+		testutil.Ok(t, err)
+		testutil.Equals(t, lsets, again)
+
+		users := map[string]int{}
+		for _, lset := range lsets {
+			users[lset.Get("user")]++
+		}
+		// The most common users have all codes. Uniform users would be almost all distinct.
+		testutil.Equals(t, 5, users["user-0"])
+		testutil.Assert(t, len(users) < len(lsets)*3/4, "users should be skewed, got %v distinct", len(users))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := read(testBlockSpec(SeriesSpec{
+			Labels:  labels.FromStrings(labels.MetricName, "requests_total"),
+			Targets: 1,
+			Type:    Counter,
+			Cardinality: CardinalityModel{Labels: []CardinalityLabel{
+				{Name: "user", Values: 100_000},
+				{Name: "path", Values: 10_000},
+			}},
+		}))
+		testutil.NotOk(t, err)
+
+		_, err = newLabelCombinations(1, CardinalityModel{Labels: []CardinalityLabel{{Name: "code"}}})
+		testutil.NotOk(t, err)
+		_, err = newLabelCombinations(1, CardinalityModel{Labels: []CardinalityLabel{{Name: "code", Values: 2, ZipfExponent: 0.5}}})
+		testutil.NotOk(t, err)
+	})
+}
+
+func TestChurn_Lifetimes(t *testing.T) {
+	c := Churn{
+		Lifetime:           seriesgen.Distribution{Type: seriesgen.Exponential, Mean: 4 * 3600},
+		ShortLivedFraction: 0.5,
+		ShortLifetime:      seriesgen.Distribution{Min: 60, Max: 300},
+	}
+	testutil.Ok(t, c.Validate())
+
+	day := durToMilis(24 * time.Hour)
+	lives := c.Lifetimes(1, 0, 10*day, 15*time.Second)
+	testutil.Assert(t, len(lives) > 100, "expected churn, got %v lifetimes", len(lives))
+	testutil.Assert(t, lives[0].Start <= 0, "first lifetime has to cover mint, got %v", lives[0])
+
+	var short int
+	for i, l := range lives {
+		testutil.Assert(t, l.End > l.Start, "empty lifetime %v", l)
+		testutil.Assert(t, l.End-l.Start <= durToMilis(churnPeriod), "lifetime %v longer than period", l)
+		if i > 0 {
+			// Targets are replaced immediately.
+			testutil.Equals(t, lives[i-1].End, l.Start)
+		}
+		if l.End-l.Start <= durToMilis(5*time.Minute) {
+			short++
+		}
+	}
+	testutil.Assert(t, short > len(lives)/3, "expected around half short-lived targets, got %v of %v", short, len(lives))
+
+	// Lifetimes are the same regardless of the time range, e.g for consecutive blocks.
+	second := c.Lifetimes(1, 2*day, 3*day-1, 15*time.Second)
+	var expected []Lifetime
+	for _, l := range lives {
+		if l.End > 2*day && l.Start < 3*day {
+			expected = append(expected, l)
+		}
+	}
+	testutil.Equals(t, expected, second)
+
+	testutil.Equals(t, []Lifetime{foreverLifetime}, Churn{}.Lifetimes(1, 0, day, 15*time.Second))
+	testutil.NotOk(t, Churn{ShortLivedFraction: 0.1}.Validate())
+	testutil.NotOk(t, Churn{Lifetime: c.Lifetime, ShortLivedFraction: 0.1}.Validate())
+	testutil.NotOk(t, Churn{Lifetime: c.Lifetime, ShortLivedFraction: 2, ShortLifetime: c.ShortLifetime}.Validate())
+}
+
+func TestGenerate_Churn(t *testing.T) {
+	spec := testBlockSpec(SeriesSpec{
+		Labels:       labels.FromStrings(labels.MetricName, "cronjob_duration_seconds"),
+		Targets:      3,
+		Type:         Gauge,
+		TargetLabels: []TargetLabel{{Name: "pod", Template: "cronjob-{{ hash 5 }}"}},
+		Churn: Churn{
+			Lifetime:           seriesgen.Distribution{Min: 1800, Max: 3600},
+			ShortLivedFraction: 0.5,
+			ShortLifetime:      seriesgen.Distribution{Min: 60, Max: 300},
+		},
+		Characteristics: seriesgen.Characteristics{Min: 100, Max: 200},
+	})
+
+	dir := t.TempDir()
+	ids, err := Generate(context.Background(), log.NewNopLogger(), 2, dir, spec)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(ids))
+
+	b, err := tsdb.OpenBlock(log.NewNopLogger(), filepath.Join(dir, ids[0].String()), nil)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, b.Close()) }()
+	q, err := tsdb.NewBlockQuerier(b, b.MinTime(), b.MaxTime())
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	var (
+		pods    = map[string]struct{}{}
+		samples int
+		stale   int
+	)
+	set := q.Select(false, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "cronjob_duration_seconds"))
+	for set.Next() {
+		pods[set.At().Labels().Get("pod")] = struct{}{}
+		it := set.At().Iterator(nil)
+		var last float64
+		for it.Next() != chunkenc.ValNone {
+			_, last = it.At()
+			samples++
+		}
+		testutil.Ok(t, it.Err())
+		if value.IsStaleNaN(last) {
+			stale++
+		}
+	}
+	testutil.Ok(t, set.Err())
+
+	// Every target is replaced at least once within 2h, each time with a fresh pod.
+	testutil.Assert(t, len(pods) > 6, "expected churned pods, got %v", len(pods))
+	// Replaced pods end with staleness marker, except the last ones.
+	testutil.Assert(t, stale >= len(pods)-3, "expected %v stale series, got %v", len(pods)-3, stale)
+	// Churn doesn't change number of samples, as targets are replaced immediately.
+	testutil.Assert(t, samples >= 3*480 && samples <= 3*480+len(pods), "unexpected number of samples %v", samples)
+}
@@ -0,0 +1,160 @@
+package blockgen
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// CardinalityModel describes labels multiplying series of each target, e.g status codes, paths or user IDs.
+// All combinations of label values are generated (cross product), unless there are more than MaxSeries of them. Then
+// MaxSeries distinct combinations are sampled, with values of each label chosen according to its ZipfExponent.
+type CardinalityModel struct {
+	Labels []CardinalityLabel `yaml:"labels"`
+	// MaxSeries is the budget of series per target, unlimited if not set.
+	MaxSeries int `yaml:"maxSeries"`
+}
+
+// CardinalityLabel describes values of a single label.
+type CardinalityLabel struct {
+	Name string `yaml:"name"`
+	// Values is the number of distinct values.
+	Values int `yaml:"values"`
+	// Length is the minimum length of values. Values are "<name>-<index>", padded with random characters if shorter.
+	Length int `yaml:"length"`
+	// ZipfExponent skews sampled combinations, so the first values are the most common ones. It has to be greater
+	// than 1. Values are chosen uniformly if not set. Not used if all combinations are generated.
+	ZipfExponent float64 `yaml:"zipfExponent"`
+}
+
+// maxCrossProduct limits number of combinations generated without MaxSeries, as those are likely configuration errors.
+const maxCrossProduct = 100_000_000
+
+// labelCombinations are label sets generated by CardinalityModel. Nil labelCombinations has just one, empty
+// combination.
+type labelCombinations struct {
+	names  []string
+	values [][]string
+	// sampled are indexes of values of sampled combinations, one after another. All combinations are generated
+	// if nil.
+	sampled []int
+	n       int
+}
+
+func newLabelCombinations(seed int64, m CardinalityModel) (*labelCombinations, error) {
+	if len(m.Labels) == 0 {
+		return nil, nil
+	}
+
+	c := &labelCombinations{n: 1}
+	for _, l := range m.Labels {
+		if l.Name == "" {
+			return nil, errors.New("cardinality: label without name")
+		}
+		if l.Values <= 0 {
+			return nil, errors.Errorf("cardinality: label %s has to have at least one value", l.Name)
+		}
+		if l.ZipfExponent != 0 && l.ZipfExponent <= 1 {
+			return nil, errors.Errorf("cardinality: label %s zipf exponent has to be greater than 1, got %v", l.Name, l.ZipfExponent)
+		}
+		c.names = append(c.names, l.Name)
+		c.values = append(c.values, labelValues(l))
+
+		if c.n > math.MaxInt/l.Values {
+			c.n = math.MaxInt
+		} else {
+			c.n *= l.Values
+		}
+	}
+
+	if m.MaxSeries <= 0 || c.n <= m.MaxSeries {
+		if c.n > maxCrossProduct {
+			return nil, errors.Errorf("cardinality: cross product of %d series is too large, set maxSeries", c.n)
+		}
+		return c, nil
+	}
+
+	random := rand.New(rand.NewSource(seed))
+	zipfs := make([]*rand.Zipf, len(m.Labels))
+	for i, l := range m.Labels {
+		if l.ZipfExponent > 0 {
+			zipfs[i] = rand.NewZipf(random, l.ZipfExponent, 1, uint64(l.Values-1))
+		}
+	}
+
+	var (
+		seen = make(map[string]struct{}, m.MaxSeries)
+		comb = make([]int, len(m.Labels))
+		key  = make([]byte, 8*len(m.Labels))
+	)
+	// Skewed distributions may have less likely combinations than the budget, give up eventually.
+	for attempts := 0; len(seen) < m.MaxSeries && attempts < 10*m.MaxSeries; attempts++ {
+		for i, l := range m.Labels {
+			if zipfs[i] != nil {
+				comb[i] = int(zipfs[i].Uint64())
+			} else {
+				comb[i] = random.Intn(l.Values)
+			}
+			binary.LittleEndian.PutUint64(key[8*i:], uint64(comb[i]))
+		}
+		if _, ok := seen[string(key)]; ok {
+			continue
+		}
+		seen[string(key)] = struct{}{}
+		c.sampled = append(c.sampled, comb...)
+	}
+	c.n = len(seen)
+	return c, nil
+}
+
+// labelValues returns all values of the label.
+func labelValues(l CardinalityLabel) []string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+	values := make([]string, l.Values)
+	for i := range values {
+		v := fmt.Sprintf("%s-%d", l.Name, i)
+		if len(v)+1 < l.Length {
+			random := rand.New(rand.NewSource(int64(xxhash.Sum64String(v))))
+			b := make([]byte, l.Length)
+			copy(b, v+"-")
+			for j := len(v) + 1; j < len(b); j++ {
+				b[j] = alphabet[random.Intn(len(alphabet))]
+			}
+			v = string(b)
+		}
+		values[i] = v
+	}
+	return values
+}
+
+// Len returns number of combinations.
+func (c *labelCombinations) Len() int {
+	if c == nil {
+		return 1
+	}
+	return c.n
+}
+
+// Set sets labels of the i-th combination in the given builder.
+func (c *labelCombinations) Set(b *labels.Builder, i int) {
+	if c == nil {
+		return
+	}
+	if c.sampled != nil {
+		for j, v := range c.sampled[i*len(c.names) : (i+1)*len(c.names)] {
+			b.Set(c.names[j], c.values[j][v])
+		}
+		return
+	}
+	// Mixed radix, the last label changes the fastest.
+	for j := len(c.names) - 1; j >= 0; j-- {
+		b.Set(c.names[j], c.values[j][i%len(c.values[j])])
+		i /= len(c.values[j])
+	}
+}
@@ -0,0 +1,116 @@
+package blockgen
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanosbench/pkg/seriesgen"
+)
+
+// churnPeriod bounds lifetimes of targets. Lifetimes are drawn from per-target anchors aligned to the period, so the
+// same target is replaced at the same time in all blocks without drawing lifetimes since the beginning of time.
+const churnPeriod = 7 * 24 * time.Hour
+
+// Churn describes targets being replaced over time, e.g pods of rollouts, autoscaling or cronjobs. Every target lives
+// for a lifetime drawn from a distribution and is then immediately replaced by a new target with fresh label values.
+// Lifetimes are in seconds and are capped at a week.
+type Churn struct {
+	// Lifetime is distribution of lifetimes of targets.
+	Lifetime seriesgen.Distribution `yaml:"lifetime"`
+	// ShortLivedFraction is a fraction of targets living ShortLifetime instead, e.g cronjobs or batch pods.
+	ShortLivedFraction float64                `yaml:"shortLivedFraction"`
+	ShortLifetime      seriesgen.Distribution `yaml:"shortLifetime"`
+}
+
+// Enabled returns true if targets are replaced.
+func (c Churn) Enabled() bool {
+	return c.Lifetime != (seriesgen.Distribution{}) || c.ShortLivedFraction > 0
+}
+
+func (c Churn) Validate() error {
+	if c.Lifetime == (seriesgen.Distribution{}) {
+		return errors.New("churn: lifetime has to be specified")
+	}
+	if err := c.Lifetime.Validate(); err != nil {
+		return errors.Wrap(err, "churn: lifetime")
+	}
+	if c.ShortLivedFraction < 0 || c.ShortLivedFraction > 1 {
+		return errors.Errorf("churn: short-lived fraction %v out of [0, 1] range", c.ShortLivedFraction)
+	}
+	if c.ShortLivedFraction > 0 {
+		if c.ShortLifetime == (seriesgen.Distribution{}) {
+			return errors.New("churn: short lifetime has to be specified for short-lived targets")
+		}
+		if err := c.ShortLifetime.Validate(); err != nil {
+			return errors.Wrap(err, "churn: short lifetime")
+		}
+	}
+	return nil
+}
+
+// Lifetime is the time range [Start, End) of a single target.
+type Lifetime struct {
+	Start, End int64
+}
+
+// foreverLifetime is lifetime of targets without churn.
+var foreverLifetime = Lifetime{Start: math.MinInt64, End: math.MaxInt64}
+
+// Lifetimes returns lifetimes of the target overlapping [mint, maxt], in order. Lifetimes are stable for the seed,
+// regardless of mint and maxt. Lifetimes are not shorter than the scrape interval.
+func (c Churn) Lifetimes(seed int64, mint, maxt int64, interval time.Duration) []Lifetime {
+	if !c.Enabled() {
+		return []Lifetime{foreverLifetime}
+	}
+
+	period := churnPeriod.Milliseconds()
+	// Targets are not replaced all at once at period boundaries.
+	offset := rand.New(rand.NewSource(seed)).Int63n(period)
+	anchor := mint - ((mint-offset)%period+period)%period
+
+	minLife := interval.Milliseconds()
+	if minLife < 1 {
+		minLife = 1
+	}
+
+	var res []Lifetime
+	for ; anchor <= maxt; anchor += period {
+		random := rand.New(rand.NewSource(seed ^ anchor))
+		for start := anchor; start < anchor+period && start <= maxt; {
+			// Always draw the same number of random numbers, so lifetimes don't depend on the fraction.
+			short := random.Float64() < c.ShortLivedFraction
+			life := c.Lifetime.Sample(random)
+			if short {
+				life = c.ShortLifetime.Sample(random)
+			}
+
+			end := start + minLife
+			if ms := int64(life * 1000); ms > minLife {
+				end = start + ms
+			}
+			if end > anchor+period {
+				end = anchor + period
+			}
+			if end > mint {
+				res = append(res, Lifetime{Start: start, End: end})
+			}
+			start = end
+		}
+	}
+	return res
+}
+
+// Window returns time range and characteristics of series of the target within [mint, maxt]. Series of a target
+// replaced within the range end with a staleness marker, as Prometheus does when target disappears.
+func (l Lifetime) Window(mint, maxt int64, opts seriesgen.Characteristics) (int64, int64, seriesgen.Characteristics) {
+	if l.Start > mint {
+		mint = l.Start
+	}
+	if l.End <= maxt {
+		maxt = l.End - 1
+		opts.Gaps.StaleAtEnd = true
+	}
+	return mint, maxt, opts
+}
@@ -201,6 +201,8 @@ func realisticK8s(ranges []time.Duration, rolloutInterval time.Duration, apps in
 					}
 					s.MinTime = smint
 					s.MaxTime = smaxt
+					// Series disappears on rollout, unless it continues in the next block.
+					s.Gaps.StaleAtEnd = lastRollout+durToMilis(rolloutInterval) <= maxt
 					b.Series = append(b.Series, s)
 				}
 
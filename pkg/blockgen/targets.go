@@ -0,0 +1,132 @@
+package blockgen
+
+import (
+	"math/rand"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// TargetLabel is a label added to all series of a target, e.g instance or pod, instead of __blockgen_target__.
+// Value is generated for every target from Template, or chosen randomly from Values.
+//
+// Template is executed with target index as .i, starting from 0. Besides standard functions, templates can use
+// integer arithmetic (add, sub, mul, div, mod) and hash, returning random alphanumeric string of given length stable
+// for the target, e.g:
+//
+//	instance: '10.0.{{ div .i 256 }}.{{ mod .i 256 }}:9100'
+//	pod: 'app-{{ hash 5 }}'
+type TargetLabel struct {
+	Name     string `yaml:"name"`
+	Template string `yaml:"template"`
+
+	Values []string `yaml:"values"`
+	// ZipfExponent skews choice of Values, so the first value is the most common one, e.g for namespaces. It has to
+	// be greater than 1. Values are chosen uniformly if not set.
+	ZipfExponent float64 `yaml:"zipfExponent"`
+}
+
+// hashAlphabet is alphabet of random suffixes of Kubernetes resource names.
+const hashAlphabet = "bcdfghjklmnpqrstvwxz2456789"
+
+var templateFuncs = template.FuncMap{
+	"add": func(a, b int) int { return a + b },
+	"sub": func(a, b int) int { return a - b },
+	"mul": func(a, b int) int { return a * b },
+	"div": func(a, b int) int { return a / b },
+	"mod": func(a, b int) int { return a % b },
+}
+
+// targetLabelsGen generates target labels. Zipf distributions and hash function of templates are bound to its
+// random once, which is seeded again for every target.
+type targetLabelsGen struct {
+	labels    []TargetLabel
+	templates []*template.Template
+	zipfs     []*rand.Zipf
+
+	random *rand.Rand
+}
+
+func newTargetLabelsGen(tls []TargetLabel) (*targetLabelsGen, error) {
+	g := &targetLabelsGen{labels: tls, random: rand.New(rand.NewSource(0))}
+	funcs := template.FuncMap{"hash": g.hash}
+	names := make(map[string]struct{}, len(tls))
+	for _, tl := range tls {
+		if tl.Name == "" {
+			return nil, errors.New("target label without name")
+		}
+		if _, ok := names[tl.Name]; ok {
+			return nil, errors.Errorf("duplicate target label %s", tl.Name)
+		}
+		names[tl.Name] = struct{}{}
+		if tl.Template == "" {
+			if len(tl.Values) == 0 {
+				return nil, errors.Errorf("target label %s: template or values have to be specified", tl.Name)
+			}
+			if tl.ZipfExponent != 0 && tl.ZipfExponent <= 1 {
+				return nil, errors.Errorf("target label %s: zipf exponent has to be greater than 1, got %v", tl.Name, tl.ZipfExponent)
+			}
+			var zipf *rand.Zipf
+			if tl.ZipfExponent > 0 {
+				zipf = rand.NewZipf(g.random, tl.ZipfExponent, 1, uint64(len(tl.Values)-1))
+			}
+			g.templates = append(g.templates, nil)
+			g.zipfs = append(g.zipfs, zipf)
+			continue
+		}
+
+		t, err := template.New(tl.Name).Funcs(templateFuncs).Funcs(funcs).Option("missingkey=error").Parse(tl.Template)
+		if err != nil {
+			return nil, errors.Wrapf(err, "target label %s: parse template", tl.Name)
+		}
+		g.templates = append(g.templates, t)
+		g.zipfs = append(g.zipfs, nil)
+	}
+	return g, nil
+}
+
+// hash returns random alphanumeric string of given length, e.g suffix of Kubernetes pod name.
+func (g *targetLabelsGen) hash(n int) string {
+	s := make([]byte, n)
+	for j := range s {
+		s[j] = hashAlphabet[g.random.Intn(len(hashAlphabet))]
+	}
+	return string(s)
+}
+
+// GenerateTargetLabels returns target labels of the i-th target. Random values are stable for the seed.
+func GenerateTargetLabels(tls []TargetLabel, seed int64, i int) (labels.Labels, error) {
+	g, err := newTargetLabelsGen(tls)
+	if err != nil {
+		return nil, err
+	}
+	return g.Labels(seed, i)
+}
+
+// Labels returns target labels of the i-th target. Random values are stable for the seed.
+func (g *targetLabelsGen) Labels(seed int64, i int) (labels.Labels, error) {
+	g.random.Seed(seed)
+	b := labels.NewScratchBuilder(len(g.labels))
+
+	var sb strings.Builder
+	for j, tl := range g.labels {
+		if g.templates[j] == nil {
+			if g.zipfs[j] != nil {
+				b.Add(tl.Name, tl.Values[g.zipfs[j].Uint64()])
+			} else {
+				b.Add(tl.Name, tl.Values[g.random.Intn(len(tl.Values))])
+			}
+			continue
+		}
+
+		sb.Reset()
+		if err := g.templates[j].Execute(&sb, map[string]interface{}{"i": i}); err != nil {
+			return nil, errors.Wrapf(err, "target label %s: execute template", tl.Name)
+		}
+		b.Add(tl.Name, sb.String())
+	}
+	b.Sort()
+	return b.Labels(), nil
+}
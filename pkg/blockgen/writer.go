@@ -3,6 +3,8 @@ package blockgen
 import (
 	"context"
 	"math"
+	"os"
+	"path/filepath"
 	"runtime"
 	"time"
 
@@ -15,6 +17,7 @@ import (
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
 )
 
 var _ Writer = &BlockWriter{}
@@ -26,6 +29,10 @@ type BlockWriter struct {
 
 	// dir is output directory, given to us as arg.
 	dir string
+	// chunkDir is temporary directory for head chunks, removed on Flush.
+	chunkDir string
+	// outOfOrder makes head accept out-of-order samples, otherwise they fail to append.
+	outOfOrder bool
 
 	head *tsdb.Head
 }
@@ -53,9 +60,20 @@ type BlockWriter struct {
 // contains anything at all. It is the caller's responsibility to
 // ensure that the resulting blocks do not overlap etc.
 func NewTSDBBlockWriter(logger log.Logger, dir string) (*BlockWriter, error) {
+	return newTSDBBlockWriter(logger, dir, false)
+}
+
+// NewTSDBBlockWriterWithOutOfOrder creates new TSDB block writer accepting out-of-order samples. Those are written
+// on Flush into separate blocks overlapping the in-order one, as Prometheus does.
+func NewTSDBBlockWriterWithOutOfOrder(logger log.Logger, dir string) (*BlockWriter, error) {
+	return newTSDBBlockWriter(logger, dir, true)
+}
+
+func newTSDBBlockWriter(logger log.Logger, dir string, outOfOrder bool) (*BlockWriter, error) {
 	res := &BlockWriter{
-		logger: logger,
-		dir:    dir,
+		logger:     logger,
+		dir:        dir,
+		outOfOrder: outOfOrder,
 	}
 
 	if err := res.initHeadAndAppender(); err != nil {
@@ -72,17 +90,26 @@ func (w *BlockWriter) Appender(ctx context.Context) storage.Appender {
 
 // Flush implements Writer interface. This is where actual block writing
 // happens. After flush completes, no write can be done.
-func (w *BlockWriter) Flush() (ulid.ULID, error) {
+func (w *BlockWriter) Flush() ([]ulid.ULID, error) {
 	id, err := w.writeHeadToDisk()
 	if err != nil {
-		return ulid.ULID{}, errors.Wrap(err, "writeHeadToDisk")
+		return nil, errors.Wrap(err, "writeHeadToDisk")
+	}
+
+	oooIDs, err := w.writeOOOHeadToDisk()
+	if err != nil {
+		return nil, errors.Wrap(err, "writeOOOHeadToDisk")
 	}
 
 	if err := w.head.Close(); err != nil {
-		return ulid.ULID{}, errors.Wrap(err, "close head")
+		return nil, errors.Wrap(err, "close head")
+	}
+
+	if err := os.RemoveAll(w.chunkDir); err != nil {
+		return nil, errors.Wrap(err, "remove head chunks dir")
 	}
 
-	return id, nil
+	return append([]ulid.ULID{id}, oooIDs...), nil
 }
 
 // initHeadAndAppender creates and initialises new head and appender.
@@ -99,7 +126,21 @@ func (w *BlockWriter) initHeadAndAppender() error {
 	// Since we don't have info about block size here, set it to large number.
 	opts := tsdb.DefaultHeadOptions()
 	opts.ChunkRange = durToMilis(9999 * time.Hour)
-	h, err := tsdb.NewHead(nil, logger, nil, opts, nil)
+	opts.EnableNativeHistograms.Store(true)
+	if w.outOfOrder {
+		// Accept any out-of-order sample, those are written into separate blocks on Flush.
+		opts.OutOfOrderTimeWindow.Store(opts.ChunkRange)
+	}
+
+	// Head memory-maps full chunks, give it a directory outside of the output one.
+	chunkDir, err := os.MkdirTemp("", "blockgen-head")
+	if err != nil {
+		return errors.Wrap(err, "create head chunks dir")
+	}
+	w.chunkDir = chunkDir
+	opts.ChunkDirRoot = chunkDir
+
+	h, err := tsdb.NewHead(nil, logger, nil, nil, opts, nil)
 	if err != nil {
 		return errors.Wrap(err, "tsdb.NewHead")
 	}
@@ -143,3 +184,58 @@ func (w *BlockWriter) writeHeadToDisk() (ulid.ULID, error) {
 
 	return compactor.Write(w.dir, w.head, mint, maxt+1, nil)
 }
+
+// writeOOOHeadToDisk writes out-of-order samples from the head to disk. Same as Prometheus, it creates a block per
+// each 2h range with any out-of-order sample, marked as created from out-of-order samples.
+func (w *BlockWriter) writeOOOHeadToDisk() ([]ulid.ULID, error) {
+	if !w.outOfOrder {
+		return nil, nil
+	}
+	oooHead, err := tsdb.NewOOOCompactionHead(w.head)
+	if err != nil {
+		return nil, errors.Wrap(err, "create ooo compaction head")
+	}
+	mint, maxt := oooHead.MinTime(), oooHead.MaxTime()
+	if mint > maxt {
+		// No out-of-order samples.
+		return nil, nil
+	}
+
+	blockSize := durToMilis(2 * time.Hour)
+	compactor, err := tsdb.NewLeveledCompactor(
+		context.Background(),
+		nil,
+		w.logger,
+		[]int64{blockSize},
+		chunkenc.NewPool(),
+		nil,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create leveled compactor")
+	}
+
+	var ids []ulid.ULID
+	for t := blockSize * (mint / blockSize); t <= maxt; t += blockSize {
+		// Block intervals are half-open, so the head range is one millisecond shorter.
+		id, err := compactor.Write(w.dir, oooHead.CloneForTimeRange(t, t+blockSize-1), t, t+blockSize, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "write ooo block")
+		}
+		if id == (ulid.ULID{}) {
+			continue
+		}
+
+		bdir := filepath.Join(w.dir, id.String())
+		meta, err := metadata.ReadFromDir(bdir)
+		if err != nil {
+			return nil, errors.Wrap(err, "meta read")
+		}
+		meta.Compaction.SetOutOfOrder()
+		if err := meta.WriteToDir(w.logger, bdir); err != nil {
+			return nil, errors.Wrap(err, "meta write")
+		}
+		ids = append(ids, id)
+	}
+	level.Info(w.logger).Log("msg", "flushed out-of-order samples", "blocks", len(ids))
+	return ids, nil
+}
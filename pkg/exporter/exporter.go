@@ -0,0 +1,457 @@
+package exporter
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-openapi/swag"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanosbench/pkg/blockgen"
+	"github.com/thanos-io/thanosbench/pkg/seriesgen"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// targetLabel replaces __blockgen_target__ label in exposed series, as double underscore labels are reserved.
+const targetLabel = "blockgen_target"
+
+// Config describes series served by the exporter.
+type Config struct {
+	// Series are generated the same way as series of blocks, MinTime and MaxTime are ignored. Units of series are not
+	// exposed as OpenMetrics UNIT, as metric families of client_model v0.4.0 have no unit. Remote write and OTLP
+	// still send them.
+	Series []blockgen.SeriesSpec `yaml:"series"`
+	// Window is the time range series are generated for at once. Series are generated again for the next window, so
+	// counters reset at window boundaries as if the exporter restarted. Defaults to 24h.
+	Window time.Duration `yaml:"window"`
+}
+
+// Exporter exposes the latest samples of generated series, advancing in wall-clock time, as Prometheus metrics.
+// It implements prometheus.Gatherer.
+type Exporter struct {
+	config  Config
+	extLset labels.Labels
+	now     func() time.Time
+
+	mtx       sync.Mutex
+	windowEnd int64
+	series    []*liveSeries
+	// units are units of metric families of the last gather.
+	units map[string]string
+}
+
+// New creates exporter of series described by given config. External labels only seed random generators, e.g so
+// exporters with different labels serve different values.
+func New(config Config, extLset labels.Labels) (*Exporter, error) {
+	return newExporter(config, extLset, time.Now)
+}
+
+// NewWithClock is like New, but values advance with the given clock instead of wall-clock time, e.g to gather values
+// of the past.
+func NewWithClock(config Config, extLset labels.Labels, now func() time.Time) (*Exporter, error) {
+	return newExporter(config, extLset, now)
+}
+
+func newExporter(config Config, extLset labels.Labels, now func() time.Time) (*Exporter, error) {
+	if config.Window == 0 {
+		config.Window = 24 * time.Hour
+	}
+	for _, s := range config.Series {
+		if s.ScrapeInterval <= 0 {
+			return nil, errors.Errorf("series %s: scrape interval has to be positive", s.Labels)
+		}
+	}
+	e := &Exporter{config: config, extLset: extLset, now: now}
+	if err := e.generate(timestamp.FromTime(e.now())); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// generate creates series for the window starting at mint.
+func (e *Exporter) generate(mint int64) error {
+	specs := make([]blockgen.SeriesSpec, len(e.config.Series))
+	for i, s := range e.config.Series {
+		s.MinTime = mint
+		s.MaxTime = mint + e.config.Window.Milliseconds() - 1
+		specs[i] = s
+	}
+
+	var series []*liveSeries
+	set := blockgen.NewSeriesSet(specs, e.extLset)
+	for set.Next() {
+		series = append(series, newLiveSeries(set.At()))
+	}
+	if err := set.Err(); err != nil {
+		return errors.Wrap(err, "generate series")
+	}
+	e.series = series
+	e.windowEnd = mint + e.config.Window.Milliseconds()
+	return nil
+}
+
+// WindowStart returns start of the current window, when all series started, e.g start of cumulative counters.
+func (e *Exporter) WindowStart() time.Time {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	return timestamp.Time(e.windowEnd - e.config.Window.Milliseconds())
+}
+
+// Handler returns HTTP handler serving metrics in Prometheus text, OpenMetrics or protobuf format, depending on
+// the Accept header.
+func (e *Exporter) Handler() http.Handler {
+	return promhttp.HandlerFor(e, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// Gather returns metric families with the latest samples of all series.
+func (e *Exporter) Gather() ([]*dto.MetricFamily, error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	now := timestamp.FromTime(e.now())
+	if now >= e.windowEnd {
+		mint := e.windowEnd
+		// Skip windows without scrapes.
+		for window := e.config.Window.Milliseconds(); now >= mint+window; {
+			mint += window
+		}
+		if err := e.generate(mint); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		families = map[string]*dto.MetricFamily{}
+		// metrics groups series of classic histograms and summaries, e.g buckets of the same histogram.
+		metrics = map[string]*dto.Metric{}
+		units   = map[string]string{}
+	)
+	for _, s := range e.series {
+		if err := s.advance(now); err != nil {
+			return nil, errors.Wrapf(err, "series %s", s.lset)
+		}
+		if !s.exposed() {
+			continue
+		}
+
+		name, suffix := familyName(s)
+		mf, ok := families[name]
+		if !ok {
+			mf = &dto.MetricFamily{Name: swag.String(name), Help: swag.String(s.meta.Help), Type: metricType(s.meta.Type).Enum()}
+			families[name] = mf
+			if s.meta.Unit != "" {
+				units[name] = s.meta.Unit
+			}
+		}
+
+		lbls := metricLabels(s.lset, mf.GetType(), suffix)
+		key := name + "\xff" + labelsKey(lbls)
+		m, ok := metrics[key]
+		if !ok {
+			m = &dto.Metric{Label: lbls}
+			metrics[key] = m
+			mf.Metric = append(mf.Metric, m)
+		}
+		s.fill(m, mf.GetType(), suffix)
+	}
+
+	res := make([]*dto.MetricFamily, 0, len(families))
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			if h := m.Histogram; h != nil {
+				sort.Slice(h.Bucket, func(i, j int) bool { return h.Bucket[i].GetUpperBound() < h.Bucket[j].GetUpperBound() })
+			}
+			if s := m.Summary; s != nil {
+				sort.Slice(s.Quantile, func(i, j int) bool { return s.Quantile[i].GetQuantile() < s.Quantile[j].GetQuantile() })
+			}
+		}
+		res = append(res, mf)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].GetName() < res[j].GetName() })
+	e.units = units
+	return res, nil
+}
+
+// Units returns units of metric families of the last gather by family name, as metric families of client_model
+// don't carry units.
+func (e *Exporter) Units() map[string]string {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	return e.units
+}
+
+func metricType(t textparse.MetricType) dto.MetricType {
+	switch t {
+	case textparse.MetricTypeCounter:
+		return dto.MetricType_COUNTER
+	case textparse.MetricTypeGauge, textparse.MetricTypeInfo:
+		// There is no info type in protobuf exposition, info metrics are gauges with value 1.
+		return dto.MetricType_GAUGE
+	case textparse.MetricTypeHistogram:
+		return dto.MetricType_HISTOGRAM
+	case textparse.MetricTypeSummary:
+		return dto.MetricType_SUMMARY
+	default:
+		return dto.MetricType_UNTYPED
+	}
+}
+
+// familyName returns name of the metric family of the series and suffix of the series within the family, e.g _bucket.
+func familyName(s *liveSeries) (string, string) {
+	name := s.lset.Get(labels.MetricName)
+
+	var suffixes []string
+	switch s.meta.Type {
+	case textparse.MetricTypeHistogram:
+		if s.typ == chunkenc.ValFloat {
+			suffixes = []string{"_bucket", "_sum", "_count"}
+		}
+	case textparse.MetricTypeSummary:
+		suffixes = []string{"_sum", "_count"}
+	}
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix), suffix
+		}
+	}
+	return name, ""
+}
+
+// metricLabels returns exposed labels of the series, without labels of histogram and summary components.
+func metricLabels(lset labels.Labels, typ dto.MetricType, suffix string) []*dto.LabelPair {
+	res := make([]*dto.LabelPair, 0, len(lset))
+	for _, l := range lset {
+		switch {
+		case l.Name == labels.MetricName:
+			continue
+		case l.Name == labels.BucketLabel && typ == dto.MetricType_HISTOGRAM && suffix == "_bucket":
+			continue
+		case l.Name == "quantile" && typ == dto.MetricType_SUMMARY && suffix == "":
+			continue
+		case l.Name == "__blockgen_target__":
+			l.Name = targetLabel
+		}
+		res = append(res, &dto.LabelPair{Name: swag.String(l.Name), Value: swag.String(l.Value)})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].GetName() < res[j].GetName() })
+	return res
+}
+
+func labelsKey(lbls []*dto.LabelPair) string {
+	var b strings.Builder
+	for _, l := range lbls {
+		b.WriteString(l.GetName())
+		b.WriteByte('\xff')
+		b.WriteString(l.GetValue())
+		b.WriteByte('\xff')
+	}
+	return b.String()
+}
+
+// liveSeries follows generated series in wall-clock time, keeping its latest sample.
+type liveSeries struct {
+	lset labels.Labels
+	meta metadata.Metadata
+
+	it  seriesgen.SeriesIterator
+	hit seriesgen.HistogramSeriesIterator
+	eit seriesgen.ExemplarSeriesIterator
+	// pending is true if iterator is at sample from the future, not exposed yet.
+	pending, done bool
+
+	// The latest sample, if any.
+	has bool
+	typ chunkenc.ValueType
+	v   float64
+	h   *histogram.Histogram
+	fh  *histogram.FloatHistogram
+	// The latest exemplar is exposed until the next one, as client libraries do.
+	ex    exemplar.Exemplar
+	hasEx bool
+}
+
+func newLiveSeries(s seriesgen.Series) *liveSeries {
+	it := s.Iterator()
+	ls := &liveSeries{lset: s.Labels(), it: it}
+	ls.hit, _ = it.(seriesgen.HistogramSeriesIterator)
+	ls.eit, _ = it.(seriesgen.ExemplarSeriesIterator)
+	if ms, ok := s.(seriesgen.MetadataSeries); ok {
+		ls.meta = ms.Metadata()
+	}
+	return ls
+}
+
+// advance moves series to the latest sample not after t.
+func (s *liveSeries) advance(t int64) error {
+	for !s.done {
+		if !s.pending {
+			if !s.it.Next() {
+				s.done = true
+				return s.it.Err()
+			}
+			s.pending = true
+		}
+		if st, _ := s.it.At(); st > t {
+			return nil
+		}
+		s.pending = false
+		s.load()
+	}
+	return nil
+}
+
+func (s *liveSeries) load() {
+	s.has = true
+	s.typ = chunkenc.ValFloat
+	if s.hit != nil {
+		s.typ = s.hit.ValueType()
+	}
+	switch s.typ {
+	case chunkenc.ValHistogram:
+		_, h := s.hit.AtHistogram()
+		s.h = h.Copy()
+	case chunkenc.ValFloatHistogram:
+		_, fh := s.hit.AtFloatHistogram()
+		s.fh = fh.Copy()
+	default:
+		_, s.v = s.it.At()
+	}
+	if s.eit != nil {
+		if ex, ok := s.eit.AtExemplar(); ok {
+			s.ex, s.hasEx = ex, true
+		}
+	}
+}
+
+// exposed returns true if series has a sample to expose. Series that disappeared, e.g replaced target, end with
+// staleness marker.
+func (s *liveSeries) exposed() bool {
+	if !s.has {
+		return false
+	}
+	// Staleness markers of native histograms are histograms with StaleNaN sum.
+	switch s.typ {
+	case chunkenc.ValHistogram:
+		return !value.IsStaleNaN(s.h.Sum)
+	case chunkenc.ValFloatHistogram:
+		return !value.IsStaleNaN(s.fh.Sum)
+	default:
+		return !value.IsStaleNaN(s.v)
+	}
+}
+
+func (s *liveSeries) exemplar() *dto.Exemplar {
+	if !s.hasEx {
+		return nil
+	}
+	e := &dto.Exemplar{Value: swag.Float64(s.ex.Value)}
+	for _, l := range s.ex.Labels {
+		e.Label = append(e.Label, &dto.LabelPair{Name: swag.String(l.Name), Value: swag.String(l.Value)})
+	}
+	if s.ex.HasTs {
+		e.Timestamp = timestamppb.New(timestamp.Time(s.ex.Ts))
+	}
+	return e
+}
+
+// fill sets the latest sample of the series in the metric of given type.
+func (s *liveSeries) fill(m *dto.Metric, typ dto.MetricType, suffix string) {
+	switch typ {
+	case dto.MetricType_COUNTER:
+		m.Counter = &dto.Counter{Value: swag.Float64(s.v), Exemplar: s.exemplar()}
+	case dto.MetricType_GAUGE:
+		m.Gauge = &dto.Gauge{Value: swag.Float64(s.v)}
+	case dto.MetricType_HISTOGRAM:
+		if m.Histogram == nil {
+			m.Histogram = &dto.Histogram{}
+		}
+		switch {
+		case s.typ == chunkenc.ValHistogram:
+			m.Histogram = nativeHistogram(s.h)
+		case s.typ == chunkenc.ValFloatHistogram:
+			m.Histogram = nativeFloatHistogram(s.fh)
+		case suffix == "_bucket":
+			le, _ := strconv.ParseFloat(s.lset.Get(labels.BucketLabel), 64)
+			m.Histogram.Bucket = append(m.Histogram.Bucket, &dto.Bucket{UpperBound: swag.Float64(le), CumulativeCount: swag.Uint64(count(s.v)), Exemplar: s.exemplar()})
+		case suffix == "_sum":
+			m.Histogram.SampleSum = swag.Float64(s.v)
+		case suffix == "_count":
+			m.Histogram.SampleCount = swag.Uint64(count(s.v))
+		}
+	case dto.MetricType_SUMMARY:
+		if m.Summary == nil {
+			m.Summary = &dto.Summary{}
+		}
+		switch suffix {
+		case "_sum":
+			m.Summary.SampleSum = swag.Float64(s.v)
+		case "_count":
+			m.Summary.SampleCount = swag.Uint64(count(s.v))
+		default:
+			q, _ := strconv.ParseFloat(s.lset.Get("quantile"), 64)
+			m.Summary.Quantile = append(m.Summary.Quantile, &dto.Quantile{Quantile: swag.Float64(q), Value: swag.Float64(s.v)})
+		}
+	default:
+		m.Untyped = &dto.Untyped{Value: swag.Float64(s.v)}
+	}
+}
+
+// count converts float count of observations to integer, as text format supports only integer counts.
+func count(v float64) uint64 {
+	if v < 0 || math.IsNaN(v) {
+		return 0
+	}
+	return uint64(math.Round(v))
+}
+
+func nativeHistogram(h *histogram.Histogram) *dto.Histogram {
+	return &dto.Histogram{
+		SampleCount:   swag.Uint64(h.Count),
+		SampleSum:     swag.Float64(h.Sum),
+		Schema:        swag.Int32(h.Schema),
+		ZeroThreshold: swag.Float64(h.ZeroThreshold),
+		ZeroCount:     swag.Uint64(h.ZeroCount),
+		PositiveSpan:  spans(h.PositiveSpans),
+		PositiveDelta: h.PositiveBuckets,
+		NegativeSpan:  spans(h.NegativeSpans),
+		NegativeDelta: h.NegativeBuckets,
+	}
+}
+
+func nativeFloatHistogram(fh *histogram.FloatHistogram) *dto.Histogram {
+	return &dto.Histogram{
+		SampleCountFloat: swag.Float64(fh.Count),
+		SampleSum:        swag.Float64(fh.Sum),
+		Schema:           swag.Int32(fh.Schema),
+		ZeroThreshold:    swag.Float64(fh.ZeroThreshold),
+		ZeroCountFloat:   swag.Float64(fh.ZeroCount),
+		PositiveSpan:     spans(fh.PositiveSpans),
+		PositiveCount:    fh.PositiveBuckets,
+		NegativeSpan:     spans(fh.NegativeSpans),
+		NegativeCount:    fh.NegativeBuckets,
+	}
+}
+
+func spans(ss []histogram.Span) []*dto.BucketSpan {
+	res := make([]*dto.BucketSpan, 0, len(ss))
+	for _, s := range ss {
+		res = append(res, &dto.BucketSpan{Offset: swag.Int32(s.Offset), Length: swag.Uint32(s.Length)})
+	}
+	return res
+}
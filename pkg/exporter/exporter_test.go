@@ -0,0 +1,260 @@
+package exporter
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/thanos-io/thanosbench/pkg/blockgen"
+	"github.com/thanos-io/thanosbench/pkg/seriesgen"
+)
+
+func testExporter(t *testing.T, now *time.Time) *Exporter {
+	t.Helper()
+
+	common := seriesgen.Characteristics{ScrapeInterval: 15 * time.Second, Min: 10, Max: 20}
+	withExemplars := common
+	withExemplars.Exemplars = seriesgen.Exemplars{Rate: 1}
+	native := common
+	native.NativeHistogram = seriesgen.NativeHistogramCharacteristics{Schema: 3, Observations: seriesgen.Distribution{Type: seriesgen.Exponential, Mean: 1}}
+
+	c := Config{
+		Series: []blockgen.SeriesSpec{
+			{Labels: labels.FromStrings(labels.MetricName, "requests_total"), Targets: 2, Type: blockgen.Counter, Characteristics: withExemplars},
+			{Labels: labels.FromStrings(labels.MetricName, "memory_bytes"), Targets: 2, Type: blockgen.Gauge, Characteristics: common},
+			{Labels: labels.FromStrings(labels.MetricName, "latency_seconds"), Targets: 2, Type: blockgen.Histogram, Characteristics: withExemplars},
+			{Labels: labels.FromStrings(labels.MetricName, "duration_seconds"), Targets: 1, Type: blockgen.Summary, Characteristics: common},
+			{Labels: labels.FromStrings(labels.MetricName, "size_bytes"), Targets: 1, Type: blockgen.NativeHistogram, Characteristics: native},
+		},
+		Window: time.Hour,
+	}
+	e, err := New(c, labels.FromStrings("seed", "test"))
+	testutil.Ok(t, err)
+	e.now = func() time.Time { return *now }
+	// Start window before the first scrape, so all series have samples, e.g gauges start with the second scrape.
+	testutil.Ok(t, e.generate(now.Add(-time.Minute).UnixMilli()))
+	return e
+}
+
+func TestExporter_Gather(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	e := testExporter(t, &now)
+
+	families := func() map[string]*dto.MetricFamily {
+		mfs, err := e.Gather()
+		testutil.Ok(t, err)
+		res := map[string]*dto.MetricFamily{}
+		for _, mf := range mfs {
+			res[mf.GetName()] = mf
+		}
+		return res
+	}
+
+	mfs := families()
+	testutil.Equals(t, 5, len(mfs))
+	testutil.Equals(t, dto.MetricType_COUNTER, mfs["requests_total"].GetType())
+	testutil.Equals(t, "Artificial counter series generated by thanosbench.", mfs["requests_total"].GetHelp())
+	testutil.Equals(t, 2, len(mfs["requests_total"].Metric))
+	testutil.Equals(t, "blockgen_target", mfs["requests_total"].Metric[0].Label[0].GetName())
+	testutil.Assert(t, mfs["requests_total"].Metric[0].Counter.Exemplar != nil, "expected exemplar")
+	testutil.Equals(t, dto.MetricType_GAUGE, mfs["memory_bytes"].GetType())
+
+	latency := mfs["latency_seconds"]
+	testutil.Equals(t, dto.MetricType_HISTOGRAM, latency.GetType())
+	testutil.Equals(t, 2, len(latency.Metric))
+	h := latency.Metric[0].Histogram
+	testutil.Equals(t, len(seriesgen.DefBuckets)+1, len(h.Bucket))
+	for i := 1; i < len(h.Bucket); i++ {
+		testutil.Assert(t, h.Bucket[i-1].GetUpperBound() < h.Bucket[i].GetUpperBound(), "buckets not sorted")
+	}
+	testutil.Equals(t, 1, len(latency.Metric[0].Label))
+
+	duration := mfs["duration_seconds"]
+	testutil.Equals(t, dto.MetricType_SUMMARY, duration.GetType())
+	testutil.Equals(t, 1, len(duration.Metric))
+	testutil.Equals(t, len(seriesgen.DefObjectives), len(duration.Metric[0].Summary.Quantile))
+
+	size := mfs["size_bytes"].Metric[0].Histogram
+	testutil.Equals(t, int32(3), size.GetSchema())
+	testutil.Assert(t, len(size.PositiveSpan) > 0, "expected native histogram buckets")
+
+	// Values advance in wall-clock time.
+	before := mfs["requests_total"].Metric[0].Counter.GetValue()
+	now = now.Add(5 * time.Minute)
+	mfs = families()
+	testutil.Assert(t, mfs["requests_total"].Metric[0].Counter.GetValue() > before, "counter should increase")
+	testutil.Assert(t, mfs["latency_seconds"].Metric[0].Histogram.GetSampleCount() > h.GetSampleCount(), "histogram count should increase")
+
+	// Series are generated again for the next window.
+	now = now.Add(3 * time.Hour)
+	mfs = families()
+	testutil.Equals(t, 5, len(mfs))
+	testutil.Assert(t, e.windowEnd > now.UnixMilli() && e.windowEnd-time.Hour.Milliseconds() <= now.UnixMilli(), "window should cover now")
+}
+
+func TestExporter_StaleNativeHistograms(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	opts := seriesgen.Characteristics{
+		ScrapeInterval: 15 * time.Second,
+		NativeHistogram: seriesgen.NativeHistogramCharacteristics{
+			Schema:       3,
+			Observations: seriesgen.Distribution{Type: seriesgen.Exponential, Mean: 1},
+		},
+		// Series end 5m after the window start with staleness marker.
+		Gaps: seriesgen.Gaps{Outages: []seriesgen.Outage{{Start: 5 * time.Minute, Duration: 2 * time.Hour}}, StalenessMarkers: true},
+	}
+	floatOpts := opts
+	floatOpts.NativeHistogram.Float = true
+
+	e, err := New(Config{
+		Series: []blockgen.SeriesSpec{
+			{Labels: labels.FromStrings(labels.MetricName, "size_bytes"), Targets: 1, Type: blockgen.NativeHistogram, Characteristics: opts},
+			{Labels: labels.FromStrings(labels.MetricName, "float_size_bytes"), Targets: 1, Type: blockgen.NativeHistogram, Characteristics: floatOpts},
+		},
+		Window: time.Hour,
+	}, labels.FromStrings("seed", "test"))
+	testutil.Ok(t, err)
+	e.now = func() time.Time { return now }
+	testutil.Ok(t, e.generate(now.Add(-time.Minute).UnixMilli()))
+
+	mfs, err := e.Gather()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(mfs))
+
+	now = now.Add(5 * time.Minute)
+	mfs, err = e.Gather()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(mfs))
+}
+
+func TestExporter_Handler(t *testing.T) {
+	now := time.Now()
+	e := testExporter(t, &now)
+
+	srv := httptest.NewServer(e.Handler())
+	defer srv.Close()
+
+	get := func(accept string) (string, []byte) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		testutil.Ok(t, err)
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		testutil.Ok(t, err)
+		defer resp.Body.Close()
+		testutil.Equals(t, http.StatusOK, resp.StatusCode)
+		b, err := io.ReadAll(resp.Body)
+		testutil.Ok(t, err)
+		return resp.Header.Get("Content-Type"), b
+	}
+
+	ct, b := get("text/plain")
+	testutil.Equals(t, string(expfmt.FmtText), ct)
+	testutil.Assert(t, strings.Contains(string(b), "# TYPE latency_seconds histogram"), "unexpected text output %s", b)
+	testutil.Assert(t, strings.Contains(string(b), `latency_seconds_bucket{blockgen_target="1",le="+Inf"}`), "unexpected text output %s", b)
+
+	ct, b = get("application/openmetrics-text; version=0.0.1")
+	testutil.Equals(t, string(expfmt.FmtOpenMetrics_0_0_1), ct)
+	testutil.Assert(t, strings.Contains(string(b), "# {span_id="), "expected exemplars in %s", b)
+	testutil.Assert(t, strings.HasSuffix(string(b), "# EOF\n"), "unexpected OpenMetrics output %s", b)
+
+	ct, b = get("application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited")
+	testutil.Equals(t, string(expfmt.FmtProtoDelim), ct)
+	dec := expfmt.NewDecoder(strings.NewReader(string(b)), expfmt.FmtProtoDelim)
+	var n int
+	for {
+		var mf dto.MetricFamily
+		if err := dec.Decode(&mf); err == io.EOF {
+			break
+		} else {
+			testutil.Ok(t, err)
+		}
+		n++
+	}
+	testutil.Equals(t, 5, n)
+}
+
+func TestFleet(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	f, err := newFleet(FleetConfig{
+		Config: Config{Series: []blockgen.SeriesSpec{
+			{Labels: labels.FromStrings(labels.MetricName, "requests_total"), Targets: 1, Type: blockgen.Counter, Characteristics: seriesgen.Characteristics{ScrapeInterval: 15 * time.Second, Min: 10, Max: 20}},
+		}},
+		Targets:      5,
+		TargetLabels: []blockgen.TargetLabel{{Name: "pod", Template: "app-{{ hash 5 }}"}},
+		Churn:        blockgen.Churn{Lifetime: seriesgen.Distribution{Min: 60, Max: 120}},
+	}, "fleet:8080", labels.FromStrings("seed", "test"), func() time.Time { return now })
+	testutil.Ok(t, err)
+
+	srv := httptest.NewServer(f.Handler())
+	defer srv.Close()
+
+	sd := func() []TargetGroup {
+		resp, err := http.Get(srv.URL + "/sd")
+		testutil.Ok(t, err)
+		defer resp.Body.Close()
+		testutil.Equals(t, http.StatusOK, resp.StatusCode)
+
+		var groups []TargetGroup
+		testutil.Ok(t, json.NewDecoder(resp.Body).Decode(&groups))
+		return groups
+	}
+	scrape := func(path string) (int, string) {
+		resp, err := http.Get(srv.URL + path)
+		testutil.Ok(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		testutil.Ok(t, err)
+		return resp.StatusCode, string(b)
+	}
+
+	groups := sd()
+	testutil.Equals(t, 5, len(groups))
+	paths := map[string]struct{}{}
+	for _, g := range groups {
+		testutil.Equals(t, []string{"fleet:8080"}, g.Targets)
+		testutil.Assert(t, strings.HasPrefix(g.Labels["pod"], "app-"), "unexpected labels %v", g.Labels)
+		paths[g.Labels["__metrics_path__"]] = struct{}{}
+	}
+	testutil.Equals(t, 5, len(paths))
+
+	old := groups[0].Labels["__metrics_path__"]
+	now = now.Add(time.Minute)
+	code, body := scrape(old)
+	testutil.Equals(t, http.StatusOK, code)
+	testutil.Assert(t, strings.Contains(body, "requests_total{"), "unexpected metrics %s", body)
+
+	// All targets are replaced after their lifetimes, with new paths and labels.
+	now = now.Add(2 * time.Minute)
+	code, _ = scrape(old)
+	testutil.Equals(t, http.StatusNotFound, code)
+	for _, g := range sd() {
+		_, ok := paths[g.Labels["__metrics_path__"]]
+		testutil.Assert(t, !ok, "target %v should be replaced", g.Labels)
+		code, _ := scrape(g.Labels["__metrics_path__"])
+		testutil.Equals(t, http.StatusOK, code)
+	}
+	// Lifetimes computed ahead are the same as computed for every replacement.
+	for i, tg := range f.targets {
+		testutil.Equals(t, f.config.Churn.Lifetimes(f.seed(strconv.Itoa(i)), timestamp.FromTime(now), timestamp.FromTime(now), f.interval)[0], tg.life)
+	}
+
+	file := filepath.Join(t.TempDir(), "targets.json")
+	testutil.Ok(t, f.WriteFileSD(file))
+	b, err := os.ReadFile(file)
+	testutil.Ok(t, err)
+	var groups2 []TargetGroup
+	testutil.Ok(t, json.Unmarshal(b, &groups2))
+	testutil.Equals(t, f.TargetGroups(), groups2)
+}
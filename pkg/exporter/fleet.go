@@ -0,0 +1,240 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/thanos-io/thanosbench/pkg/blockgen"
+)
+
+// targetsPath is the path prefix of metrics endpoints of fleet targets, followed by target ID and /metrics.
+const targetsPath = "/targets/"
+
+// lifetimesAhead is how far ahead lifetimes of targets are computed at once. Computing lifetimes replays all of them
+// since the start of the churn period, too costly to do for every replaced target.
+const lifetimesAhead = time.Hour
+
+// FleetConfig describes fleet of targets served by one process, each with its own series.
+type FleetConfig struct {
+	// Series of every target, e.g with Targets 1 for one series per spec and target.
+	Config `yaml:",inline"`
+
+	// Targets is the number of targets at any time.
+	Targets int `yaml:"targets"`
+	// TargetLabels are added to every target in service discovery. Template values get the target slot as .i,
+	// hash values are fresh for replaced targets. Targets get target label with their ID if not specified.
+	TargetLabels []blockgen.TargetLabel `yaml:"targetLabels"`
+	// Churn replaces targets over time, e.g with exponential lifetime with mean of Targets/rate seconds, targets are
+	// replaced at rate per second on average. Replaced targets have new ID and path, so Prometheus sees new targets.
+	Churn blockgen.Churn `yaml:"churn"`
+}
+
+// TargetGroup is a group of targets of Prometheus HTTP and file service discovery.
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+type fleetTarget struct {
+	id   string
+	life blockgen.Lifetime
+	lset labels.Labels
+
+	handler http.Handler
+}
+
+// Fleet simulates many exporters on distinct paths of one address, so Prometheus pays per target cost of scrapes as
+// with real exporters.
+type Fleet struct {
+	config  FleetConfig
+	address string
+	extLset labels.Labels
+	now     func() time.Time
+	// interval is the minimum lifetime of targets.
+	interval time.Duration
+
+	mtx sync.Mutex
+	// targets are current targets of every slot.
+	targets []*fleetTarget
+	byID    map[string]*fleetTarget
+	// lifetimes are upcoming lifetimes of targets of every slot, starting with the current one.
+	lifetimes [][]blockgen.Lifetime
+}
+
+// NewFleet creates fleet of targets described by given config, scraped at given address, e.g host:port. External
+// labels only seed random generators, so fleets with different labels serve different targets.
+func NewFleet(config FleetConfig, address string, extLset labels.Labels) (*Fleet, error) {
+	return newFleet(config, address, extLset, time.Now)
+}
+
+func newFleet(config FleetConfig, address string, extLset labels.Labels, now func() time.Time) (*Fleet, error) {
+	if config.Targets <= 0 {
+		return nil, errors.Errorf("fleet: targets has to be positive, got %d", config.Targets)
+	}
+	if config.Churn.Enabled() {
+		if err := config.Churn.Validate(); err != nil {
+			return nil, errors.Wrap(err, "fleet")
+		}
+	}
+	if len(config.TargetLabels) > 0 {
+		if _, err := blockgen.GenerateTargetLabels(config.TargetLabels, 0, 0); err != nil {
+			return nil, errors.Wrap(err, "fleet")
+		}
+	}
+
+	f := &Fleet{
+		config:    config,
+		address:   address,
+		extLset:   extLset,
+		now:       now,
+		targets:   make([]*fleetTarget, config.Targets),
+		byID:      map[string]*fleetTarget{},
+		lifetimes: make([][]blockgen.Lifetime, config.Targets),
+	}
+	for _, s := range config.Series {
+		if f.interval == 0 || s.ScrapeInterval < f.interval {
+			f.interval = s.ScrapeInterval
+		}
+	}
+	if err := f.Refresh(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// seed returns stable random seed for given slot or target ID.
+func (f *Fleet) seed(id string) int64 {
+	b := []byte(id)
+	for _, l := range f.extLset {
+		b = append(b, '\xff')
+		b = append(b, l.Name...)
+		b = append(b, '\xff')
+		b = append(b, l.Value...)
+	}
+	return int64(xxhash.Sum64(b))
+}
+
+// Refresh replaces targets which lifetime ended. Targets are refreshed on every HTTP service discovery request,
+// otherwise Refresh has to be called periodically.
+func (f *Fleet) Refresh() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	now := timestamp.FromTime(f.now())
+	for i, t := range f.targets {
+		if t != nil && t.life.Start <= now && now < t.life.End {
+			continue
+		}
+		if t != nil {
+			delete(f.byID, t.id)
+		}
+
+		slot := strconv.Itoa(i)
+		life := f.lifetime(i, slot, now)
+		id := slot
+		if f.config.Churn.Enabled() {
+			id = fmt.Sprintf("%s-%d", slot, life.Start)
+		}
+		nt, err := f.newTarget(i, id, life)
+		if err != nil {
+			return errors.Wrapf(err, "target %s", id)
+		}
+		f.targets[i] = nt
+		f.byID[id] = nt
+	}
+	return nil
+}
+
+// lifetime returns lifetime of the target of given slot at now, computing lifetimes ahead once the known ones ended.
+func (f *Fleet) lifetime(i int, slot string, now int64) blockgen.Lifetime {
+	lives := f.lifetimes[i]
+	for len(lives) > 0 && lives[0].End <= now {
+		lives = lives[1:]
+	}
+	if len(lives) == 0 || lives[0].Start > now {
+		lives = f.config.Churn.Lifetimes(f.seed(slot), now, now+lifetimesAhead.Milliseconds(), f.interval)
+	}
+	f.lifetimes[i] = lives
+	return lives[0]
+}
+
+func (f *Fleet) newTarget(i int, id string, life blockgen.Lifetime) (*fleetTarget, error) {
+	extLset := labels.NewBuilder(f.extLset).Set("target", id).Labels()
+	e, err := newExporter(f.config.Config, extLset, f.now)
+	if err != nil {
+		return nil, err
+	}
+
+	lset := labels.FromStrings("target", id)
+	if len(f.config.TargetLabels) > 0 {
+		if lset, err = blockgen.GenerateTargetLabels(f.config.TargetLabels, f.seed(id), i); err != nil {
+			return nil, err
+		}
+	}
+	lset = labels.NewBuilder(lset).Set("__metrics_path__", targetsPath+id+"/metrics").Labels()
+	return &fleetTarget{id: id, life: life, lset: lset, handler: e.Handler()}, nil
+}
+
+// TargetGroups returns current targets in format of Prometheus HTTP and file service discovery.
+func (f *Fleet) TargetGroups() []TargetGroup {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	res := make([]TargetGroup, 0, len(f.targets))
+	for _, t := range f.targets {
+		res = append(res, TargetGroup{Targets: []string{f.address}, Labels: t.lset.Map()})
+	}
+	return res
+}
+
+// WriteFileSD writes current targets to the file for Prometheus file service discovery. File is replaced atomically.
+func (f *Fleet) WriteFileSD(path string) error {
+	b, err := json.MarshalIndent(f.TargetGroups(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Handler returns HTTP handler serving metrics of targets on /targets/<id>/metrics and HTTP service discovery on
+// /sd.
+func (f *Fleet) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sd", func(w http.ResponseWriter, r *http.Request) {
+		if err := f.Refresh(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(f.TargetGroups())
+	})
+	mux.HandleFunc(targetsPath, func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, targetsPath), "/metrics")
+
+		f.mtx.Lock()
+		t, ok := f.byID[id]
+		f.mtx.Unlock()
+		if !ok || !strings.HasSuffix(r.URL.Path, "/metrics") || timestamp.FromTime(f.now()) >= t.life.End {
+			// Target was replaced, Prometheus scrapes it until the next service discovery refresh.
+			http.NotFound(w, r)
+			return
+		}
+		t.handler.ServeHTTP(w, r)
+	})
+	return mux
+}
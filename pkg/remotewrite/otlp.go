@@ -0,0 +1,598 @@
+package remotewrite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/thanos-io/thanosbench/pkg/blockgen"
+	"github.com/thanos-io/thanosbench/pkg/exporter"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"golang.org/x/sync/errgroup"
+)
+
+// MessageOTLP is ExportMetricsServiceRequest of OTLP/HTTP. Series are exported as snapshots of their latest values
+// every export interval, as OpenTelemetry SDKs do, so classic histograms and summaries are exported as single data
+// points instead of series per bucket or quantile.
+const MessageOTLP = "opentelemetry.proto.collector.metrics.v1.ExportMetricsServiceRequest"
+
+// Aggregation temporalities of sums and histograms.
+const (
+	TemporalityCumulative = "cumulative"
+	TemporalityDelta      = "delta"
+)
+
+// OTLPConfig describes export of series with OTLP.
+type OTLPConfig struct {
+	// Temporality of sums and histograms, cumulative (default) or delta. Summaries are always cumulative.
+	Temporality string `yaml:"temporality"`
+	// ExportInterval is the interval of data points. Defaults to the shortest scrape interval of series.
+	ExportInterval time.Duration `yaml:"exportInterval"`
+	// ResourceLabels are labels of series moved to resource attributes, in addition to target labels of series
+	// specs. Target and job labels are always exported as service.instance.id and service.name attributes.
+	ResourceLabels []string `yaml:"resourceLabels"`
+}
+
+func (c *OTLPConfig) setDefaults(series []blockgen.SeriesSpec) error {
+	if c.Temporality == "" {
+		c.Temporality = TemporalityCumulative
+	}
+	if c.Temporality != TemporalityCumulative && c.Temporality != TemporalityDelta {
+		return errors.Errorf("otlp: unknown temporality %q, expected %s or %s", c.Temporality, TemporalityCumulative, TemporalityDelta)
+	}
+	if c.ExportInterval == 0 {
+		for _, s := range series {
+			if c.ExportInterval == 0 || s.ScrapeInterval < c.ExportInterval {
+				c.ExportInterval = s.ScrapeInterval
+			}
+		}
+	}
+	if c.ExportInterval <= 0 {
+		return errors.New("otlp: export interval has to be positive")
+	}
+	return nil
+}
+
+// writeOTLP exports data points of series within [mint, maxt] every export interval. Data points of every series are
+// exported by the same shard and shards export the next data points once all of them exported the previous ones, so
+// data points of every series are in order.
+func (w *Writer) writeOTLP(ctx context.Context, mint, maxt int64, realTime bool) error {
+	now := timestamp.Time(mint)
+	e, err := exporter.NewWithClock(exporter.Config{
+		Series: w.config.Series,
+		Window: time.Duration(maxt-mint+1) * time.Millisecond,
+	}, w.extLset, func() time.Time { return now })
+	if err != nil {
+		return err
+	}
+
+	// Names of resource attributes by names of labels moved to resources.
+	resourceLabels := map[string]string{}
+	for _, s := range w.config.Series {
+		for _, tl := range s.TargetLabels {
+			resourceLabels[tl.Name] = tl.Name
+		}
+	}
+	for _, l := range w.config.OTLP.ResourceLabels {
+		resourceLabels[l] = l
+	}
+	resourceLabels["blockgen_target"] = "service.instance.id"
+	resourceLabels["job"] = "service.name"
+
+	shards := make([]*otlpShard, w.config.Queue.Shards)
+	for i := range shards {
+		shards[i] = &otlpShard{w: w, i: uint64(i), n: uint64(len(shards)), resourceLabels: resourceLabels}
+	}
+
+	for t := mint; t <= maxt; t += w.config.OTLP.ExportInterval.Milliseconds() {
+		if realTime {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Until(timestamp.Time(t))):
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		now = timestamp.Time(t)
+		mfs, err := e.Gather()
+		if err != nil {
+			return errors.Wrap(err, "gather series")
+		}
+		start := timestamp.FromTime(e.WindowStart())
+		units := e.Units()
+
+		g, gctx := errgroup.WithContext(ctx)
+		for _, sh := range shards {
+			sh := sh
+			g.Go(func() error {
+				return sh.export(gctx, mfs, units, t, start)
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// otlpPoint is a data point exported by the shard, to compute deltas of the next one.
+type otlpPoint struct {
+	t int64
+	m *dto.Metric
+}
+
+type otlpShard struct {
+	w              *Writer
+	i, n           uint64
+	resourceLabels map[string]string
+
+	// prev are data points of series exported at the previous export, to compute deltas. They are reset once the
+	// exporter window starts again, as all series are regenerated from scratch.
+	prev      map[string]otlpPoint
+	prevStart int64
+}
+
+// export exports data points of metrics of the shard at t, with units by metric family name. Cumulative data points
+// start at start.
+func (s *otlpShard) export(ctx context.Context, mfs []*dto.MetricFamily, units map[string]string, t, start int64) error {
+	b := newOTLPBatch(s.w.config.OTLP.Temporality)
+	delta := b.temporality == pmetric.AggregationTemporalityDelta
+	if start != s.prevStart {
+		s.prev, s.prevStart = nil, start
+	}
+	// Only series of the current gather are kept, so data points of churned series are not kept forever.
+	var next map[string]otlpPoint
+	if delta {
+		next = make(map[string]otlpPoint, len(s.prev))
+	}
+
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			key := mf.GetName() + "\xff" + labelsKey(m.Label)
+			if xxhash.Sum64String(key)%s.n != s.i {
+				continue
+			}
+
+			var prev *otlpPoint
+			if delta {
+				if p, ok := s.prev[key]; ok {
+					prev = &p
+				}
+				next[key] = otlpPoint{t: t, m: m}
+			}
+			b.add(s.resourceLabels, mf, units[mf.GetName()], m, prev, start, t)
+			if b.len() >= s.w.config.Queue.MaxSamplesPerSend {
+				if err := s.send(ctx, b); err != nil {
+					return err
+				}
+				b = newOTLPBatch(s.w.config.OTLP.Temporality)
+			}
+		}
+	}
+	s.prev = next
+	if b.len() > 0 {
+		return s.send(ctx, b)
+	}
+	return nil
+}
+
+func (s *otlpShard) send(ctx context.Context, b *otlpBatch) error {
+	if err := s.w.limiter.WaitN(ctx, b.len()); err != nil {
+		return err
+	}
+	written, err := s.w.send(ctx, func(string) ([]byte, error) {
+		raw, err := pmetricotlp.NewExportRequestFromMetrics(b.md).MarshalProto()
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal export request")
+		}
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(raw); err != nil {
+			return nil, err
+		}
+		if err := gw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+	if err != nil || !written {
+		return err
+	}
+
+	s.w.mtx.Lock()
+	defer s.w.mtx.Unlock()
+	s.w.stats.Samples += b.samples
+	s.w.stats.Histograms += b.histograms
+	s.w.stats.Exemplars += b.exemplars
+	return nil
+}
+
+// rejectedDataPoints returns the number of rejected data points of partial success response, 0 if response is empty
+// or invalid.
+func rejectedDataPoints(b []byte) int {
+	resp := pmetricotlp.NewExportResponse()
+	if len(b) == 0 || resp.UnmarshalProto(b) != nil {
+		return 0
+	}
+	return int(resp.PartialSuccess().RejectedDataPoints())
+}
+
+// otlpBatch is a pending export request. Number and summary data points are counted as samples, histogram data
+// points as histograms.
+type otlpBatch struct {
+	md          pmetric.Metrics
+	temporality pmetric.AggregationTemporality
+	// scopes and metrics by resource and by resource and metric name.
+	scopes  map[string]pmetric.ScopeMetrics
+	metrics map[string]pmetric.Metric
+
+	samples, histograms, exemplars int
+}
+
+func newOTLPBatch(temporality string) *otlpBatch {
+	b := &otlpBatch{
+		md:          pmetric.NewMetrics(),
+		temporality: pmetric.AggregationTemporalityCumulative,
+		scopes:      map[string]pmetric.ScopeMetrics{},
+		metrics:     map[string]pmetric.Metric{},
+	}
+	if temporality == TemporalityDelta {
+		b.temporality = pmetric.AggregationTemporalityDelta
+	}
+	return b
+}
+
+func (b *otlpBatch) len() int {
+	return b.samples + b.histograms + b.exemplars
+}
+
+// add adds data point of the metric at t, with delta from previous data point, if any. Data points without previous
+// one start at start.
+func (b *otlpBatch) add(resourceLabels map[string]string, mf *dto.MetricFamily, unit string, m *dto.Metric, prev *otlpPoint, start, t int64) {
+	var resource, attrs []*dto.LabelPair
+	for _, l := range m.Label {
+		if _, ok := resourceLabels[l.GetName()]; ok {
+			resource = append(resource, l)
+			continue
+		}
+		attrs = append(attrs, l)
+	}
+
+	rkey := labelsKey(resource)
+	sm, ok := b.scopes[rkey]
+	if !ok {
+		rm := b.md.ResourceMetrics().AppendEmpty()
+		for _, l := range resource {
+			rm.Resource().Attributes().PutStr(resourceLabels[l.GetName()], l.GetValue())
+		}
+		sm = rm.ScopeMetrics().AppendEmpty()
+		sm.Scope().SetName("thanosbench")
+		b.scopes[rkey] = sm
+	}
+
+	native := m.Histogram != nil && m.Histogram.Schema != nil
+	mkey := rkey + "\xfe" + mf.GetName()
+	metric, ok := b.metrics[mkey]
+	if !ok {
+		metric = sm.Metrics().AppendEmpty()
+		metric.SetName(mf.GetName())
+		metric.SetDescription(mf.GetHelp())
+		metric.SetUnit(unit)
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			// Receivers add the suffix to monotonic sums.
+			metric.SetName(strings.TrimSuffix(mf.GetName(), "_total"))
+			sum := metric.SetEmptySum()
+			sum.SetIsMonotonic(true)
+			sum.SetAggregationTemporality(b.temporality)
+		case dto.MetricType_HISTOGRAM:
+			if native {
+				metric.SetEmptyExponentialHistogram().SetAggregationTemporality(b.temporality)
+			} else {
+				metric.SetEmptyHistogram().SetAggregationTemporality(b.temporality)
+			}
+		case dto.MetricType_SUMMARY:
+			metric.SetEmptySummary()
+		default:
+			metric.SetEmptyGauge()
+		}
+		b.metrics[mkey] = metric
+	}
+
+	ts := pcommon.Timestamp(t * int64(time.Millisecond))
+	// startTs returns start of the data point, given whether it is a delta from the previous one.
+	startTs := func(delta bool) pcommon.Timestamp {
+		switch {
+		case prev == nil:
+			return pcommon.Timestamp(start * int64(time.Millisecond))
+		case delta:
+			return pcommon.Timestamp(prev.t * int64(time.Millisecond))
+		default:
+			// Values decreased since the previous data point, e.g after counter reset, so the previous one can't be
+			// subtracted and the delta starts now.
+			return ts
+		}
+	}
+
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		dp := metric.Sum().DataPoints().AppendEmpty()
+		v := m.Counter.GetValue()
+		delta := false
+		if prev != nil {
+			if d := v - prev.m.Counter.GetValue(); d >= 0 {
+				v, delta = d, true
+			}
+		}
+		dp.SetDoubleValue(v)
+		dp.SetStartTimestamp(startTs(delta))
+		dp.SetTimestamp(ts)
+		putAttributes(dp.Attributes(), attrs)
+		b.exemplars += addExemplar(dp.Exemplars(), m.Counter.Exemplar)
+		b.samples++
+	case dto.MetricType_HISTOGRAM:
+		if native {
+			dp := metric.ExponentialHistogram().DataPoints().AppendEmpty()
+			dp.SetStartTimestamp(startTs(fillExponentialHistogram(dp, m.Histogram, prev)))
+			dp.SetTimestamp(ts)
+			putAttributes(dp.Attributes(), attrs)
+		} else {
+			dp := metric.Histogram().DataPoints().AppendEmpty()
+			n, delta := fillHistogram(dp, m.Histogram, prev)
+			b.exemplars += n
+			dp.SetStartTimestamp(startTs(delta))
+			dp.SetTimestamp(ts)
+			putAttributes(dp.Attributes(), attrs)
+		}
+		b.histograms++
+	case dto.MetricType_SUMMARY:
+		dp := metric.Summary().DataPoints().AppendEmpty()
+		dp.SetCount(m.Summary.GetSampleCount())
+		dp.SetSum(m.Summary.GetSampleSum())
+		for _, q := range m.Summary.Quantile {
+			qv := dp.QuantileValues().AppendEmpty()
+			qv.SetQuantile(q.GetQuantile())
+			qv.SetValue(q.GetValue())
+		}
+		// Summaries are always cumulative, since the start of the window.
+		dp.SetStartTimestamp(pcommon.Timestamp(start * int64(time.Millisecond)))
+		dp.SetTimestamp(ts)
+		putAttributes(dp.Attributes(), attrs)
+		b.samples++
+	default:
+		dp := metric.Gauge().DataPoints().AppendEmpty()
+		if m.Gauge != nil {
+			dp.SetDoubleValue(m.Gauge.GetValue())
+		} else {
+			dp.SetDoubleValue(m.Untyped.GetValue())
+		}
+		dp.SetTimestamp(ts)
+		putAttributes(dp.Attributes(), attrs)
+		b.samples++
+	}
+}
+
+// fillHistogram sets explicit buckets of classic histogram, as delta from the previous data point if possible. It
+// returns the number of exemplars and whether the data point is a delta.
+func fillHistogram(dp pmetric.HistogramDataPoint, h *dto.Histogram, prev *otlpPoint) (int, bool) {
+	var (
+		bounds []float64
+		counts []uint64
+		last   uint64
+		n      int
+	)
+	for _, bkt := range h.Bucket {
+		if !math.IsInf(bkt.GetUpperBound(), 1) {
+			bounds = append(bounds, bkt.GetUpperBound())
+		}
+		// Buckets are cumulative in Prometheus and not in OTLP.
+		counts = append(counts, bkt.GetCumulativeCount()-last)
+		last = bkt.GetCumulativeCount()
+		n += addExemplar(dp.Exemplars(), bkt.Exemplar)
+	}
+	if len(counts) == len(bounds) {
+		counts = append(counts, h.GetSampleCount()-last)
+	}
+	count, sum := h.GetSampleCount(), h.GetSampleSum()
+
+	isDelta := false
+	if prev != nil {
+		p := prev.m.Histogram
+		var prevCounts []uint64
+		last = 0
+		for _, bkt := range p.Bucket {
+			prevCounts = append(prevCounts, bkt.GetCumulativeCount()-last)
+			last = bkt.GetCumulativeCount()
+		}
+		if len(prevCounts) < len(counts) {
+			prevCounts = append(prevCounts, p.GetSampleCount()-last)
+		}
+		if delta, ok := subtract(counts, prevCounts); ok && count >= p.GetSampleCount() {
+			counts, isDelta = delta, true
+			count -= p.GetSampleCount()
+			sum -= p.GetSampleSum()
+		}
+	}
+
+	dp.SetCount(count)
+	dp.SetSum(sum)
+	dp.ExplicitBounds().FromRaw(bounds)
+	dp.BucketCounts().FromRaw(counts)
+	return n, isDelta
+}
+
+// fillExponentialHistogram sets buckets of native histogram. OTLP bucket of index i is (base^i, base^(i+1)], so it is
+// the Prometheus bucket of index i+1. It returns whether the data point is a delta from the previous one.
+func fillExponentialHistogram(dp pmetric.ExponentialHistogramDataPoint, h *dto.Histogram, prev *otlpPoint) bool {
+	count, sum, zero := nativeCount(h), h.GetSampleSum(), nativeZeroCount(h)
+	posOffset, pos := denseBuckets(h.PositiveSpan, h.PositiveDelta, h.PositiveCount)
+	negOffset, neg := denseBuckets(h.NegativeSpan, h.NegativeDelta, h.NegativeCount)
+
+	isDelta := false
+	if prev != nil && prev.m.Histogram.GetSchema() == h.GetSchema() && count >= nativeCount(prev.m.Histogram) {
+		p := prev.m.Histogram
+		prevPosOffset, prevPos := denseBuckets(p.PositiveSpan, p.PositiveDelta, p.PositiveCount)
+		prevNegOffset, prevNeg := denseBuckets(p.NegativeSpan, p.NegativeDelta, p.NegativeCount)
+		dPos, okPos := subtract(pos, align(prevPos, prevPosOffset, posOffset, len(pos)))
+		dNeg, okNeg := subtract(neg, align(prevNeg, prevNegOffset, negOffset, len(neg)))
+		if okPos && okNeg && zero >= nativeZeroCount(p) {
+			pos, neg, isDelta = dPos, dNeg, true
+			count -= nativeCount(p)
+			sum -= p.GetSampleSum()
+			zero -= nativeZeroCount(p)
+		}
+	}
+
+	dp.SetScale(h.GetSchema())
+	dp.SetCount(count)
+	dp.SetSum(sum)
+	dp.SetZeroCount(zero)
+	dp.Positive().SetOffset(posOffset - 1)
+	dp.Positive().BucketCounts().FromRaw(pos)
+	dp.Negative().SetOffset(negOffset - 1)
+	dp.Negative().BucketCounts().FromRaw(neg)
+	return isDelta
+}
+
+func nativeCount(h *dto.Histogram) uint64 {
+	if h.SampleCountFloat != nil {
+		return uint64(math.Round(h.GetSampleCountFloat()))
+	}
+	return h.GetSampleCount()
+}
+
+func nativeZeroCount(h *dto.Histogram) uint64 {
+	if h.ZeroCountFloat != nil {
+		return uint64(math.Round(h.GetZeroCountFloat()))
+	}
+	return h.GetZeroCount()
+}
+
+// denseBuckets returns index of the first bucket and counts of all buckets from the first to the last populated one
+// of sparse native histogram buckets, with either integer deltas or float counts.
+func denseBuckets(spans []*dto.BucketSpan, deltas []int64, floats []float64) (int32, []uint64) {
+	if len(spans) == 0 {
+		return 0, nil
+	}
+	var (
+		first = spans[0].GetOffset()
+		idx   = first
+		res   []uint64
+		cur   int64
+		i     int
+	)
+	for si, span := range spans {
+		if si > 0 {
+			idx += span.GetOffset()
+		}
+		for int32(len(res)) < idx-first {
+			res = append(res, 0)
+		}
+		for j := uint32(0); j < span.GetLength(); j++ {
+			switch {
+			case i < len(deltas):
+				cur += deltas[i]
+				res = append(res, uint64(cur))
+			case i < len(floats):
+				res = append(res, uint64(math.Round(floats[i])))
+			}
+			i++
+			idx++
+		}
+	}
+	return first, res
+}
+
+// align returns counts of buckets starting at offset as buckets starting at the new offset, n buckets in total.
+func align(counts []uint64, offset, newOffset int32, n int) []uint64 {
+	res := make([]uint64, n)
+	for i, c := range counts {
+		if j := int(offset-newOffset) + i; j >= 0 && j < n {
+			res[j] = c
+		} else if c > 0 {
+			// Bucket of the previous data point is gone, e.g after reset.
+			return nil
+		}
+	}
+	return res
+}
+
+// subtract returns a-b per bucket. It returns false if any bucket decreased, e.g after reset.
+func subtract(a, b []uint64) ([]uint64, bool) {
+	if b == nil && len(a) > 0 || len(b) > len(a) {
+		return nil, false
+	}
+	res := make([]uint64, len(a))
+	for i := range a {
+		var prev uint64
+		if i < len(b) {
+			prev = b[i]
+		}
+		if a[i] < prev {
+			return nil, false
+		}
+		res[i] = a[i] - prev
+	}
+	return res, true
+}
+
+// addExemplar adds exemplar, if any, with trace_id and span_id labels as trace and span IDs. It returns the number of
+// exemplars added.
+func addExemplar(es pmetric.ExemplarSlice, e *dto.Exemplar) int {
+	if e == nil {
+		return 0
+	}
+	ex := es.AppendEmpty()
+	ex.SetDoubleValue(e.GetValue())
+	if e.Timestamp != nil {
+		ex.SetTimestamp(pcommon.NewTimestampFromTime(e.Timestamp.AsTime()))
+	}
+	for _, l := range e.Label {
+		switch b, err := hex.DecodeString(l.GetValue()); {
+		case l.GetName() == "trace_id" && err == nil && len(b) == 16:
+			var id pcommon.TraceID
+			copy(id[:], b)
+			ex.SetTraceID(id)
+		case l.GetName() == "span_id" && err == nil && len(b) == 8:
+			var id pcommon.SpanID
+			copy(id[:], b)
+			ex.SetSpanID(id)
+		default:
+			ex.FilteredAttributes().PutStr(l.GetName(), l.GetValue())
+		}
+	}
+	return 1
+}
+
+func putAttributes(m pcommon.Map, lbls []*dto.LabelPair) {
+	for _, l := range lbls {
+		m.PutStr(l.GetName(), l.GetValue())
+	}
+}
+
+func labelsKey(lbls []*dto.LabelPair) string {
+	lbls = append([]*dto.LabelPair(nil), lbls...)
+	sort.Slice(lbls, func(i, j int) bool { return lbls[i].GetName() < lbls[j].GetName() })
+
+	var b strings.Builder
+	for _, l := range lbls {
+		b.WriteString(l.GetName())
+		b.WriteByte('\xff')
+		b.WriteString(l.GetValue())
+		b.WriteByte('\xff')
+	}
+	return b.String()
+}
@@ -0,0 +1,249 @@
+package remotewrite
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/thanos-io/thanosbench/pkg/blockgen"
+	"github.com/thanos-io/thanosbench/pkg/seriesgen"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+// otlpReceiver is a stand-in OTLP/HTTP receiver, responding with given status codes first and rejecting one data
+// point of every request in partial success response.
+type otlpReceiver struct {
+	t     *testing.T
+	codes []int
+
+	mtx     sync.Mutex
+	metrics []pmetric.Metrics
+}
+
+func (r *otlpReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	testutil.Equals(r.t, "gzip", req.Header.Get("Content-Encoding"))
+	testutil.Equals(r.t, "application/x-protobuf", req.Header.Get("Content-Type"))
+	gr, err := gzip.NewReader(req.Body)
+	testutil.Ok(r.t, err)
+	b, err := io.ReadAll(gr)
+	testutil.Ok(r.t, err)
+	er := pmetricotlp.NewExportRequest()
+	testutil.Ok(r.t, er.UnmarshalProto(b))
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if len(r.codes) > 0 {
+		code := r.codes[0]
+		r.codes = r.codes[1:]
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	r.metrics = append(r.metrics, er.Metrics())
+
+	resp := pmetricotlp.NewExportResponse()
+	resp.PartialSuccess().SetRejectedDataPoints(1)
+	out, err := resp.MarshalProto()
+	testutil.Ok(r.t, err)
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(out)
+}
+
+// points returns data points of all received metrics by metric name and service.instance.id, in order.
+func (r *otlpReceiver) points() map[string]map[string][]pmetric.Metric {
+	res := map[string]map[string][]pmetric.Metric{}
+	for _, md := range r.metrics {
+		for i := 0; i < md.ResourceMetrics().Len(); i++ {
+			rm := md.ResourceMetrics().At(i)
+			instance, _ := rm.Resource().Attributes().Get("service.instance.id")
+			sms := rm.ScopeMetrics()
+			for j := 0; j < sms.Len(); j++ {
+				ms := sms.At(j).Metrics()
+				for k := 0; k < ms.Len(); k++ {
+					m := ms.At(k)
+					if res[m.Name()] == nil {
+						res[m.Name()] = map[string][]pmetric.Metric{}
+					}
+					res[m.Name()][instance.Str()] = append(res[m.Name()][instance.Str()], m)
+				}
+			}
+		}
+	}
+	return res
+}
+
+func TestWriter_OTLP(t *testing.T) {
+	mint := timestamp.FromTime(time.Unix(1700000000, 0))
+	write := func(t *testing.T, r *otlpReceiver, temporality string, modify ...func(*Config)) Stats {
+		srv := httptest.NewServer(r)
+		defer srv.Close()
+
+		c := testConfig(15 * time.Second)
+		c.ProtobufMessage = MessageOTLP
+		c.OTLP.Temporality = temporality
+		for _, m := range modify {
+			m(&c)
+		}
+		w, err := New(log.NewNopLogger(), c, srv.URL, labels.FromStrings("seed", "test"))
+		testutil.Ok(t, err)
+		testutil.Ok(t, w.Backfill(context.Background(), mint, mint+time.Hour.Milliseconds()-1))
+		return w.Stats()
+	}
+
+	t.Run("cumulative", func(t *testing.T) {
+		r := &otlpReceiver{t: t, codes: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+		s := write(t, r, TemporalityCumulative)
+		testutil.Equals(t, 2, s.Retried)
+		testutil.Equals(t, 0, s.Failed)
+		testutil.Equals(t, len(r.metrics), s.Rejected)
+
+		points := r.points()
+		// Counters are monotonic sums without _total suffix, with a data point every export interval for every target.
+		requests := points["requests"]
+		testutil.Equals(t, 4, len(requests))
+		var last float64
+		for i, m := range requests["1"] {
+			testutil.Equals(t, pmetric.MetricTypeSum, m.Type())
+			testutil.Assert(t, m.Sum().IsMonotonic(), "expected monotonic sum")
+			testutil.Equals(t, pmetric.AggregationTemporalityCumulative, m.Sum().AggregationTemporality())
+			dp := m.Sum().DataPoints().At(0)
+			testutil.Equals(t, pcommon.Timestamp(mint*int64(time.Millisecond)), dp.StartTimestamp())
+			testutil.Equals(t, pcommon.Timestamp((mint+int64(i)*15000)*int64(time.Millisecond)), dp.Timestamp())
+			testutil.Assert(t, dp.DoubleValue() >= last, "cumulative sum decreased")
+			last = dp.DoubleValue()
+			testutil.Equals(t, 1, dp.Exemplars().Len())
+			testutil.Assert(t, !dp.Exemplars().At(0).TraceID().IsEmpty(), "expected trace ID of exemplar")
+		}
+		testutil.Equals(t, 240, len(requests["1"]))
+
+		// Classic histograms are single data points with all buckets.
+		latency := points["latency_seconds"]["1"]
+		testutil.Assert(t, len(latency) > 0, "expected histogram data points")
+		for _, m := range latency {
+			dp := m.Histogram().DataPoints().At(0)
+			testutil.Equals(t, len(seriesgen.DefBuckets), dp.ExplicitBounds().Len())
+			testutil.Equals(t, len(seriesgen.DefBuckets)+1, dp.BucketCounts().Len())
+			var count uint64
+			for _, c := range dp.BucketCounts().AsRaw() {
+				count += c
+			}
+			testutil.Equals(t, dp.Count(), count)
+		}
+
+		// Native histograms are exponential histograms.
+		size := points["size_bytes"]["1"]
+		testutil.Equals(t, 240, len(size))
+		dp := size[len(size)-1].ExponentialHistogram().DataPoints().At(0)
+		testutil.Equals(t, int32(3), dp.Scale())
+		count := dp.ZeroCount()
+		for _, c := range dp.Positive().BucketCounts().AsRaw() {
+			count += c
+		}
+		for _, c := range dp.Negative().BucketCounts().AsRaw() {
+			count += c
+		}
+		testutil.Equals(t, dp.Count(), count)
+	})
+	t.Run("delta", func(t *testing.T) {
+		cumulative := &otlpReceiver{t: t}
+		write(t, cumulative, TemporalityCumulative)
+		r := &otlpReceiver{t: t}
+		write(t, r, TemporalityDelta)
+
+		// Deltas sum up to cumulative values and start at the previous data point.
+		points := r.points()
+		requests := points["requests"]["1"]
+		var sum float64
+		for i, m := range requests {
+			testutil.Equals(t, pmetric.AggregationTemporalityDelta, m.Sum().AggregationTemporality())
+			dp := m.Sum().DataPoints().At(0)
+			if i > 0 {
+				testutil.Equals(t, requests[i-1].Sum().DataPoints().At(0).Timestamp(), dp.StartTimestamp())
+			}
+			sum += dp.DoubleValue()
+		}
+		expected := cumulative.points()["requests"]["1"]
+		diff := expected[len(expected)-1].Sum().DataPoints().At(0).DoubleValue() - sum
+		testutil.Assert(t, math.Abs(diff) < 1e-6, "deltas don't sum up to cumulative value, difference %v", diff)
+
+		var count uint64
+		for _, m := range points["size_bytes"]["1"] {
+			count += m.ExponentialHistogram().DataPoints().At(0).Count()
+		}
+		expected = cumulative.points()["size_bytes"]["1"]
+		testutil.Equals(t, expected[len(expected)-1].ExponentialHistogram().DataPoints().At(0).Count(), count)
+	})
+	t.Run("delta with resets", func(t *testing.T) {
+		withResets := func(c *Config) {
+			c.Series[0].Characteristics.Resets = seriesgen.Resets{Interval: 10 * time.Minute}
+		}
+		cumulative := &otlpReceiver{t: t}
+		write(t, cumulative, TemporalityCumulative, withResets)
+		r := &otlpReceiver{t: t}
+		write(t, r, TemporalityDelta, withResets)
+
+		expected := cumulative.points()["requests"]["1"]
+		requests := r.points()["requests"]["1"]
+		testutil.Equals(t, len(expected), len(requests))
+
+		var resets int
+		for i := 1; i < len(requests); i++ {
+			dp := requests[i].Sum().DataPoints().At(0)
+			curr, prev := expected[i].Sum().DataPoints().At(0).DoubleValue(), expected[i-1].Sum().DataPoints().At(0).DoubleValue()
+			if curr < prev {
+				// After reset, the whole value is the delta starting at the data point, not at the previous one.
+				resets++
+				testutil.Equals(t, dp.Timestamp(), dp.StartTimestamp())
+				testutil.Equals(t, curr, dp.DoubleValue())
+				continue
+			}
+			testutil.Equals(t, requests[i-1].Sum().DataPoints().At(0).Timestamp(), dp.StartTimestamp())
+			testutil.Assert(t, math.Abs(curr-prev-dp.DoubleValue()) < 1e-6, "wrong delta at %d", i)
+		}
+		testutil.Assert(t, resets > 0, "expected counter resets")
+	})
+	t.Run("units and summaries", func(t *testing.T) {
+		r := &otlpReceiver{t: t}
+		write(t, r, TemporalityDelta, func(c *Config) {
+			c.Series[1].Characteristics.Unit = "seconds"
+			c.Series = append(c.Series, blockgen.SeriesSpec{
+				Labels: labels.FromStrings(labels.MetricName, "duration_seconds"), Targets: 1, Type: blockgen.Summary,
+				Characteristics: seriesgen.Characteristics{ScrapeInterval: 15 * time.Second, Unit: "seconds"},
+			})
+		})
+
+		points := r.points()
+		for _, m := range points["latency_seconds"]["1"] {
+			testutil.Equals(t, "seconds", m.Unit())
+		}
+		for _, m := range points["requests"]["1"] {
+			testutil.Equals(t, "", m.Unit())
+		}
+		// Summaries are cumulative even with delta temporality, so they start at the start of the window.
+		duration := points["duration_seconds"]["1"]
+		testutil.Equals(t, 240, len(duration))
+		for _, m := range duration {
+			testutil.Equals(t, "seconds", m.Unit())
+			testutil.Equals(t, pcommon.Timestamp(mint*int64(time.Millisecond)), m.Summary().DataPoints().At(0).StartTimestamp())
+		}
+	})
+	t.Run("dropped", func(t *testing.T) {
+		// Data points rejected as invalid are dropped, as in OTLP/HTTP specification.
+		r := &otlpReceiver{t: t, codes: []int{http.StatusBadRequest, http.StatusInternalServerError}}
+		s := write(t, r, TemporalityCumulative)
+		testutil.Equals(t, 0, s.Retried)
+		testutil.Equals(t, 2, s.Failed)
+	})
+}
@@ -0,0 +1,783 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanosbench/pkg/blockgen"
+	"github.com/thanos-io/thanosbench/pkg/seriesgen"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+)
+
+// backfillStep is the time range of samples collected from all series of a shard at once when backfilling, so
+// samples are written roughly in time order, as receivers reject samples too far behind the newest one.
+const backfillStep = time.Minute
+
+// Config describes series written by the writer and how they are queued, with defaults of Prometheus queue manager.
+type Config struct {
+	// Series are generated the same way as series of blocks, MinTime and MaxTime are ignored.
+	Series []blockgen.SeriesSpec `yaml:"series"`
+	// Window is the time range series are generated for at once when writing in real time. Series are generated
+	// again for the next window, so counters reset at window boundaries as if targets restarted. Defaults to 24h.
+	Window time.Duration `yaml:"window"`
+	// ProtobufMessage is the message of remote write protocol, prometheus.WriteRequest of 1.0 (default) or
+	// io.prometheus.write.v2.Request of 2.0. Writer falls back to 1.0 if receiver rejects 2.0 with 415 status code.
+	// With opentelemetry.proto.collector.metrics.v1.ExportMetricsServiceRequest series are exported with OTLP instead.
+	ProtobufMessage string `yaml:"protobufMessage"`
+	// OTLP configures export with OTLP.
+	OTLP OTLPConfig `yaml:"otlp"`
+
+	Queue QueueConfig `yaml:"queue"`
+}
+
+// QueueConfig describes sharding, batching and retries of write requests.
+type QueueConfig struct {
+	// Shards is the number of concurrent senders. Series are distributed over shards by hash. Defaults to 1.
+	Shards int `yaml:"shards"`
+	// MaxSamplesPerSend is the maximum number of samples, histograms and exemplars per request. Defaults to 2000.
+	MaxSamplesPerSend int `yaml:"maxSamplesPerSend"`
+	// BatchSendDeadline is how often partial batches are sent when writing in real time. Defaults to 5s.
+	BatchSendDeadline time.Duration `yaml:"batchSendDeadline"`
+	// MaxSamplesPerSecond limits samples, histograms and exemplars written per second by all shards, e.g to backfill
+	// at a steady rate. Unlimited if 0.
+	MaxSamplesPerSecond float64 `yaml:"maxSamplesPerSecond"`
+
+	// MinBackoff is the initial backoff of retries, doubled up to MaxBackoff. Default to 30ms and 5s.
+	MinBackoff time.Duration `yaml:"minBackoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	// RetryOnRateLimit retries requests rejected with 429 status code, otherwise they are dropped.
+	RetryOnRateLimit bool `yaml:"retryOnRateLimit"`
+	// Timeout of a single request. Defaults to 30s.
+	Timeout time.Duration `yaml:"timeout"`
+	// MetadataInterval is how often metadata of all metric families is sent when writing in real time. Metadata is
+	// always sent once before samples. Defaults to 1m. Remote write 2.0 sends metadata with every series instead.
+	MetadataInterval time.Duration `yaml:"metadataInterval"`
+
+	// Headers are added to every request, e.g THANOS-TENANT.
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Stats describes requests sent so far. Samples, histograms and exemplars are counted once written.
+type Stats struct {
+	// Requests is the number of attempts, including retries.
+	Requests int
+	// Responses is the number of responses per HTTP status code.
+	Responses map[int]int
+	// Retried is the number of attempts retried, failed with 5xx, 429 or network error.
+	Retried int
+	// Failed is the number of requests dropped after non-retryable response, e.g 400.
+	Failed int
+
+	Samples, Histograms, Exemplars, Metadata int
+	// WrittenSamples, WrittenHistograms and WrittenExemplars are reported by receivers in response headers of remote
+	// write 2.0, e.g excluding samples rejected as duplicates.
+	WrittenSamples, WrittenHistograms, WrittenExemplars int
+	// Rejected is the number of data points rejected by OTLP receivers in partial success responses.
+	Rejected int
+	// Bytes is the size of compressed written requests.
+	Bytes int
+	// Latencies is the number of attempts per latency bucket, with upper bounds of latencyBuckets and the last one
+	// counting attempts slower than all of them. Buckets keep memory fixed for writers running for days.
+	Latencies []int
+}
+
+// latencyBuckets are upper bounds of latency buckets, from 1ms to ~65s.
+var latencyBuckets = func() []time.Duration {
+	b := make([]time.Duration, 17)
+	for i := range b {
+		b[i] = time.Millisecond << i
+	}
+	return b
+}()
+
+// observeLatency counts attempt with given latency.
+func (s *Stats) observeLatency(d time.Duration) {
+	if s.Latencies == nil {
+		s.Latencies = make([]int, len(latencyBuckets)+1)
+	}
+	s.Latencies[sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })]++
+}
+
+// Latency returns q-quantile of latencies of attempts, interpolated linearly within the bucket it falls into, as
+// Prometheus histogram_quantile does. Latencies above the last bucket are reported as its upper bound.
+func (s Stats) Latency(q float64) time.Duration {
+	var total int
+	for _, n := range s.Latencies {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	var count int
+	for i, n := range s.Latencies {
+		if i == len(latencyBuckets) {
+			break
+		}
+		if float64(count+n) >= rank && n > 0 {
+			var lower time.Duration
+			if i > 0 {
+				lower = latencyBuckets[i-1]
+			}
+			return lower + time.Duration(float64(latencyBuckets[i]-lower)*(rank-float64(count))/float64(n))
+		}
+		count += n
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("requests=%d retried=%d failed=%d samples=%d histograms=%d exemplars=%d metadata=%d written_samples=%d written_histograms=%d written_exemplars=%d rejected=%d bytes=%d p50=%s p99=%s",
+		s.Requests, s.Retried, s.Failed, s.Samples, s.Histograms, s.Exemplars, s.Metadata, s.WrittenSamples, s.WrittenHistograms, s.WrittenExemplars, s.Rejected, s.Bytes, s.Latency(0.5), s.Latency(0.99))
+}
+
+// Writer writes generated series to a remote write endpoint, e.g Thanos Receive.
+type Writer struct {
+	logger  log.Logger
+	config  Config
+	url     string
+	extLset labels.Labels
+	client  *http.Client
+	limiter *rate.Limiter
+
+	mtx   sync.Mutex
+	stats Stats
+	// message is the protobuf message of requests, changed to MessageV1 if receiver doesn't support 2.0.
+	message string
+	// metas is metadata of metric families of the current window.
+	metas map[string]prompb.MetricMetadata
+}
+
+// New creates writer of series described by given config to given remote write URL. External labels only seed random
+// generators, so writers with different labels write different series values.
+func New(logger log.Logger, config Config, url string, extLset labels.Labels) (*Writer, error) {
+	q := &config.Queue
+	if q.Shards == 0 {
+		q.Shards = 1
+	}
+	if q.MaxSamplesPerSend == 0 {
+		q.MaxSamplesPerSend = 2000
+	}
+	if q.BatchSendDeadline == 0 {
+		q.BatchSendDeadline = 5 * time.Second
+	}
+	if q.MinBackoff == 0 {
+		q.MinBackoff = 30 * time.Millisecond
+	}
+	if q.MaxBackoff == 0 {
+		q.MaxBackoff = 5 * time.Second
+	}
+	if q.Timeout == 0 {
+		q.Timeout = 30 * time.Second
+	}
+	if q.MetadataInterval == 0 {
+		q.MetadataInterval = time.Minute
+	}
+	if config.Window == 0 {
+		config.Window = 24 * time.Hour
+	}
+	if config.ProtobufMessage == "" {
+		config.ProtobufMessage = MessageV1
+	}
+	switch config.ProtobufMessage {
+	case MessageV1, MessageV2:
+	case MessageOTLP:
+		if err := config.OTLP.setDefaults(config.Series); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("remote write: unknown protobuf message %q, expected %s, %s or %s", config.ProtobufMessage, MessageV1, MessageV2, MessageOTLP)
+	}
+	if q.Shards < 0 || q.MaxSamplesPerSend < 0 || q.MaxSamplesPerSecond < 0 {
+		return nil, errors.New("remote write: shards, max samples per send and max samples per second can't be negative")
+	}
+	for _, s := range config.Series {
+		if s.ScrapeInterval <= 0 {
+			return nil, errors.Errorf("series %s: scrape interval has to be positive", s.Labels)
+		}
+	}
+
+	w := &Writer{
+		logger:  logger,
+		config:  config,
+		url:     url,
+		extLset: extLset,
+		client:  &http.Client{Timeout: q.Timeout},
+		limiter: rate.NewLimiter(rate.Inf, 0),
+		stats:   Stats{Responses: map[int]int{}},
+		message: config.ProtobufMessage,
+	}
+	if q.MaxSamplesPerSecond > 0 {
+		// Batches exceed MaxSamplesPerSend by at most the exemplar of the last sample.
+		w.limiter = rate.NewLimiter(rate.Limit(q.MaxSamplesPerSecond), q.MaxSamplesPerSend+1)
+	}
+	return w, nil
+}
+
+// Stats returns statistics of requests sent so far.
+func (w *Writer) Stats() Stats {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	s := w.stats
+	s.Responses = make(map[int]int, len(w.stats.Responses))
+	for code, n := range w.stats.Responses {
+		s.Responses[code] = n
+	}
+	s.Latencies = append([]int(nil), w.stats.Latencies...)
+	return s
+}
+
+// Backfill writes samples within [mint, maxt] as fast as possible, limited only by MaxSamplesPerSecond and the
+// receiver.
+func (w *Writer) Backfill(ctx context.Context, mint, maxt int64) error {
+	return w.write(ctx, mint, maxt, false)
+}
+
+// RealTime writes samples as their timestamps pass in wall-clock time, until given time or until context is canceled
+// if zero.
+func (w *Writer) RealTime(ctx context.Context, until time.Time) error {
+	for {
+		mint := timestamp.FromTime(time.Now())
+		maxt := mint + w.config.Window.Milliseconds() - 1
+		if !until.IsZero() {
+			if end := timestamp.FromTime(until); end <= mint {
+				return nil
+			} else if end <= maxt {
+				maxt = end - 1
+			}
+		}
+		if err := w.write(ctx, mint, maxt, true); err != nil {
+			return err
+		}
+	}
+}
+
+func (w *Writer) write(ctx context.Context, mint, maxt int64, realTime bool) error {
+	if w.config.ProtobufMessage == MessageOTLP {
+		return w.writeOTLP(ctx, mint, maxt, realTime)
+	}
+
+	specs := make([]blockgen.SeriesSpec, len(w.config.Series))
+	for i, s := range w.config.Series {
+		s.MinTime = mint
+		s.MaxTime = maxt
+		specs[i] = s
+	}
+
+	var (
+		shards = make([][]*series, w.config.Queue.Shards)
+		metas  = map[string]prompb.MetricMetadata{}
+	)
+	set := blockgen.NewSeriesSet(specs, w.extLset)
+	for set.Next() {
+		s := newSeries(set.At())
+		shard := s.lset.Hash() % uint64(len(shards))
+		shards[shard] = append(shards[shard], s)
+		if s.meta != (metadata.Metadata{}) {
+			name := familyName(s)
+			metas[name] = prompb.MetricMetadata{
+				Type:             metricTypeProto(s.meta.Type),
+				MetricFamilyName: name,
+				Help:             s.meta.Help,
+				Unit:             s.meta.Unit,
+			}
+		}
+	}
+	if err := set.Err(); err != nil {
+		return errors.Wrap(err, "generate series")
+	}
+
+	w.mtx.Lock()
+	w.metas = metas
+	w.mtx.Unlock()
+
+	// Metadata is sent in separate requests with remote write 1.0, as Prometheus does.
+	if err := w.sendMetadata(ctx); err != nil {
+		return err
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	for _, ss := range shards {
+		ss := ss
+		g.Go(func() error {
+			return w.runShard(gctx, ss, mint, realTime)
+		})
+	}
+	if realTime {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			t := time.NewTicker(w.config.Queue.MetadataInterval)
+			defer t.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-gctx.Done():
+					return
+				case <-t.C:
+					if err := w.sendMetadata(gctx); err != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+	return g.Wait()
+}
+
+// runShard writes samples of series of the shard, collected from all series every BatchSendDeadline in real time or
+// every backfillStep of samples when backfilling.
+func (w *Writer) runShard(ctx context.Context, ss []*series, mint int64, realTime bool) error {
+	var (
+		b = &batch{}
+		t = mint - 1
+	)
+	for {
+		if realTime {
+			t = timestamp.FromTime(time.Now())
+		} else {
+			t += backfillStep.Milliseconds()
+		}
+
+		done := true
+		for _, s := range ss {
+			for {
+				ok, err := s.ready(t)
+				if err != nil {
+					return errors.Wrapf(err, "series %s", s.lset)
+				}
+				if !ok {
+					break
+				}
+				b.add(s)
+				if b.len() >= w.config.Queue.MaxSamplesPerSend {
+					if err := w.sendBatch(ctx, b); err != nil {
+						return err
+					}
+				}
+			}
+			done = done && s.done
+		}
+		if b.len() > 0 {
+			if err := w.sendBatch(ctx, b); err != nil {
+				return err
+			}
+		}
+		if done {
+			return nil
+		}
+
+		if realTime {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(w.config.Queue.BatchSendDeadline):
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+func (w *Writer) sendBatch(ctx context.Context, b *batch) error {
+	defer b.reset()
+
+	if err := w.limiter.WaitN(ctx, b.len()); err != nil {
+		return err
+	}
+	written, err := w.send(ctx, func(message string) ([]byte, error) { return encode(message, b, nil) })
+	if err != nil || !written {
+		return err
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.stats.Samples += b.samples
+	w.stats.Histograms += b.histograms
+	w.stats.Exemplars += b.exemplars
+	return nil
+}
+
+// sendMetadata sends metadata of all metric families of the current window, unless metadata is sent with series.
+func (w *Writer) sendMetadata(ctx context.Context) error {
+	w.mtx.Lock()
+	message, metas := w.message, w.metas
+	w.mtx.Unlock()
+	if message != MessageV1 || len(metas) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(metas))
+	for name := range metas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for len(names) > 0 {
+		n := w.config.Queue.MaxSamplesPerSend
+		if n > len(names) {
+			n = len(names)
+		}
+		req := &prompb.WriteRequest{}
+		for _, name := range names[:n] {
+			req.Metadata = append(req.Metadata, metas[name])
+		}
+		names = names[n:]
+
+		written, err := w.send(ctx, func(message string) ([]byte, error) { return encode(message, nil, req) })
+		if err != nil {
+			return err
+		}
+		if written {
+			w.mtx.Lock()
+			w.stats.Metadata += len(req.Metadata)
+			w.mtx.Unlock()
+		}
+	}
+	return nil
+}
+
+// send sends request encoded for the current protobuf message, retrying with backoff on retryable responses, e.g 5xx
+// and, if enabled, 429 of remote write, as Prometheus queue manager does. Requests rejected with other statuses are
+// dropped. It returns true if the request was written.
+func (w *Writer) send(ctx context.Context, encode func(message string) ([]byte, error)) (bool, error) {
+	w.mtx.Lock()
+	message := w.message
+	w.mtx.Unlock()
+
+	body, err := encode(message)
+	if err != nil {
+		return false, err
+	}
+
+	backoff := w.config.Queue.MinBackoff
+	for {
+		resp, err := w.attempt(ctx, message, body)
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		switch {
+		case err == nil:
+			w.mtx.Lock()
+			w.stats.Bytes += len(body)
+			w.stats.WrittenSamples += resp.samples
+			w.stats.WrittenHistograms += resp.histograms
+			w.stats.WrittenExemplars += resp.exemplars
+			w.stats.Rejected += resp.rejected
+			w.mtx.Unlock()
+			return true, nil
+		case resp.code == http.StatusUnsupportedMediaType && message == MessageV2:
+			// Receiver doesn't support remote write 2.0, fall back to 1.0 with metadata in separate requests.
+			level.Warn(w.logger).Log("msg", "receiver doesn't support remote write 2.0, falling back to 1.0", "err", err)
+			w.mtx.Lock()
+			// Metadata is sent once, even if other shards were rejected concurrently.
+			switched := w.message == MessageV2
+			w.message = MessageV1
+			w.mtx.Unlock()
+			if switched {
+				if err := w.sendMetadata(ctx); err != nil {
+					return false, err
+				}
+			}
+			return w.send(ctx, encode)
+		case w.retryable(message, resp.code):
+			w.mtx.Lock()
+			w.stats.Retried++
+			w.mtx.Unlock()
+			level.Debug(w.logger).Log("msg", "retrying write request", "err", err)
+		default:
+			w.mtx.Lock()
+			w.stats.Failed++
+			w.mtx.Unlock()
+			level.Warn(w.logger).Log("msg", "dropping write request", "err", err)
+			return false, nil
+		}
+
+		sleep := backoff
+		if resp.retryAfter > 0 {
+			sleep = resp.retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(sleep):
+		}
+		if backoff *= 2; backoff > w.config.Queue.MaxBackoff {
+			backoff = w.config.Queue.MaxBackoff
+		}
+	}
+}
+
+// retryable returns true if request rejected with given status code, 0 on network errors, should be retried.
+func (w *Writer) retryable(message string, code int) bool {
+	if message == MessageOTLP {
+		// As in OTLP/HTTP specification.
+		switch code {
+		case 0, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return code/100 == 5 || code == 0 || code == http.StatusTooManyRequests && w.config.Queue.RetryOnRateLimit
+}
+
+// encode returns snappy-compressed request of given protobuf message with series of the batch, or the metadata
+// request of remote write 1.0.
+func encode(message string, b *batch, metaReq *prompb.WriteRequest) ([]byte, error) {
+	var (
+		raw []byte
+		err error
+	)
+	switch {
+	case metaReq != nil:
+		raw, err = metaReq.Marshal()
+	case message == MessageV2:
+		raw, err = encodeV2(b)
+	default:
+		raw, err = (&prompb.WriteRequest{Timeseries: b.series}).Marshal()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal write request")
+	}
+	return snappy.Encode(nil, raw), nil
+}
+
+type response struct {
+	// code is HTTP status code, 0 on network errors.
+	code       int
+	retryAfter time.Duration
+	// Written samples, histograms and exemplars reported by the receiver, if any.
+	samples, histograms, exemplars int
+	// rejected is the number of data points rejected by OTLP receiver in partial success response.
+	rejected int
+}
+
+// attempt sends the request once.
+func (w *Writer) attempt(ctx context.Context, message string, body []byte) (response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return response{}, err
+	}
+	req.Header.Set("Content-Type", contentType(message))
+	req.Header.Set("User-Agent", "thanosbench")
+	if message == MessageOTLP {
+		req.Header.Set("Content-Encoding", "gzip")
+	} else {
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", protocolVersion(message))
+	}
+	for k, v := range w.config.Queue.Headers {
+		req.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	resp, err := w.client.Do(req)
+
+	w.mtx.Lock()
+	w.stats.Requests++
+	w.stats.observeLatency(time.Since(start))
+	if err == nil {
+		w.stats.Responses[resp.StatusCode]++
+	}
+	w.mtx.Unlock()
+
+	if err != nil {
+		return response{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		res := response{code: resp.StatusCode}
+		if message == MessageOTLP {
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return response{}, err
+			}
+			res.rejected = rejectedDataPoints(b)
+			return res, nil
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		res.samples, _ = strconv.Atoi(resp.Header.Get(writtenSamplesHeader))
+		res.histograms, _ = strconv.Atoi(resp.Header.Get(writtenHistogramsHeader))
+		res.exemplars, _ = strconv.Atoi(resp.Header.Get(writtenExemplarsHeader))
+		return res, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return response{code: resp.StatusCode, retryAfter: retryAfter(resp.Header.Get("Retry-After"))},
+		errors.Errorf("server returned HTTP status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// retryAfter parses Retry-After header in seconds or as HTTP date. It returns 0 if header is missing or invalid.
+func retryAfter(v string) time.Duration {
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	return 0
+}
+
+// batch is a pending write request. Every sample, histogram or exemplar is in a separate time series, as Prometheus
+// sends them.
+type batch struct {
+	series []prompb.TimeSeries
+	// refs are generated series of series of the batch.
+	refs                           []*series
+	samples, histograms, exemplars int
+}
+
+func (b *batch) len() int {
+	return b.samples + b.histograms + b.exemplars
+}
+
+func (b *batch) reset() {
+	b.series = b.series[:0]
+	b.refs = b.refs[:0]
+	b.samples, b.histograms, b.exemplars = 0, 0, 0
+}
+
+// add adds the current sample of the series.
+func (b *batch) add(s *series) {
+	ts := prompb.TimeSeries{Labels: s.protoLabels}
+	t, v := s.it.At()
+
+	typ := chunkenc.ValFloat
+	if s.hit != nil {
+		typ = s.hit.ValueType()
+	}
+	switch typ {
+	case chunkenc.ValHistogram:
+		t, h := s.hit.AtHistogram()
+		ts.Histograms = []prompb.Histogram{remote.HistogramToHistogramProto(t, h)}
+		b.histograms++
+	case chunkenc.ValFloatHistogram:
+		t, fh := s.hit.AtFloatHistogram()
+		ts.Histograms = []prompb.Histogram{remote.FloatHistogramToHistogramProto(t, fh)}
+		b.histograms++
+	default:
+		ts.Samples = []prompb.Sample{{Timestamp: t, Value: v}}
+		b.samples++
+	}
+	b.series = append(b.series, ts)
+	b.refs = append(b.refs, s)
+
+	if s.eit == nil {
+		return
+	}
+	if ex, ok := s.eit.AtExemplar(); ok {
+		et := t
+		if ex.HasTs {
+			et = ex.Ts
+		}
+		e := prompb.Exemplar{Value: ex.Value, Timestamp: et}
+		for _, l := range ex.Labels {
+			e.Labels = append(e.Labels, prompb.Label{Name: l.Name, Value: l.Value})
+		}
+		b.series = append(b.series, prompb.TimeSeries{Labels: s.protoLabels, Exemplars: []prompb.Exemplar{e}})
+		b.refs = append(b.refs, s)
+		b.exemplars++
+	}
+}
+
+// series follows generated series, exposing samples up to given time.
+type series struct {
+	lset        labels.Labels
+	protoLabels []prompb.Label
+	meta        metadata.Metadata
+
+	it  seriesgen.SeriesIterator
+	hit seriesgen.HistogramSeriesIterator
+	eit seriesgen.ExemplarSeriesIterator
+	// pending is true if iterator is at sample not written yet.
+	pending, done bool
+	// created is timestamp of the first sample, as series start with the window or target.
+	created int64
+}
+
+func newSeries(s seriesgen.Series) *series {
+	it := s.Iterator()
+	rs := &series{lset: s.Labels(), it: it}
+	for _, l := range rs.lset {
+		rs.protoLabels = append(rs.protoLabels, prompb.Label{Name: l.Name, Value: l.Value})
+	}
+	rs.hit, _ = it.(seriesgen.HistogramSeriesIterator)
+	rs.eit, _ = it.(seriesgen.ExemplarSeriesIterator)
+	if ms, ok := s.(seriesgen.MetadataSeries); ok {
+		rs.meta = ms.Metadata()
+	}
+	return rs
+}
+
+// ready returns true if iterator is at sample not after t. The sample is consumed, so it is returned only once.
+func (s *series) ready(t int64) (bool, error) {
+	if s.done {
+		return false, nil
+	}
+	if !s.pending {
+		if !s.it.Next() {
+			s.done = true
+			return false, s.it.Err()
+		}
+		s.pending = true
+	}
+	st, _ := s.it.At()
+	if st > t {
+		return false, nil
+	}
+	if s.created == 0 {
+		s.created = st
+	}
+	s.pending = false
+	return true, nil
+}
+
+// familyName returns name of the metric family of the series, without suffixes of classic histograms and summaries.
+func familyName(s *series) string {
+	name := s.lset.Get(labels.MetricName)
+
+	var suffixes []string
+	switch s.meta.Type {
+	case textparse.MetricTypeHistogram:
+		// Native histograms are expected to be named without these suffixes.
+		suffixes = []string{"_bucket", "_sum", "_count"}
+	case textparse.MetricTypeSummary:
+		suffixes = []string{"_sum", "_count"}
+	}
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix)
+		}
+	}
+	return name
+}
+
+func metricTypeProto(t textparse.MetricType) prompb.MetricMetadata_MetricType {
+	if v, ok := prompb.MetricMetadata_MetricType_value[strings.ToUpper(string(t))]; ok {
+		return prompb.MetricMetadata_MetricType(v)
+	}
+	return prompb.MetricMetadata_UNKNOWN
+}
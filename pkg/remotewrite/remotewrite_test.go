@@ -0,0 +1,462 @@
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/thanos-io/thanosbench/pkg/blockgen"
+	"github.com/thanos-io/thanosbench/pkg/seriesgen"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// receiver is a stand-in remote write receiver, responding with given status codes first.
+type receiver struct {
+	t     *testing.T
+	codes []int
+	// v1Only rejects remote write 2.0 requests as unsupported.
+	v1Only bool
+
+	mtx        sync.Mutex
+	requests   []*prompb.WriteRequest
+	latest     int64
+	samples    map[string]int
+	histograms int
+	exemplars  int
+	metadata   int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	testutil.Equals(r.t, "snappy", req.Header.Get("Content-Encoding"))
+	testutil.Equals(r.t, "tenant-a", req.Header.Get("THANOS-TENANT"))
+
+	var (
+		wr  *prompb.WriteRequest
+		err error
+	)
+	switch req.Header.Get("Content-Type") {
+	case "application/x-protobuf":
+		testutil.Equals(r.t, "0.1.0", req.Header.Get("X-Prometheus-Remote-Write-Version"))
+		wr, err = remote.DecodeWriteRequest(req.Body)
+	case "application/x-protobuf;proto=io.prometheus.write.v2.Request":
+		if r.v1Only {
+			http.Error(w, "unsupported", http.StatusUnsupportedMediaType)
+			return
+		}
+		testutil.Equals(r.t, "2.0.0", req.Header.Get("X-Prometheus-Remote-Write-Version"))
+		wr, err = decodeV2(req.Body)
+	default:
+		r.t.Errorf("unexpected content type %s", req.Header.Get("Content-Type"))
+	}
+	testutil.Ok(r.t, err)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if len(r.codes) > 0 {
+		code := r.codes[0]
+		r.codes = r.codes[1:]
+		if code == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "0")
+		}
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	r.requests = append(r.requests, wr)
+	for _, ts := range wr.Timeseries {
+		name := ""
+		for _, l := range ts.Labels {
+			if l.Name == labels.MetricName {
+				name = l.Value
+			}
+		}
+		r.samples[name] += len(ts.Samples)
+		for _, s := range ts.Samples {
+			if s.Timestamp > r.latest {
+				r.latest = s.Timestamp
+			}
+		}
+		r.histograms += len(ts.Histograms)
+		r.exemplars += len(ts.Exemplars)
+	}
+	r.metadata += len(wr.Metadata)
+	if req.Header.Get("X-Prometheus-Remote-Write-Version") == "2.0.0" {
+		var samples, histograms, exemplars int
+		for _, ts := range wr.Timeseries {
+			samples += len(ts.Samples)
+			histograms += len(ts.Histograms)
+			exemplars += len(ts.Exemplars)
+		}
+		w.Header().Set("X-Prometheus-Remote-Write-Samples-Written", strconv.Itoa(samples))
+		w.Header().Set("X-Prometheus-Remote-Write-Histograms-Written", strconv.Itoa(histograms))
+		w.Header().Set("X-Prometheus-Remote-Write-Exemplars-Written", strconv.Itoa(exemplars))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeV2 decodes io.prometheus.write.v2.Request into remote write 1.0 request, with metadata of every series.
+func decodeV2(r io.Reader) (*prompb.WriteRequest, error) {
+	compressed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		syms []string
+		tss  [][]byte
+	)
+	if err := forEachField(b, func(num protowire.Number, v uint64, msg []byte) error {
+		switch num {
+		case requestSymbols:
+			syms = append(syms, string(msg))
+		case requestTimeseries:
+			tss = append(tss, msg)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if len(syms) == 0 || syms[0] != "" {
+		return nil, errors.New("first symbol has to be empty")
+	}
+	symbol := func(ref uint64) (string, error) {
+		if ref >= uint64(len(syms)) {
+			return "", errors.Errorf("symbol %d out of range", ref)
+		}
+		return syms[ref], nil
+	}
+	lbls := func(packed []byte) ([]prompb.Label, error) {
+		var (
+			res  []prompb.Label
+			refs []uint64
+		)
+		for len(packed) > 0 {
+			v, n := protowire.ConsumeVarint(packed)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			refs = append(refs, v)
+			packed = packed[n:]
+		}
+		for i := 0; i+1 < len(refs); i += 2 {
+			name, err := symbol(refs[i])
+			if err != nil {
+				return nil, err
+			}
+			value, err := symbol(refs[i+1])
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, prompb.Label{Name: name, Value: value})
+		}
+		return res, nil
+	}
+
+	res := &prompb.WriteRequest{}
+	for _, tsb := range tss {
+		var ts prompb.TimeSeries
+		if err := forEachField(tsb, func(num protowire.Number, v uint64, msg []byte) (err error) {
+			switch num {
+			case seriesLabelsRefs:
+				ts.Labels, err = lbls(msg)
+			case seriesSamples:
+				var s prompb.Sample
+				err = forEachField(msg, func(num protowire.Number, v uint64, _ []byte) error {
+					switch num {
+					case sampleValue:
+						s.Value = math.Float64frombits(v)
+					case sampleTimestamp:
+						s.Timestamp = int64(v)
+					}
+					return nil
+				})
+				ts.Samples = append(ts.Samples, s)
+			case seriesHistograms:
+				var h prompb.Histogram
+				err = h.Unmarshal(msg)
+				ts.Histograms = append(ts.Histograms, h)
+			case seriesExemplars:
+				var e prompb.Exemplar
+				err = forEachField(msg, func(num protowire.Number, v uint64, msg []byte) (err error) {
+					switch num {
+					case exemplarLabelsRefs:
+						e.Labels, err = lbls(msg)
+					case exemplarValue:
+						e.Value = math.Float64frombits(v)
+					case exemplarTimestamp:
+						e.Timestamp = int64(v)
+					}
+					return err
+				})
+				ts.Exemplars = append(ts.Exemplars, e)
+			case seriesMetadata:
+				m := prompb.MetricMetadata{}
+				err = forEachField(msg, func(num protowire.Number, v uint64, _ []byte) (err error) {
+					switch num {
+					case metadataType:
+						m.Type = prompb.MetricMetadata_MetricType(v)
+					case metadataHelpRef:
+						m.Help, err = symbol(v)
+					case metadataUnitRef:
+						m.Unit, err = symbol(v)
+					}
+					return err
+				})
+				res.Metadata = append(res.Metadata, m)
+			case seriesCreatedTimestamp:
+				if v == 0 {
+					err = errors.New("created timestamp not set")
+				}
+			}
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		res.Timeseries = append(res.Timeseries, ts)
+	}
+	return res, nil
+}
+
+// forEachField calls f with number and either varint or fixed64 value or bytes of every field of the message.
+func forEachField(b []byte, f func(num protowire.Number, v uint64, msg []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var (
+			v   uint64
+			msg []byte
+		)
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			msg, n = protowire.ConsumeBytes(b)
+		default:
+			return errors.Errorf("unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := f(num, v, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func testConfig(interval time.Duration) Config {
+	common := seriesgen.Characteristics{ScrapeInterval: interval, Min: 10, Max: 20}
+	withExemplars := common
+	withExemplars.Exemplars = seriesgen.Exemplars{Rate: 1}
+	native := common
+	native.NativeHistogram = seriesgen.NativeHistogramCharacteristics{Schema: 3, Observations: seriesgen.Distribution{Type: seriesgen.Exponential, Mean: 1}}
+
+	return Config{
+		Series: []blockgen.SeriesSpec{
+			{Labels: labels.FromStrings(labels.MetricName, "requests_total"), Targets: 4, Type: blockgen.Counter, Characteristics: withExemplars},
+			{Labels: labels.FromStrings(labels.MetricName, "latency_seconds"), Targets: 1, Type: blockgen.Histogram, Characteristics: common},
+			{Labels: labels.FromStrings(labels.MetricName, "size_bytes"), Targets: 1, Type: blockgen.NativeHistogram, Characteristics: native},
+		},
+		Queue: QueueConfig{
+			Shards:            3,
+			MaxSamplesPerSend: 50,
+			MinBackoff:        time.Millisecond,
+			MaxBackoff:        10 * time.Millisecond,
+			Headers:           map[string]string{"THANOS-TENANT": "tenant-a"},
+		},
+	}
+}
+
+func TestWriter_Backfill(t *testing.T) {
+	t.Run("retries", func(t *testing.T) {
+		r := &receiver{t: t, samples: map[string]int{}, codes: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusInternalServerError}}
+		srv := httptest.NewServer(r)
+		defer srv.Close()
+
+		c := testConfig(15 * time.Second)
+		c.Queue.RetryOnRateLimit = true
+		w, err := New(log.NewNopLogger(), c, srv.URL, labels.FromStrings("seed", "test"))
+		testutil.Ok(t, err)
+
+		mint := timestamp.FromTime(time.Unix(1700000000, 0))
+		testutil.Ok(t, w.Backfill(context.Background(), mint, mint+time.Hour.Milliseconds()-1))
+
+		// Every target of the counter has a sample every 15s.
+		testutil.Equals(t, 4*240, r.samples["requests_total"])
+		testutil.Assert(t, r.samples["latency_seconds_bucket"] > 0, "expected classic histogram samples")
+		testutil.Equals(t, 240, r.histograms)
+		testutil.Assert(t, r.exemplars > 0, "expected exemplars")
+		testutil.Equals(t, 3, r.metadata)
+		for _, req := range r.requests {
+			var n int
+			for _, ts := range req.Timeseries {
+				n += len(ts.Samples) + len(ts.Histograms) + len(ts.Exemplars)
+			}
+			testutil.Assert(t, n <= 51, "too many samples in request: %d", n)
+		}
+
+		s := w.Stats()
+		testutil.Equals(t, len(r.requests)+3, s.Requests)
+		testutil.Equals(t, 3, s.Retried)
+		testutil.Equals(t, 0, s.Failed)
+		testutil.Equals(t, map[int]int{http.StatusNoContent: len(r.requests), http.StatusServiceUnavailable: 1, http.StatusTooManyRequests: 1, http.StatusInternalServerError: 1}, s.Responses)
+		var samples int
+		for _, n := range r.samples {
+			samples += n
+		}
+		testutil.Equals(t, samples, s.Samples)
+		testutil.Equals(t, r.histograms, s.Histograms)
+		testutil.Equals(t, r.exemplars, s.Exemplars)
+		testutil.Equals(t, 3, s.Metadata)
+		var attempts int
+		for _, n := range s.Latencies {
+			attempts += n
+		}
+		testutil.Equals(t, s.Requests, attempts)
+	})
+	t.Run("dropped", func(t *testing.T) {
+		// Requests rejected as invalid or rate limited without retries enabled are dropped, as Prometheus does.
+		r := &receiver{t: t, samples: map[string]int{}, codes: []int{http.StatusBadRequest, http.StatusTooManyRequests}}
+		srv := httptest.NewServer(r)
+		defer srv.Close()
+
+		c := testConfig(15 * time.Second)
+		c.Series = c.Series[:1]
+		c.Queue.Shards = 1
+		w, err := New(log.NewNopLogger(), c, srv.URL, labels.FromStrings("seed", "test"))
+		testutil.Ok(t, err)
+
+		mint := timestamp.FromTime(time.Unix(1700000000, 0))
+		testutil.Ok(t, w.Backfill(context.Background(), mint, mint+time.Hour.Milliseconds()-1))
+
+		s := w.Stats()
+		testutil.Equals(t, 2, s.Failed)
+		testutil.Equals(t, 0, s.Retried)
+		// Metadata was dropped with the first request.
+		testutil.Equals(t, 0, s.Metadata)
+		testutil.Equals(t, 0, r.metadata)
+		testutil.Assert(t, s.Samples+s.Exemplars < 4*240*2, "expected dropped samples")
+		testutil.Equals(t, s.Samples, r.samples["requests_total"])
+	})
+}
+
+func TestWriter_V2(t *testing.T) {
+	mint := timestamp.FromTime(time.Unix(1700000000, 0))
+	write := func(t *testing.T, r *receiver, message string) Stats {
+		srv := httptest.NewServer(r)
+		defer srv.Close()
+
+		c := testConfig(15 * time.Second)
+		c.ProtobufMessage = message
+		w, err := New(log.NewNopLogger(), c, srv.URL, labels.FromStrings("seed", "test"))
+		testutil.Ok(t, err)
+		testutil.Ok(t, w.Backfill(context.Background(), mint, mint+time.Hour.Milliseconds()-1))
+		return w.Stats()
+	}
+
+	v1 := &receiver{t: t, samples: map[string]int{}}
+	s1 := write(t, v1, MessageV1)
+	v2 := &receiver{t: t, samples: map[string]int{}}
+	s2 := write(t, v2, MessageV2)
+
+	// The same series are written with both protocols.
+	testutil.Equals(t, v1.samples, v2.samples)
+	testutil.Equals(t, v1.histograms, v2.histograms)
+	testutil.Equals(t, v1.exemplars, v2.exemplars)
+	testutil.Equals(t, s1.Samples, s2.Samples)
+
+	// Receiver reports written samples with 2.0 only.
+	testutil.Equals(t, 0, s1.WrittenSamples)
+	testutil.Equals(t, s2.Samples, s2.WrittenSamples)
+	testutil.Equals(t, s2.Histograms, s2.WrittenHistograms)
+	testutil.Equals(t, s2.Exemplars, s2.WrittenExemplars)
+	testutil.Assert(t, s1.Bytes > 0 && s2.Bytes > 0, "expected written bytes")
+
+	// Metadata is attached to every series instead of separate requests.
+	testutil.Equals(t, 0, s2.Metadata)
+	var series int
+	for _, req := range v2.requests {
+		series += len(req.Timeseries)
+		for _, m := range req.Metadata {
+			testutil.Assert(t, m.Type != prompb.MetricMetadata_UNKNOWN && m.Help != "", "unexpected metadata %v", m)
+		}
+	}
+	testutil.Equals(t, series, v2.metadata)
+
+	t.Run("fallback", func(t *testing.T) {
+		r := &receiver{t: t, samples: map[string]int{}, v1Only: true}
+		s := write(t, r, MessageV2)
+		testutil.Equals(t, v1.samples, r.samples)
+		testutil.Equals(t, 3, r.metadata)
+		testutil.Equals(t, 0, s.Failed)
+		testutil.Assert(t, s.Responses[http.StatusUnsupportedMediaType] > 0, "expected rejected 2.0 requests")
+	})
+}
+
+func TestWriter_RealTime(t *testing.T) {
+	r := &receiver{t: t, samples: map[string]int{}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	c := testConfig(50 * time.Millisecond)
+	c.Queue.BatchSendDeadline = 50 * time.Millisecond
+	w, err := New(log.NewNopLogger(), c, srv.URL, labels.FromStrings("seed", "test"))
+	testutil.Ok(t, err)
+
+	start := time.Now()
+	testutil.Ok(t, w.RealTime(context.Background(), start.Add(500*time.Millisecond)))
+	// Writer is done with the last sample, up to a scrape interval before the end.
+	testutil.Assert(t, time.Since(start) >= 450*time.Millisecond, "samples should be written in wall-clock time")
+	testutil.Assert(t, r.latest <= timestamp.FromTime(time.Now()), "samples from the future")
+	testutil.Assert(t, r.samples["requests_total"] > 4*5, "expected samples, got %d", r.samples["requests_total"])
+
+	// Writing stops once context is canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	testutil.Equals(t, context.DeadlineExceeded, w.RealTime(ctx, time.Time{}))
+}
+
+func TestStats_Latency(t *testing.T) {
+	var s Stats
+	testutil.Equals(t, time.Duration(0), s.Latency(0.5))
+
+	for i := 0; i < 90; i++ {
+		s.observeLatency(500 * time.Microsecond)
+	}
+	for i := 0; i < 10; i++ {
+		s.observeLatency(3 * time.Second)
+	}
+	testutil.Equals(t, len(latencyBuckets)+1, len(s.Latencies))
+	testutil.Equals(t, 90, s.Latencies[0])
+	testutil.Equals(t, time.Millisecond*50/90, s.Latency(0.5))
+	testutil.Equals(t, 2048*time.Millisecond+2048*time.Millisecond*9/10, s.Latency(0.99))
+
+	s.observeLatency(time.Hour)
+	testutil.Equals(t, latencyBuckets[len(latencyBuckets)-1], s.Latency(1))
+}
@@ -0,0 +1,172 @@
+package remotewrite
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf messages of remote write protocols, as in protobuf_message of Prometheus remote write config.
+const (
+	// MessageV1 is prometheus.WriteRequest of remote write 1.0.
+	MessageV1 = "prometheus.WriteRequest"
+	// MessageV2 is io.prometheus.write.v2.Request of remote write 2.0, with labels, help and unit interned in a symbol
+	// table and metadata attached to every series.
+	MessageV2 = "io.prometheus.write.v2.Request"
+)
+
+// Response headers of remote write 2.0 receivers with the number of written samples, histograms and exemplars.
+const (
+	writtenSamplesHeader    = "X-Prometheus-Remote-Write-Samples-Written"
+	writtenHistogramsHeader = "X-Prometheus-Remote-Write-Histograms-Written"
+	writtenExemplarsHeader  = "X-Prometheus-Remote-Write-Exemplars-Written"
+)
+
+func contentType(message string) string {
+	if message == MessageV2 {
+		return "application/x-protobuf;proto=" + MessageV2
+	}
+	return "application/x-protobuf"
+}
+
+func protocolVersion(message string) string {
+	if message == MessageV2 {
+		return "2.0.0"
+	}
+	return "0.1.0"
+}
+
+// Field numbers of io.prometheus.write.v2 messages. The generated Go code is not available in the Prometheus version
+// we depend on, so requests are encoded by hand.
+const (
+	requestSymbols    = 4
+	requestTimeseries = 5
+
+	seriesLabelsRefs       = 1
+	seriesSamples          = 2
+	seriesHistograms       = 3
+	seriesExemplars        = 4
+	seriesMetadata         = 5
+	seriesCreatedTimestamp = 6
+
+	exemplarLabelsRefs = 1
+	exemplarValue      = 2
+	exemplarTimestamp  = 3
+
+	sampleValue     = 1
+	sampleTimestamp = 2
+
+	metadataType    = 1
+	metadataHelpRef = 3
+	metadataUnitRef = 4
+)
+
+// symbols is a symbol table of remote write 2.0 request. The first symbol is always an empty string.
+type symbols struct {
+	refs  map[string]uint32
+	table []string
+}
+
+func newSymbols() *symbols {
+	return &symbols{refs: map[string]uint32{"": 0}, table: []string{""}}
+}
+
+func (s *symbols) ref(v string) uint32 {
+	if ref, ok := s.refs[v]; ok {
+		return ref
+	}
+	ref := uint32(len(s.table))
+	s.refs[v] = ref
+	s.table = append(s.table, v)
+	return ref
+}
+
+func (s *symbols) appendLabelsRefs(b []byte, num protowire.Number, lbls []prompb.Label) []byte {
+	var packed []byte
+	for _, l := range lbls {
+		packed = protowire.AppendVarint(packed, uint64(s.ref(l.Name)))
+		packed = protowire.AppendVarint(packed, uint64(s.ref(l.Value)))
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, packed)
+}
+
+// encodeV2 encodes series of the batch as io.prometheus.write.v2.Request. Every series carries metadata and, for
+// counters, histograms and summaries, created timestamp of its series.
+func encodeV2(b *batch) ([]byte, error) {
+	var (
+		syms = newSymbols()
+		tss  []byte
+	)
+	for i, ts := range b.series {
+		s := b.refs[i]
+
+		var m []byte
+		m = syms.appendLabelsRefs(m, seriesLabelsRefs, ts.Labels)
+		for _, smpl := range ts.Samples {
+			var sm []byte
+			sm = protowire.AppendTag(sm, sampleValue, protowire.Fixed64Type)
+			sm = protowire.AppendFixed64(sm, math.Float64bits(smpl.Value))
+			sm = protowire.AppendTag(sm, sampleTimestamp, protowire.VarintType)
+			sm = protowire.AppendVarint(sm, uint64(smpl.Timestamp))
+			m = protowire.AppendTag(m, seriesSamples, protowire.BytesType)
+			m = protowire.AppendBytes(m, sm)
+		}
+		for _, h := range ts.Histograms {
+			// Histogram of remote write 2.0 has the same fields as of 1.0.
+			hb, err := h.Marshal()
+			if err != nil {
+				return nil, errors.Wrap(err, "marshal histogram")
+			}
+			m = protowire.AppendTag(m, seriesHistograms, protowire.BytesType)
+			m = protowire.AppendBytes(m, hb)
+		}
+		for _, e := range ts.Exemplars {
+			var em []byte
+			em = syms.appendLabelsRefs(em, exemplarLabelsRefs, e.Labels)
+			em = protowire.AppendTag(em, exemplarValue, protowire.Fixed64Type)
+			em = protowire.AppendFixed64(em, math.Float64bits(e.Value))
+			em = protowire.AppendTag(em, exemplarTimestamp, protowire.VarintType)
+			em = protowire.AppendVarint(em, uint64(e.Timestamp))
+			m = protowire.AppendTag(m, seriesExemplars, protowire.BytesType)
+			m = protowire.AppendBytes(m, em)
+		}
+
+		var mm []byte
+		if typ := metricTypeProto(s.meta.Type); typ != prompb.MetricMetadata_UNKNOWN {
+			mm = protowire.AppendTag(mm, metadataType, protowire.VarintType)
+			mm = protowire.AppendVarint(mm, uint64(typ))
+		}
+		if s.meta.Help != "" {
+			mm = protowire.AppendTag(mm, metadataHelpRef, protowire.VarintType)
+			mm = protowire.AppendVarint(mm, uint64(syms.ref(s.meta.Help)))
+		}
+		if s.meta.Unit != "" {
+			mm = protowire.AppendTag(mm, metadataUnitRef, protowire.VarintType)
+			mm = protowire.AppendVarint(mm, uint64(syms.ref(s.meta.Unit)))
+		}
+		if len(mm) > 0 {
+			m = protowire.AppendTag(m, seriesMetadata, protowire.BytesType)
+			m = protowire.AppendBytes(m, mm)
+		}
+
+		switch s.meta.Type {
+		case textparse.MetricTypeCounter, textparse.MetricTypeHistogram, textparse.MetricTypeSummary:
+			m = protowire.AppendTag(m, seriesCreatedTimestamp, protowire.VarintType)
+			m = protowire.AppendVarint(m, uint64(s.created))
+		}
+
+		tss = protowire.AppendTag(tss, requestTimeseries, protowire.BytesType)
+		tss = protowire.AppendBytes(tss, m)
+	}
+
+	var req []byte
+	for _, sym := range syms.table {
+		req = protowire.AppendTag(req, requestSymbols, protowire.BytesType)
+		req = protowire.AppendString(req, sym)
+	}
+	return append(req, tss...), nil
+}
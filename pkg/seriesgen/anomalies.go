@@ -0,0 +1,174 @@
+package seriesgen
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+type AnomalyType string
+
+const (
+	// Spike adds Magnitude to values. Spike without Duration affects a single sample.
+	Spike AnomalyType = "spike"
+	// Step adds Magnitude to values, e.g sudden increase of latency after rollout.
+	Step AnomalyType = "step"
+	// Drop multiplies values by Magnitude, e.g 0 for traffic dropping to zero.
+	Drop AnomalyType = "drop"
+	// Flatline repeats the value from before the event, e.g stuck exporter.
+	Flatline AnomalyType = "flatline"
+)
+
+// Anomaly is a rare event injected into otherwise regular series.
+// Anomaly starts either at fixed Start or randomly with given Probability on every sample. It lasts Duration, or until
+// the end of the series if Duration is not set (except for spikes that affect a single sample then).
+type Anomaly struct {
+	Type AnomalyType `yaml:"type"`
+
+	// Start is the time of the event, relative to series MinTime. Used only if Probability is not set.
+	Start time.Duration `yaml:"start"`
+	// Probability is a chance of the event starting on every sample.
+	Probability float64       `yaml:"probability"`
+	Duration    time.Duration `yaml:"duration"`
+
+	Magnitude float64 `yaml:"magnitude"`
+}
+
+func (a Anomaly) Validate() error {
+	switch a.Type {
+	case Spike, Step, Drop, Flatline:
+	default:
+		return errors.Errorf("unknown anomaly type: %s", a.Type)
+	}
+	if a.Probability < 0 || a.Probability > 1 {
+		return errors.Errorf("%s anomaly: probability %v out of [0, 1] range", a.Type, a.Probability)
+	}
+	if a.Duration < 0 {
+		return errors.Errorf("%s anomaly: negative duration %v", a.Type, a.Duration)
+	}
+	return nil
+}
+
+type anomalyState struct {
+	Anomaly
+
+	active, fired bool
+	end           int64
+	// flat is the value repeated by flatline.
+	flat float64
+}
+
+// begin starts the event at the given time, first affecting the sample with timestamp t.
+func (s *anomalyState) begin(start, t int64, prev float64) {
+	s.fired = true
+	s.flat = prev
+	s.end = start + s.Duration.Milliseconds()
+	if s.Duration == 0 {
+		s.end = math.MaxInt64
+		if s.Type == Spike {
+			s.end = t + 1
+		}
+	}
+	// Sample could be already after the end of the event.
+	s.active = t < s.end
+}
+
+func (s *anomalyState) apply(v float64) float64 {
+	switch s.Type {
+	case Spike, Step:
+		return v + s.Magnitude
+	case Drop:
+		return v * s.Magnitude
+	case Flatline:
+		return s.flat
+	}
+	return v
+}
+
+var _ HistogramSeriesIterator = &AnomalyIterator{}
+
+// AnomalyIterator injects anomalies into float samples of the wrapped iterator, e.g so min and max aggregates of
+// downsampled chunks or max_over_time queries have something to find. Native histograms are not changed.
+// Random events depend only on given random and timestamps, so iterators wrapped with the same seed have the same
+// events (e.g all series of one histogram).
+type AnomalyIterator struct {
+	pointIterator
+
+	it   SeriesIterator
+	hit  HistogramSeriesIterator
+	mint int64
+
+	states []anomalyState
+	// prev is the last returned float value, if any.
+	prev    float64
+	hasPrev bool
+
+	random *rand.Rand
+}
+
+func NewAnomalyIterator(random *rand.Rand, mint int64, it SeriesIterator, anomalies []Anomaly) *AnomalyIterator {
+	hit, _ := it.(HistogramSeriesIterator)
+	states := make([]anomalyState, 0, len(anomalies))
+	for _, a := range anomalies {
+		states = append(states, anomalyState{Anomaly: a})
+	}
+	return &AnomalyIterator{
+		it:     it,
+		hit:    hit,
+		mint:   mint,
+		states: states,
+		random: random,
+	}
+}
+
+func (g *AnomalyIterator) Next() bool {
+	p, ok := fetchPoint(g.it, g.hit)
+	if !ok {
+		return false
+	}
+
+	prev := p.v
+	if g.hasPrev {
+		prev = g.prev
+	}
+	for i := range g.states {
+		s := &g.states[i]
+		// Always draw the same number of random numbers, so events stay the same for all iterators with the same seed.
+		occurs := g.random.Float64() < s.Probability
+
+		if s.active && p.t >= s.end {
+			s.active = false
+		}
+		if s.active {
+			continue
+		}
+		if s.Probability > 0 {
+			if occurs {
+				s.begin(p.t, p.t, prev)
+			}
+			continue
+		}
+		if start := g.mint + s.Start.Milliseconds(); !s.fired && p.t >= start {
+			s.begin(start, p.t, prev)
+		}
+	}
+
+	if p.typ == chunkenc.ValFloat {
+		for i := range g.states {
+			if g.states[i].active {
+				p.v = g.states[i].apply(p.v)
+			}
+		}
+		g.prev, g.hasPrev = p.v, true
+	}
+	g.curr = p
+	return true
+}
+
+// SeekTo fast-forwards through skipped samples, as every sample draws random numbers.
+func (g *AnomalyIterator) SeekTo(t int64) bool { return g.seek(g.Next, t) }
+
+func (g *AnomalyIterator) Err() error { return g.it.Err() }
@@ -4,7 +4,11 @@ import (
 	"context"
 
 	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
 	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -38,13 +42,19 @@ func Append(ctx context.Context, goroutines int, appendable storage.Appendable,
 
 				ref := storage.SeriesRef(0)
 				iter := s.Iterator()
+				hiter, _ := iter.(HistogramSeriesIterator)
+				eiter, _ := iter.(ExemplarSeriesIterator)
+				meta := metadata.Metadata{}
+				if ms, ok := s.(MetadataSeries); ok {
+					meta = ms.Metadata()
+				}
+				first := true
 
 				for iter.Next() {
 					if gctx.Err() != nil {
 						return gctx.Err()
 					}
-					t, v := iter.At()
-					ref, err = app.Append(ref, s.Labels(), t, v)
+					ref, err = appendSample(app, ref, s.Labels(), iter, hiter)
 					if err != nil {
 						if rerr := app.Rollback(); rerr != nil {
 							err = errors.Wrapf(err, "rollback failed: %v", rerr)
@@ -52,6 +62,29 @@ func Append(ctx context.Context, goroutines int, appendable storage.Appendable,
 
 						return errors.Wrap(err, "add sample")
 					}
+					if first && meta != (metadata.Metadata{}) {
+						// Metadata is per series, but series has to exist in the appender first.
+						if _, err := app.UpdateMetadata(ref, s.Labels(), meta); err != nil {
+							if rerr := app.Rollback(); rerr != nil {
+								err = errors.Wrapf(err, "rollback failed: %v", rerr)
+							}
+
+							return errors.Wrap(err, "update metadata")
+						}
+					}
+					first = false
+					if eiter == nil {
+						continue
+					}
+					if e, ok := eiter.AtExemplar(); ok {
+						if err := appendExemplar(app, ref, s.Labels(), e); err != nil {
+							if rerr := app.Rollback(); rerr != nil {
+								err = errors.Wrapf(err, "rollback failed: %v", rerr)
+							}
+
+							return errors.Wrap(err, "add exemplar")
+						}
+					}
 				}
 
 				if err := iter.Err(); err != nil {
@@ -81,3 +114,29 @@ func Append(ctx context.Context, goroutines int, appendable storage.Appendable,
 
 	return g.Wait()
 }
+
+// appendSample appends current sample of the iterator. hiter is the same iterator if it supports native histograms, nil otherwise.
+func appendSample(app storage.Appender, ref storage.SeriesRef, lset labels.Labels, iter SeriesIterator, hiter HistogramSeriesIterator) (storage.SeriesRef, error) {
+	if hiter != nil {
+		switch hiter.ValueType() {
+		case chunkenc.ValHistogram:
+			t, h := hiter.AtHistogram()
+			return app.AppendHistogram(ref, lset, t, h, nil)
+		case chunkenc.ValFloatHistogram:
+			t, fh := hiter.AtFloatHistogram()
+			return app.AppendHistogram(ref, lset, t, nil, fh)
+		}
+	}
+	t, v := iter.At()
+	return app.Append(ref, lset, t, v)
+}
+
+// appendExemplar appends exemplar of the current sample. Same as Prometheus scrape, exemplars older than the last one
+// of the series (e.g attached to out-of-order sample) are skipped.
+func appendExemplar(app storage.Appender, ref storage.SeriesRef, lset labels.Labels, e exemplar.Exemplar) error {
+	_, err := app.AppendExemplar(ref, lset, e)
+	if err == storage.ErrOutOfOrderExemplar || err == storage.ErrDuplicateExemplar {
+		return nil
+	}
+	return err
+}
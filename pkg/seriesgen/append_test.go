@@ -9,12 +9,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/efficientgo/core/testutil"
+	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/model/textparse"
 	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/storage"
-	"github.com/thanos-io/thanos/pkg/testutil"
 )
 
 type testSet struct {
@@ -48,8 +51,11 @@ func (s *testSet) At() Series { return s.curr }
 func (s *testSet) Err() error { return nil }
 
 type testAppendable struct {
-	mtx     sync.Mutex
-	samples map[uint64][]sample
+	mtx        sync.Mutex
+	samples    map[uint64][]sample
+	histograms map[uint64][]int64
+	exemplars  map[uint64][]exemplar.Exemplar
+	metadata   map[uint64][]metadata.Metadata
 }
 
 func (a *testAppendable) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
@@ -64,12 +70,41 @@ func (a *testAppendable) Append(ref storage.SeriesRef, l labels.Labels, t int64,
 	return storage.SeriesRef(hash), nil
 }
 
+func (a *testAppendable) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int64, h *histogram.Histogram, fh *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	hash := uint64(ref)
+	if hash == 0 {
+		hash = l.Hash()
+	}
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if h == nil && fh == nil {
+		return 0, errors.New("no histogram")
+	}
+	a.histograms[hash] = append(a.histograms[hash], t)
+	return storage.SeriesRef(hash), nil
+}
+
 func (a *testAppendable) AppendExemplar(ref storage.SeriesRef, l labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
-	return 0, nil
+	if a.exemplars == nil {
+		return 0, nil
+	}
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	a.exemplars[uint64(ref)] = append(a.exemplars[uint64(ref)], e)
+	return ref, nil
 }
 
 func (a *testAppendable) UpdateMetadata(ref storage.SeriesRef, l labels.Labels, m metadata.Metadata) (storage.SeriesRef, error) {
-	return 0, nil
+	if a.metadata == nil {
+		return 0, nil
+	}
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	a.metadata[uint64(ref)] = append(a.metadata[uint64(ref)], m)
+	return ref, nil
 }
 
 func (a *testAppendable) Commit() error {
@@ -109,3 +144,75 @@ func TestAppend(t *testing.T) {
 	}, a.samples)
 
 }
+
+func TestAppend_NativeHistograms(t *testing.T) {
+	a := &testAppendable{samples: map[uint64][]sample{}, histograms: map[uint64][]int64{}}
+	lset := labels.FromStrings("__name__", "a")
+	s := &testSeriesSet{series: []Series{
+		NewSeriesGen(lset, NewNativeHistogramGen(rand.New(rand.NewSource(1)), 0, 30000, Characteristics{
+			ScrapeInterval: 10 * time.Second,
+		})),
+	}}
+	testutil.Ok(t, Append(context.Background(), 1, a, s))
+	testutil.Equals(t, 0, len(a.samples))
+	testutil.Equals(t, map[uint64][]int64{lset.Hash(): {0, 10000, 20000, 30000}}, a.histograms)
+}
+
+func TestAppend_Exemplars(t *testing.T) {
+	a := &testAppendable{samples: map[uint64][]sample{}, exemplars: map[uint64][]exemplar.Exemplar{}}
+	lset := labels.FromStrings("__name__", "a")
+	s := &testSeriesSet{series: []Series{
+		// Exemplars are passed through other wrapping iterators.
+		NewSeriesGen(lset, NewGapsIterator(rand.New(rand.NewSource(1)), 0, NewExemplarIterator(rand.New(rand.NewSource(1)), NewCounterGen(rand.New(rand.NewSource(1)), 0, 30000, Characteristics{
+			ScrapeInterval: 10 * time.Second,
+			Min:            10,
+			Max:            20,
+		}), Exemplars{Rate: 1, TraceIDCardinality: 1}), Gaps{StaleAtEnd: true})),
+	}}
+	testutil.Ok(t, Append(context.Background(), 1, a, s))
+	testutil.Equals(t, 4, len(a.samples[lset.Hash()]))
+
+	// Staleness marker has no exemplar.
+	exemplars := a.exemplars[lset.Hash()]
+	testutil.Equals(t, 3, len(exemplars))
+	for i, e := range exemplars {
+		testutil.Equals(t, a.samples[lset.Hash()][i].T, e.Ts)
+		testutil.Equals(t, a.samples[lset.Hash()][i].V, e.Value)
+		testutil.Equals(t, exemplars[0].Labels.Get("trace_id"), e.Labels.Get("trace_id"))
+	}
+}
+
+func TestAppend_Metadata(t *testing.T) {
+	a := &testAppendable{samples: map[uint64][]sample{}, metadata: map[uint64][]metadata.Metadata{}}
+	meta := metadata.Metadata{Type: textparse.MetricTypeCounter, Help: "Requests.", Unit: "requests"}
+	lset1, lset2 := labels.FromStrings("__name__", "a"), labels.FromStrings("__name__", "b")
+	s := &testSeriesSet{series: []Series{
+		NewSeriesGenWithMetadata(lset1, NewCounterGen(rand.New(rand.NewSource(1)), 0, 30000, Characteristics{
+			ScrapeInterval: 10 * time.Second,
+		}), meta),
+		NewSeriesGen(lset2, NewCounterGen(rand.New(rand.NewSource(1)), 0, 30000, Characteristics{
+			ScrapeInterval: 10 * time.Second,
+		})),
+	}}
+	testutil.Ok(t, Append(context.Background(), 1, a, s))
+	testutil.Equals(t, 4, len(a.samples[lset1.Hash()]))
+	// Metadata is appended once per series, if any.
+	testutil.Equals(t, map[uint64][]metadata.Metadata{lset1.Hash(): {meta}}, a.metadata)
+}
+
+type testSeriesSet struct {
+	series []Series
+	curr   Series
+}
+
+func (s *testSeriesSet) Next() bool {
+	if len(s.series) == 0 {
+		return false
+	}
+	s.curr, s.series = s.series[0], s.series[1:]
+	return true
+}
+
+func (s *testSeriesSet) At() Series { return s.curr }
+
+func (s *testSeriesSet) Err() error { return nil }
@@ -0,0 +1,150 @@
+package seriesgen
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+const (
+	// calibrationSeries and calibrationChunks configure how many XOR chunks of 120 samples (as Prometheus cuts them
+	// for regular scrape intervals) are encoded to measure bytes per sample of each candidate.
+	calibrationSeries = 4
+	calibrationChunks = 2
+	samplesPerChunk   = 120
+
+	// calibrationEpsilon is relative error of bytes per sample good enough to not try timestamp jitter.
+	calibrationEpsilon = 0.02
+)
+
+var (
+	// calibrationChangeEvery are candidate numbers of scrapes between gauge value changes.
+	calibrationChangeEvery = []int64{1, 2, 3, 4, 5, 6, 8, 10, 12, 15, 20, 30, 40, 60, 120, 240}
+	// calibrationTimestampJitters are candidate timestamp jitters, used only if target can't be reached with perfectly
+	// regular timestamps, as timestamps are usually cheap.
+	calibrationTimestampJitters = []time.Duration{0, 1 * time.Millisecond, 50 * time.Millisecond, 500 * time.Millisecond}
+)
+
+type calibrationKey struct {
+	target         float64
+	scrapeInterval time.Duration
+	min, max       float64
+	dist           Distribution
+	rounding       Rounding
+}
+
+var calibrations sync.Map
+
+// CalibrateBytesPerSample returns characteristics with ChangeInterval, Jitter and TimestampJitter chosen so gauge
+// generated with them is encoded into XOR chunks of around BytesPerSample bytes per sample, and the estimated bytes
+// per sample. Candidates are encoded into real chunks, so the result accounts for Min, Max, Distribution and Rounding.
+// Results are cached, as calibration takes a while and all series of a spec share characteristics.
+func CalibrateBytesPerSample(opts Characteristics) (Characteristics, float64) {
+	if opts.BytesPerSample <= 0 || opts.ScrapeInterval <= 0 {
+		return opts, 0
+	}
+
+	key := calibrationKey{
+		target:         opts.BytesPerSample,
+		scrapeInterval: opts.ScrapeInterval,
+		min:            opts.Min,
+		max:            opts.Max,
+		dist:           opts.Distribution,
+		rounding:       opts.Rounding,
+	}
+	if c, ok := calibrations.Load(key); ok {
+		return c.(calibration).apply(opts)
+	}
+	c := calibrate(opts)
+	calibrations.Store(key, c)
+	return c.apply(opts)
+}
+
+type calibration struct {
+	changeInterval  time.Duration
+	jitter          float64
+	timestampJitter time.Duration
+
+	bytesPerSample float64
+}
+
+func (c calibration) apply(opts Characteristics) (Characteristics, float64) {
+	opts.ChangeInterval = c.changeInterval
+	opts.Jitter = c.jitter
+	opts.TimestampJitter = c.timestampJitter
+	return opts, c.bytesPerSample
+}
+
+func calibrate(opts Characteristics) calibration {
+	// Jitter is relative to the typical value, as only bits that differ between consecutive values are encoded.
+	scale := math.Abs(opts.Min+opts.Max) / 2
+	if opts.Distribution != (Distribution{}) {
+		lo, hi := opts.Distribution.Range()
+		scale = math.Abs(lo+hi) / 2
+	}
+	if scale == 0 {
+		scale = 1
+	}
+
+	var best calibration
+	for _, tsJitter := range calibrationTimestampJitters {
+		if tsJitter > 0 && tsJitter >= opts.ScrapeInterval/2 {
+			break
+		}
+		for _, n := range calibrationChangeEvery {
+			// From the largest jitter, changing almost all bits of values, down to changing just the last ones.
+			for k := 0; k <= 48; k++ {
+				c := calibration{
+					changeInterval:  time.Duration(n) * opts.ScrapeInterval,
+					jitter:          scale * math.Pow(10, -float64(k)/4),
+					timestampJitter: tsJitter,
+				}
+				c.bytesPerSample = c.measure(opts)
+				if best.bytesPerSample == 0 || math.Abs(c.bytesPerSample-opts.BytesPerSample) < math.Abs(best.bytesPerSample-opts.BytesPerSample) {
+					best = c
+				}
+			}
+		}
+		if math.Abs(best.bytesPerSample-opts.BytesPerSample) <= calibrationEpsilon*opts.BytesPerSample {
+			break
+		}
+	}
+	return best
+}
+
+// measure returns average bytes per sample of XOR chunks with gauges generated with the calibration.
+func (c calibration) measure(opts Characteristics) float64 {
+	opts, _ = c.apply(opts)
+	maxt := int64(calibrationChunks*samplesPerChunk)*opts.ScrapeInterval.Milliseconds() - 1
+
+	var bytes, samples int
+	for s := int64(0); s < calibrationSeries; s++ {
+		it := NewTimestampIterator(rand.New(rand.NewSource(s)), 0, maxt, NewGaugeGen(rand.New(rand.NewSource(s)), 0, maxt, opts), 0, opts.TimestampJitter, opts.ScrapeInterval)
+
+		var (
+			chk = chunkenc.NewXORChunk()
+			app chunkenc.Appender
+		)
+		for it.Next() {
+			if chk.NumSamples() == samplesPerChunk {
+				bytes += len(chk.Bytes())
+				chk = chunkenc.NewXORChunk()
+				app = nil
+			}
+			if app == nil {
+				// Error is impossible for a new XOR chunk.
+				app, _ = chk.Appender()
+			}
+			app.Append(it.At())
+			samples++
+		}
+		bytes += len(chk.Bytes())
+	}
+	if samples == 0 {
+		return 0
+	}
+	return float64(bytes) / float64(samples)
+}
@@ -0,0 +1,244 @@
+package seriesgen
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/pkg/errors"
+)
+
+type DistributionType string
+
+const (
+	Uniform     DistributionType = "uniform"
+	Normal      DistributionType = "normal"
+	Exponential DistributionType = "exponential"
+	LogNormal   DistributionType = "lognormal"
+	Poisson     DistributionType = "poisson"
+	Zipf        DistributionType = "zipf"
+)
+
+// Distribution describes a probability distribution of generated values.
+// Only fields relevant for the given Type are used:
+//   - uniform: Min, Max
+//   - normal: Mean, StdDev
+//   - exponential: Mean
+//   - lognormal: Mean, StdDev of the logarithm of values, so the median is exp(Mean)
+//   - poisson: Mean, values are integers
+//   - zipf: Min, Max, Exponent; integers from [Min, Max] with probability of Min+k proportional to (1+k)^-Exponent
+type Distribution struct {
+	Type DistributionType `yaml:"type"`
+
+	Min      float64 `yaml:"min"`
+	Max      float64 `yaml:"max"`
+	Mean     float64 `yaml:"mean"`
+	StdDev   float64 `yaml:"stdDev"`
+	Exponent float64 `yaml:"exponent"`
+}
+
+func (d Distribution) Validate() error {
+	switch d.Type {
+	case Uniform, "":
+		if d.Max < d.Min {
+			return errors.Errorf("uniform distribution: max %v lower than min %v", d.Max, d.Min)
+		}
+	case Normal:
+		if d.StdDev < 0 {
+			return errors.Errorf("normal distribution: negative stdDev %v", d.StdDev)
+		}
+	case Exponential:
+		if d.Mean <= 0 {
+			return errors.Errorf("exponential distribution: mean has to be positive, got %v", d.Mean)
+		}
+	case LogNormal:
+		if d.StdDev < 0 {
+			return errors.Errorf("lognormal distribution: negative stdDev %v", d.StdDev)
+		}
+	case Poisson:
+		if d.Mean < 0 {
+			return errors.Errorf("poisson distribution: negative mean %v", d.Mean)
+		}
+	case Zipf:
+		if d.Exponent <= 1 {
+			return errors.Errorf("zipf distribution: exponent has to be greater than 1, got %v", d.Exponent)
+		}
+		if d.Max < d.Min {
+			return errors.Errorf("zipf distribution: max %v lower than min %v", d.Max, d.Min)
+		}
+	default:
+		return errors.Errorf("unknown distribution type: %s", d.Type)
+	}
+	return nil
+}
+
+// Sample draws a single value from the distribution.
+func (d Distribution) Sample(random *rand.Rand) float64 {
+	switch d.Type {
+	case Normal:
+		return d.Mean + random.NormFloat64()*d.StdDev
+	case Exponential:
+		return random.ExpFloat64() * d.Mean
+	case LogNormal:
+		return math.Exp(d.Mean + random.NormFloat64()*d.StdDev)
+	case Poisson:
+		return poisson(random, d.Mean)
+	case Zipf:
+		return d.Min + float64(rand.NewZipf(random, d.Exponent, 1, uint64(d.Max-d.Min)).Uint64())
+	default:
+		return d.Min + random.Float64()*(d.Max-d.Min)
+	}
+}
+
+// sampler draws values from the distribution with the given random. Constants of the distribution (e.g of Zipf) are
+// computed once, so generators drawing value for every sample use it instead of Sample.
+type sampler struct {
+	d      Distribution
+	random *rand.Rand
+	zipf   *rand.Zipf
+}
+
+// newSampler returns sampler of the distribution, nil if the distribution is not specified.
+func newSampler(random *rand.Rand, d Distribution) *sampler {
+	if d == (Distribution{}) {
+		return nil
+	}
+	s := &sampler{d: d, random: random}
+	if d.Type == Zipf {
+		s.zipf = rand.NewZipf(random, d.Exponent, 1, uint64(d.Max-d.Min))
+	}
+	return s
+}
+
+func (s *sampler) sample() float64 {
+	if s.zipf != nil {
+		return s.d.Min + float64(s.zipf.Uint64())
+	}
+	return s.d.Sample(s.random)
+}
+
+// Range returns values range that contains practically all samples of the distribution.
+func (d Distribution) Range() (lo, hi float64) {
+	switch d.Type {
+	case Normal:
+		return d.Mean - 8*d.StdDev, d.Mean + 8*d.StdDev
+	case Exponential:
+		return 0, 40 * d.Mean
+	case LogNormal:
+		return 0, math.Exp(d.Mean + 8*d.StdDev)
+	case Poisson:
+		return 0, d.Mean + 8*math.Sqrt(d.Mean) + 10
+	default:
+		return d.Min, d.Max
+	}
+}
+
+// CDF returns probability of the sample being lower or equal to x.
+func (d Distribution) CDF(x float64) float64 {
+	switch d.Type {
+	case Normal:
+		if d.StdDev == 0 {
+			if x < d.Mean {
+				return 0
+			}
+			return 1
+		}
+		return 0.5 * math.Erfc(-(x-d.Mean)/(d.StdDev*math.Sqrt2))
+	case Exponential:
+		if x <= 0 {
+			return 0
+		}
+		return 1 - math.Exp(-x/d.Mean)
+	case LogNormal:
+		if x <= 0 {
+			return 0
+		}
+		return Distribution{Type: Normal, Mean: d.Mean, StdDev: d.StdDev}.CDF(math.Log(x))
+	case Poisson:
+		if x < 0 {
+			return 0
+		}
+		// Sum of probabilities of all k <= x, computed iteratively as P(k) = P(k-1) * mean / k.
+		p := math.Exp(-d.Mean)
+		cdf := p
+		for k := 1.0; k <= math.Floor(x) && cdf < 1; k++ {
+			p *= d.Mean / k
+			cdf += p
+		}
+		return math.Min(1, cdf)
+	case Zipf:
+		if x < d.Min {
+			return 0
+		}
+		n := math.Floor(d.Max - d.Min + 1)
+		return math.Min(1, harmonic(math.Floor(x-d.Min+1), d.Exponent)/harmonic(n, d.Exponent))
+	default:
+		if x < d.Min {
+			return 0
+		}
+		if x >= d.Max {
+			return 1
+		}
+		return (x - d.Min) / (d.Max - d.Min)
+	}
+}
+
+// harmonic returns generalized harmonic number, sum of k^-s for k in [1, n]. Large n are approximated by integral.
+func harmonic(n, s float64) float64 {
+	const exact = 1000
+
+	h := 0.0
+	for k := 1.0; k <= math.Min(n, exact); k++ {
+		h += math.Pow(k, -s)
+	}
+	if n > exact {
+		// Euler–Maclaurin approximation of the rest of the sum.
+		h += (math.Pow(exact+0.5, 1-s) - math.Pow(n+0.5, 1-s)) / (s - 1)
+	}
+	return h
+}
+
+// Rounding reduces precision of generated values. Real metrics are often integers or have only few significant
+// digits, which makes them compress much better than random floats.
+type Rounding struct {
+	// Integer rounds values to the nearest integer.
+	Integer bool `yaml:"integer"`
+	// SignificantDigits rounds values to the given number of significant digits, if positive.
+	SignificantDigits int `yaml:"significantDigits"`
+}
+
+// Round returns v rounded according to the configuration.
+func (r Rounding) Round(v float64) float64 {
+	if r.SignificantDigits > 0 && v != 0 && !math.IsNaN(v) && !math.IsInf(v, 0) {
+		pow := math.Pow(10, float64(r.SignificantDigits)-math.Ceil(math.Log10(math.Abs(v))))
+		v = math.Round(v*pow) / pow
+	}
+	if r.Integer {
+		v = math.Round(v)
+	}
+	return v
+}
+
+// poisson draws number of events with the given average lambda.
+func poisson(random *rand.Rand, lambda float64) float64 {
+	if lambda <= 0 {
+		return 0
+	}
+	if lambda > 30 {
+		// Normal approximation is good enough for larger lambdas and way faster.
+		return math.Max(0, math.Round(lambda+random.NormFloat64()*math.Sqrt(lambda)))
+	}
+
+	// Knuth's algorithm.
+	var (
+		l = math.Exp(-lambda)
+		k = 0.0
+		p = 1.0
+	)
+	for {
+		p *= random.Float64()
+		if p <= l {
+			return k
+		}
+		k++
+	}
+}
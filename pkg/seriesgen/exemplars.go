@@ -0,0 +1,119 @@
+package seriesgen
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// ExemplarSeriesIterator is implemented by iterators that attach exemplars to samples.
+type ExemplarSeriesIterator interface {
+	SeriesIterator
+
+	// AtExemplar returns exemplar attached to the current sample, if any.
+	AtExemplar() (exemplar.Exemplar, bool)
+}
+
+// Exemplars describes exemplars attached to samples, e.g trace of the request that was observed.
+type Exemplars struct {
+	// Rate is a fraction of samples with exemplar, between 0 and 1.
+	Rate float64 `yaml:"rate"`
+
+	// TraceIDCardinality is number of distinct trace_id label values. Values are the same for all series with the same
+	// cardinality, as if requests were traced across many series. Zero means every exemplar has unique trace.
+	TraceIDCardinality int `yaml:"traceIDCardinality"`
+	// SpanIDCardinality is number of distinct span_id label values. Zero means every exemplar has unique span.
+	SpanIDCardinality int `yaml:"spanIDCardinality"`
+}
+
+// Enabled returns true if any sample could have exemplar.
+func (e Exemplars) Enabled() bool {
+	return e.Rate > 0
+}
+
+var _ ExemplarSeriesIterator = &ExemplarIterator{}
+var _ HistogramSeriesIterator = &ExemplarIterator{}
+
+// ExemplarIterator attaches exemplars with trace_id and span_id labels to samples of the wrapped iterator.
+// Exemplar value is the sample value, or the average observation for native histograms.
+type ExemplarIterator struct {
+	pointIterator
+
+	it  SeriesIterator
+	hit HistogramSeriesIterator
+	ex  Exemplars
+
+	random *rand.Rand
+}
+
+func NewExemplarIterator(random *rand.Rand, it SeriesIterator, ex Exemplars) *ExemplarIterator {
+	hit, _ := it.(HistogramSeriesIterator)
+	return &ExemplarIterator{
+		it:     it,
+		hit:    hit,
+		ex:     ex,
+		random: random,
+	}
+}
+
+// id returns hex encoded ID of given number of bytes (8 or 16), drawn from cardinality distinct values if cardinality is positive.
+func (g *ExemplarIterator) id(cardinality int, bytes int) string {
+	var v uint64
+	if cardinality > 0 {
+		v = splitmix64(uint64(g.random.Intn(cardinality)))
+	} else {
+		v = g.random.Uint64()
+	}
+	if bytes == 8 {
+		return fmt.Sprintf("%016x", v)
+	}
+	return fmt.Sprintf("%016x%016x", splitmix64(v), v)
+}
+
+func (g *ExemplarIterator) Next() bool {
+	p, ok := fetchPoint(g.it, g.hit)
+	if !ok {
+		return false
+	}
+
+	if g.random.Float64() < g.ex.Rate {
+		v := p.v
+		switch p.typ {
+		case chunkenc.ValHistogram:
+			if p.h.Count > 0 {
+				v = p.h.Sum / float64(p.h.Count)
+			}
+		case chunkenc.ValFloatHistogram:
+			if p.fh.Count > 0 {
+				v = p.fh.Sum / p.fh.Count
+			}
+		}
+		p.e = &exemplar.Exemplar{
+			Labels: labels.FromStrings(
+				"trace_id", g.id(g.ex.TraceIDCardinality, 16),
+				"span_id", g.id(g.ex.SpanIDCardinality, 8),
+			),
+			Value: v,
+			Ts:    p.t,
+			HasTs: true,
+		}
+	}
+	g.curr = p
+	return true
+}
+
+// SeekTo fast-forwards through skipped samples, as every sample draws random numbers.
+func (g *ExemplarIterator) SeekTo(t int64) bool { return g.seek(g.Next, t) }
+
+func (g *ExemplarIterator) Err() error { return g.it.Err() }
+
+// splitmix64 scrambles given value, so IDs derived from small integers look random.
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
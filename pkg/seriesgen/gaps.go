@@ -0,0 +1,119 @@
+package seriesgen
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Gaps describes missing samples of otherwise regular series.
+type Gaps struct {
+	// ScrapeFailureProbability is a chance of each scrape to fail, so sample is missing.
+	ScrapeFailureProbability float64 `yaml:"scrapeFailureProbability"`
+
+	// OutageProbability is a chance of target outage starting on each scrape. Outage lasts OutageDuration.
+	OutageProbability float64       `yaml:"outageProbability"`
+	OutageDuration    time.Duration `yaml:"outageDuration"`
+	// Outages are fixed outage windows, relative to series MinTime.
+	Outages []Outage `yaml:"outages"`
+
+	// StalenessMarkers emits staleness marker (StaleNaN) on the first missed scrape, as Prometheus does when scrape fails.
+	StalenessMarkers bool `yaml:"stalenessMarkers"`
+	// StaleAtEnd replaces the last sample of the series with staleness marker, as Prometheus does when series disappears.
+	StaleAtEnd bool `yaml:"staleAtEnd"`
+}
+
+// Outage is a window without samples.
+type Outage struct {
+	Start    time.Duration `yaml:"start"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// Enabled returns true if any sample could be missing or replaced.
+func (g Gaps) Enabled() bool {
+	return g.ScrapeFailureProbability > 0 || g.OutageProbability > 0 || len(g.Outages) > 0 || g.StaleAtEnd
+}
+
+var _ HistogramSeriesIterator = &GapsIterator{}
+
+// GapsIterator drops samples of the wrapped iterator according to given Gaps. Decisions depend only on given random
+// and timestamps, so iterators wrapped with the same seed miss exactly the same scrapes (e.g all series of one target).
+type GapsIterator struct {
+	pointIterator
+
+	it   SeriesIterator
+	hit  HistogramSeriesIterator
+	gaps Gaps
+	mint int64
+
+	init, hasNext bool
+	next          point
+
+	// emitted is true if any sample was returned, stale is true if the last returned sample was staleness marker.
+	emitted, stale bool
+	outageEnd      int64
+
+	random *rand.Rand
+}
+
+func NewGapsIterator(random *rand.Rand, mint int64, it SeriesIterator, gaps Gaps) *GapsIterator {
+	hit, _ := it.(HistogramSeriesIterator)
+	return &GapsIterator{
+		it:     it,
+		hit:    hit,
+		gaps:   gaps,
+		mint:   mint,
+		random: random,
+	}
+}
+
+func (g *GapsIterator) missing(t int64) bool {
+	// Always draw the same number of random numbers, so decisions stay the same for all iterators with the same seed.
+	failed := g.random.Float64() < g.gaps.ScrapeFailureProbability
+	outage := g.random.Float64() < g.gaps.OutageProbability
+
+	if t < g.outageEnd {
+		return true
+	}
+	if outage {
+		g.outageEnd = t + g.gaps.OutageDuration.Milliseconds()
+		return true
+	}
+	for _, o := range g.gaps.Outages {
+		if start := g.mint + o.Start.Milliseconds(); t >= start && t < start+o.Duration.Milliseconds() {
+			return true
+		}
+	}
+	return failed
+}
+
+func (g *GapsIterator) Next() bool {
+	if !g.init {
+		g.next, g.hasNext = fetchPoint(g.it, g.hit)
+		g.init = true
+	}
+
+	for g.hasNext {
+		p := g.next
+		g.next, g.hasNext = fetchPoint(g.it, g.hit)
+
+		if g.missing(p.t) {
+			if (g.gaps.StalenessMarkers || (g.gaps.StaleAtEnd && !g.hasNext)) && g.emitted && !g.stale {
+				g.curr, g.stale = p.stale(), true
+				return true
+			}
+			continue
+		}
+
+		g.curr, g.emitted, g.stale = p, true, false
+		if g.gaps.StaleAtEnd && !g.hasNext {
+			g.curr, g.stale = p.stale(), true
+		}
+		return true
+	}
+	return false
+}
+
+// SeekTo fast-forwards through skipped samples, as every sample draws random numbers.
+func (g *GapsIterator) SeekTo(t int64) bool { return g.seek(g.Next, t) }
+
+func (g *GapsIterator) Err() error { return g.it.Err() }
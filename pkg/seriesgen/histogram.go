@@ -0,0 +1,243 @@
+package seriesgen
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// DefBuckets are the default classic histogram buckets, same as in Prometheus client_golang.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// HistogramCharacteristics describes classic histogram bucket layout and observations.
+type HistogramCharacteristics struct {
+	// Buckets are explicit bucket upper bounds. +Inf bucket is always added.
+	Buckets []float64 `yaml:"buckets"`
+
+	// BucketStart, BucketFactor, BucketWidth and BucketCount generate buckets if explicit buckets are not specified.
+	// Buckets are exponential if BucketFactor is specified, linear with BucketWidth otherwise.
+	// If none is specified DefBuckets are used.
+	BucketStart  float64 `yaml:"bucketStart"`
+	BucketFactor float64 `yaml:"bucketFactor"`
+	BucketWidth  float64 `yaml:"bucketWidth"`
+	BucketCount  int     `yaml:"bucketCount"`
+
+	// ObservationRate is an average number of observations per second. Defaults to 1.
+	ObservationRate float64 `yaml:"observationRate"`
+	// Observations is the distribution of observed values. Defaults to uniform distribution across all finite buckets.
+	Observations Distribution `yaml:"observations"`
+}
+
+// UpperBounds returns sorted bucket upper bounds including +Inf.
+func (h HistogramCharacteristics) UpperBounds() []float64 {
+	var bounds []float64
+	switch {
+	case len(h.Buckets) > 0:
+		bounds = append(bounds, h.Buckets...)
+	case h.BucketCount > 0 && h.BucketFactor > 1:
+		b := h.BucketStart
+		for i := 0; i < h.BucketCount; i++ {
+			bounds = append(bounds, b)
+			b *= h.BucketFactor
+		}
+	case h.BucketCount > 0 && h.BucketWidth > 0:
+		for i := 0; i < h.BucketCount; i++ {
+			bounds = append(bounds, h.BucketStart+float64(i)*h.BucketWidth)
+		}
+	default:
+		bounds = append(bounds, DefBuckets...)
+	}
+	sort.Float64s(bounds)
+	if !math.IsInf(bounds[len(bounds)-1], +1) {
+		bounds = append(bounds, math.Inf(+1))
+	}
+	return bounds
+}
+
+// Validate returns error if generated buckets are misconfigured, e.g BucketCount without usable BucketFactor or
+// BucketWidth, instead of silently falling back to default buckets, or if bucket upper bounds are duplicated, as
+// duplicated `le` series would be rejected by TSDB.
+func (h HistogramCharacteristics) Validate() error {
+	if len(h.Buckets) == 0 && h.BucketCount > 0 {
+		switch {
+		case h.BucketFactor > 1:
+			if h.BucketStart <= 0 {
+				return errors.Errorf("histogram: exponential buckets need positive bucket start, got %v", h.BucketStart)
+			}
+		case h.BucketWidth <= 0:
+			return errors.Errorf("histogram: bucket count %d needs bucket factor greater than 1 or positive bucket width", h.BucketCount)
+		}
+	}
+
+	bounds := h.UpperBounds()
+	for i := 1; i < len(bounds); i++ {
+		if bounds[i] == bounds[i-1] {
+			return errors.Errorf("histogram: duplicate bucket upper bound %v", bounds[i])
+		}
+	}
+	return nil
+}
+
+const (
+	histogramSum   = -1
+	histogramCount = -2
+)
+
+// HistogramGen generates a classic histogram: cumulative bucket counters, sum and count.
+// Every scrape the number of observations per bucket is drawn from Poisson distribution with mean derived
+// from observation rate and given observation distribution. This keeps all counters monotonic and consistent with each
+// other: the +Inf bucket always equals count.
+// Sum is approximated with bucket midpoints. All counters reset together according to configured Resets.
+type HistogramGen struct {
+	interval         time.Duration
+	maxTime, minTime int64
+	resets           *resetter
+
+	bounds []float64
+	probs  []float64
+	mids   []float64
+	rate   float64
+
+	buckets    []float64
+	sum, count float64
+	init       bool
+
+	random *rand.Rand
+}
+
+func NewHistogramGen(random *rand.Rand, mint, maxt int64, opts Characteristics) *HistogramGen {
+	g := &HistogramGen{
+		interval: opts.ScrapeInterval,
+		minTime:  mint,
+		maxTime:  maxt,
+		bounds:   opts.Histogram.UpperBounds(),
+		rate:     opts.Histogram.ObservationRate,
+		resets:   newResetter(mint, opts.Resets),
+		random:   random,
+	}
+	if g.rate <= 0 {
+		g.rate = 1
+	}
+
+	obs := opts.Histogram.Observations
+	if obs == (Distribution{}) && len(g.bounds) > 1 {
+		obs = Distribution{Type: Uniform, Min: math.Min(0, g.bounds[0]), Max: g.bounds[len(g.bounds)-2]}
+	}
+
+	g.probs = make([]float64, len(g.bounds))
+	g.mids = make([]float64, len(g.bounds))
+	g.buckets = make([]float64, len(g.bounds))
+	prevCDF, lower := 0.0, math.Min(0, g.bounds[0])
+	for i, b := range g.bounds {
+		cdf := obs.CDF(b)
+		g.probs[i] = cdf - prevCDF
+		prevCDF = cdf
+
+		g.mids[i] = (lower + b) / 2
+		if math.IsInf(b, +1) {
+			g.mids[i] = lower
+		}
+		lower = b
+	}
+	return g
+}
+
+// UpperBounds returns upper bounds of the generated buckets.
+func (g *HistogramGen) UpperBounds() []float64 { return g.bounds }
+
+func (g *HistogramGen) Next() bool {
+	if g.init {
+		g.minTime += int64(g.interval.Seconds() * 1000)
+	}
+	if g.minTime > g.maxTime {
+		return false
+	}
+	g.init = true
+
+	if g.resets.Reset(g.random, g.minTime) {
+		g.sum, g.count = 0, 0
+		for i := range g.buckets {
+			g.buckets[i] = 0
+		}
+	}
+
+	var cumulative float64
+	lambda := g.rate * g.interval.Seconds()
+	for i := range g.bounds {
+		n := poisson(g.random, lambda*g.probs[i])
+		cumulative += n
+		g.buckets[i] += cumulative
+		g.sum += n * g.mids[i]
+	}
+	g.count += cumulative
+	return true
+}
+
+// Bucket returns the current cumulative value of i-th bucket.
+func (g *HistogramGen) Bucket(i int) (t int64, v float64) { return g.minTime, g.buckets[i] }
+
+// Sum returns the current sum of observations.
+func (g *HistogramGen) Sum() (t int64, v float64) { return g.minTime, g.sum }
+
+// Count returns the current count of observations.
+func (g *HistogramGen) Count() (t int64, v float64) { return g.minTime, g.count }
+
+func (g *HistogramGen) Err() error { return nil }
+
+type histogramComponentIter struct {
+	g         *HistogramGen
+	component int
+}
+
+func (it *histogramComponentIter) Next() bool { return it.g.Next() }
+
+func (it *histogramComponentIter) At() (int64, float64) {
+	switch it.component {
+	case histogramSum:
+		return it.g.Sum()
+	case histogramCount:
+		return it.g.Count()
+	default:
+		return it.g.Bucket(it.component)
+	}
+}
+
+// SeekTo fast-forwards through skipped samples, as every sample draws random numbers.
+func (it *histogramComponentIter) SeekTo(t int64) bool {
+	return seekNext(it, it.g.init, it.g.minTime, t)
+}
+
+func (it *histogramComponentIter) Err() error { return it.g.Err() }
+
+// NewHistogramSeries expands given labels into classic histogram series: <name>_bucket for each upper bound,
+// <name>_sum and <name>_count. Every series has its own generator created with the same seed, so all of them
+// replay exactly the same observations.
+func NewHistogramSeries(lset labels.Labels, seed int64, mint, maxt int64, opts Characteristics) []Series {
+	newGen := func() *HistogramGen {
+		return NewHistogramGen(rand.New(rand.NewSource(seed)), mint, maxt, opts)
+	}
+
+	name := lset.Get(labels.MetricName)
+	bounds := opts.Histogram.UpperBounds()
+	series := make([]Series, 0, len(bounds)+2)
+	for i, b := range bounds {
+		series = append(series, NewSeriesGen(
+			labels.NewBuilder(lset).Set(labels.MetricName, name+"_bucket").Set(labels.BucketLabel, formatFloat(b)).Labels(),
+			&histogramComponentIter{g: newGen(), component: i},
+		))
+	}
+	series = append(series,
+		NewSeriesGen(labels.NewBuilder(lset).Set(labels.MetricName, name+"_sum").Labels(), &histogramComponentIter{g: newGen(), component: histogramSum}),
+		NewSeriesGen(labels.NewBuilder(lset).Set(labels.MetricName, name+"_count").Labels(), &histogramComponentIter{g: newGen(), component: histogramCount}),
+	)
+	return series
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,368 @@
+package seriesgen
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// NativeHistogramCharacteristics describes native (sparse) histogram layout and observations.
+type NativeHistogramCharacteristics struct {
+	// Schema of the buckets, between -4 and 8. Each power of two is divided into 2^schema buckets.
+	Schema int32 `yaml:"schema"`
+	// ZeroThreshold is the width of the zero bucket.
+	ZeroThreshold float64 `yaml:"zeroThreshold"`
+	// MaxBuckets limits the bucket spread. If observations would populate more buckets, schema is reduced
+	// the same way Prometheus does for native_histogram_bucket_limit. 0 means no limit.
+	MaxBuckets int `yaml:"maxBuckets"`
+	// Float generates float histograms instead of integer ones.
+	Float bool `yaml:"float"`
+
+	// ObservationRate is an average number of observations per second. Defaults to 1.
+	ObservationRate float64 `yaml:"observationRate"`
+	// Observations is the distribution of observed values. Negative values populate negative buckets.
+	Observations Distribution `yaml:"observations"`
+}
+
+// HistogramSeriesIterator is a SeriesIterator that is able to return native histogram samples.
+type HistogramSeriesIterator interface {
+	SeriesIterator
+
+	// ValueType returns type of the current sample. It tells which At method should be used.
+	ValueType() chunkenc.ValueType
+	// AtHistogram returns the current timestamp and integer histogram.
+	AtHistogram() (int64, *histogram.Histogram)
+	// AtFloatHistogram returns the current timestamp and float histogram.
+	AtFloatHistogram() (int64, *histogram.FloatHistogram)
+}
+
+var _ HistogramSeriesIterator = &NativeHistogramGen{}
+
+// nativeBuckets holds cumulative counts of continuous range of bucket indexes.
+type nativeBuckets struct {
+	offset int32
+	probs  []float64
+	mids   []float64
+	counts []float64
+}
+
+// cutoff returns the lowest absolute value above zero threshold on the given side of zero, below which observations
+// have negligible probability. This bounds number of buckets for distributions close to zero.
+func cutoff(dist Distribution, zeroThreshold, hi float64, sign float64) float64 {
+	mass := func(x float64) float64 {
+		if sign > 0 {
+			return dist.CDF(x) - dist.CDF(zeroThreshold)
+		}
+		return dist.CDF(-zeroThreshold) - dist.CDF(-x)
+	}
+	low := math.Max(zeroThreshold, math.SmallestNonzeroFloat32)
+	if hi <= low {
+		return hi
+	}
+
+	negligible := 1e-6 * mass(hi)
+	lower, upper := math.Log2(low), math.Log2(hi)
+	for i := 0; i < 64; i++ {
+		mid := (lower + upper) / 2
+		if mass(math.Exp2(mid)) <= negligible {
+			lower = mid
+		} else {
+			upper = mid
+		}
+	}
+	return math.Max(low, math.Exp2(lower))
+}
+
+func newNativeBuckets(schema int32, zeroThreshold, lo, hi float64, dist Distribution, sign float64) nativeBuckets {
+	first, last := bucketIndex(schema, lo), bucketIndex(schema, hi)
+	b := nativeBuckets{
+		offset: first,
+		probs:  make([]float64, last-first+1),
+		mids:   make([]float64, last-first+1),
+		counts: make([]float64, last-first+1),
+	}
+	for i := range b.probs {
+		lower, upper := bucketBound(schema, first+int32(i)-1), bucketBound(schema, first+int32(i))
+		b.mids[i] = sign * (lower + upper) / 2
+
+		// Observations within zero threshold go to the zero bucket.
+		lower = math.Max(lower, zeroThreshold)
+		if sign > 0 {
+			b.probs[i] = dist.CDF(upper) - dist.CDF(lower)
+		} else {
+			b.probs[i] = dist.CDF(-lower) - dist.CDF(-upper)
+		}
+	}
+	return b
+}
+
+// bucketIndex returns index of the bucket with given value, for positive value.
+func bucketIndex(schema int32, v float64) int32 {
+	return int32(math.Ceil(math.Log2(v) * math.Ldexp(1, int(schema))))
+}
+
+// bucketBound returns upper bound of the bucket with given index.
+func bucketBound(schema int32, idx int32) float64 {
+	return math.Exp2(float64(idx) / math.Ldexp(1, int(schema)))
+}
+
+// NativeHistogramGen generates native histogram counter samples.
+// Similar to HistogramGen, every scrape the number of observations per bucket is drawn from Poisson distribution with
+// mean derived from observation rate and given observation distribution.
+type NativeHistogramGen struct {
+	interval         time.Duration
+	maxTime, minTime int64
+	resets           *resetter
+
+	schema        int32
+	zeroThreshold float64
+	float         bool
+	rate          float64
+
+	zeroProb, zeroCount float64
+	pos, neg            nativeBuckets
+	sum                 float64
+	reset               bool
+	init                bool
+
+	h  *histogram.Histogram
+	fh *histogram.FloatHistogram
+
+	random *rand.Rand
+}
+
+func NewNativeHistogramGen(random *rand.Rand, mint, maxt int64, opts Characteristics) *NativeHistogramGen {
+	o := opts.NativeHistogram
+	g := &NativeHistogramGen{
+		interval:      opts.ScrapeInterval,
+		minTime:       mint,
+		maxTime:       maxt,
+		resets:        newResetter(mint, opts.Resets),
+		schema:        o.Schema,
+		zeroThreshold: o.ZeroThreshold,
+		float:         o.Float,
+		rate:          o.ObservationRate,
+		random:        random,
+	}
+	if g.rate <= 0 {
+		g.rate = 1
+	}
+	if g.schema > 8 {
+		g.schema = 8
+	}
+	if g.schema < -4 {
+		g.schema = -4
+	}
+
+	dist := o.Observations
+	if dist == (Distribution{}) {
+		dist = Distribution{Type: Uniform, Min: 0, Max: 1}
+	}
+	g.zeroProb = dist.CDF(g.zeroThreshold) - dist.CDF(-g.zeroThreshold)
+	if g.zeroThreshold == 0 {
+		g.zeroProb = 0
+	}
+
+	// Absolute value ranges of positive and negative observations that have non-negligible probability.
+	lo, hi := dist.Range()
+	posLo, posHi := cutoff(dist, g.zeroThreshold, hi, +1), hi
+	negLo, negHi := cutoff(dist, g.zeroThreshold, -lo, -1), -lo
+	for {
+		var buckets int
+		if posHi > posLo {
+			buckets += int(bucketIndex(g.schema, posHi) - bucketIndex(g.schema, posLo) + 1)
+		}
+		if negHi > negLo {
+			buckets += int(bucketIndex(g.schema, negHi) - bucketIndex(g.schema, negLo) + 1)
+		}
+		if o.MaxBuckets <= 0 || buckets <= o.MaxBuckets || g.schema == -4 {
+			break
+		}
+		g.schema--
+	}
+	if posHi > posLo {
+		g.pos = newNativeBuckets(g.schema, g.zeroThreshold, posLo, posHi, dist, +1)
+	}
+	if negHi > negLo {
+		g.neg = newNativeBuckets(g.schema, g.zeroThreshold, negLo, negHi, dist, -1)
+	}
+	return g
+}
+
+func (g *NativeHistogramGen) Next() bool {
+	if !g.advance() {
+		return false
+	}
+	g.build()
+	return true
+}
+
+// SeekTo fast-forwards through skipped samples, as every sample draws random numbers. Histograms are built only for
+// the sample found.
+func (g *NativeHistogramGen) SeekTo(t int64) bool {
+	if g.init && g.minTime >= t {
+		return true
+	}
+	for g.advance() {
+		if g.minTime >= t {
+			g.build()
+			return true
+		}
+	}
+	return false
+}
+
+// advance moves to the next sample, updating bucket counts.
+func (g *NativeHistogramGen) advance() bool {
+	if g.init {
+		g.minTime += int64(g.interval.Seconds() * 1000)
+	}
+	if g.minTime > g.maxTime {
+		return false
+	}
+	g.init = true
+
+	g.reset = g.resets.Reset(g.random, g.minTime)
+	if g.reset {
+		g.zeroCount, g.sum = 0, 0
+		for _, b := range []nativeBuckets{g.pos, g.neg} {
+			for i := range b.counts {
+				b.counts[i] = 0
+			}
+		}
+	}
+
+	lambda := g.rate * g.interval.Seconds()
+	g.zeroCount += poisson(g.random, lambda*g.zeroProb)
+	for _, b := range []nativeBuckets{g.pos, g.neg} {
+		for i := range b.counts {
+			n := poisson(g.random, lambda*b.probs[i])
+			b.counts[i] += n
+			g.sum += n * b.mids[i]
+		}
+	}
+
+	return true
+}
+
+// build creates histogram of the current sample.
+func (g *NativeHistogramGen) build() {
+	if g.float {
+		g.fh = g.floatHistogram()
+		g.h = nil
+		return
+	}
+	g.h = g.histogram()
+	g.fh = nil
+}
+
+func (g *NativeHistogramGen) counterResetHint() histogram.CounterResetHint {
+	if g.reset {
+		return histogram.CounterReset
+	}
+	return histogram.UnknownCounterReset
+}
+
+func (g *NativeHistogramGen) histogram() *histogram.Histogram {
+	h := &histogram.Histogram{
+		CounterResetHint: g.counterResetHint(),
+		Schema:           g.schema,
+		ZeroThreshold:    g.zeroThreshold,
+		ZeroCount:        uint64(g.zeroCount),
+		Count:            uint64(g.zeroCount),
+		Sum:              g.sum,
+	}
+	var counts []float64
+	h.PositiveSpans, counts = spansFor(g.pos)
+	h.PositiveBuckets = deltas(counts)
+	for _, c := range counts {
+		h.Count += uint64(c)
+	}
+	h.NegativeSpans, counts = spansFor(g.neg)
+	h.NegativeBuckets = deltas(counts)
+	for _, c := range counts {
+		h.Count += uint64(c)
+	}
+	return h
+}
+
+func (g *NativeHistogramGen) floatHistogram() *histogram.FloatHistogram {
+	h := &histogram.FloatHistogram{
+		CounterResetHint: g.counterResetHint(),
+		Schema:           g.schema,
+		ZeroThreshold:    g.zeroThreshold,
+		ZeroCount:        g.zeroCount,
+		Count:            g.zeroCount,
+		Sum:              g.sum,
+	}
+	h.PositiveSpans, h.PositiveBuckets = spansFor(g.pos)
+	for _, c := range h.PositiveBuckets {
+		h.Count += c
+	}
+	h.NegativeSpans, h.NegativeBuckets = spansFor(g.neg)
+	for _, c := range h.NegativeBuckets {
+		h.Count += c
+	}
+	return h
+}
+
+// spansFor returns spans and absolute counts of all populated buckets.
+func spansFor(b nativeBuckets) (spans []histogram.Span, counts []float64) {
+	var next int32
+	for i, c := range b.counts {
+		if c == 0 {
+			continue
+		}
+		idx := b.offset + int32(i)
+		if len(spans) == 0 || idx != next {
+			offset := idx - next
+			if len(spans) == 0 {
+				offset = idx
+			}
+			spans = append(spans, histogram.Span{Offset: offset})
+		}
+		spans[len(spans)-1].Length++
+		counts = append(counts, c)
+		next = idx + 1
+	}
+	return spans, counts
+}
+
+func deltas(counts []float64) []int64 {
+	if len(counts) == 0 {
+		return nil
+	}
+	d := make([]int64, len(counts))
+	var prev int64
+	for i, c := range counts {
+		d[i] = int64(c) - prev
+		prev = int64(c)
+	}
+	return d
+}
+
+// At returns the current timestamp and count of observations.
+func (g *NativeHistogramGen) At() (int64, float64) {
+	if g.float {
+		return g.minTime, g.fh.Count
+	}
+	return g.minTime, float64(g.h.Count)
+}
+
+func (g *NativeHistogramGen) ValueType() chunkenc.ValueType {
+	if g.float {
+		return chunkenc.ValFloatHistogram
+	}
+	return chunkenc.ValHistogram
+}
+
+func (g *NativeHistogramGen) AtHistogram() (int64, *histogram.Histogram) { return g.minTime, g.h }
+
+func (g *NativeHistogramGen) AtFloatHistogram() (int64, *histogram.FloatHistogram) {
+	return g.minTime, g.fh
+}
+
+func (g *NativeHistogramGen) Err() error { return nil }
@@ -0,0 +1,131 @@
+package seriesgen
+
+import (
+	"container/heap"
+	"math/rand"
+	"time"
+
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// OutOfOrder describes samples arriving late, e.g from agents with unreliable network or replayed from a buffer.
+type OutOfOrder struct {
+	// Fraction of samples that arrive late.
+	Fraction float64 `yaml:"fraction"`
+	// Window is maximum delay of the late sample. It should not be larger than out-of-order time window of the TSDB,
+	// otherwise late samples are rejected.
+	Window time.Duration `yaml:"window"`
+}
+
+// Enabled returns true if any sample could arrive late.
+func (o OutOfOrder) Enabled() bool {
+	return o.Fraction > 0 && o.Window > 0
+}
+
+var _ HistogramSeriesIterator = &OutOfOrderIterator{}
+
+// OutOfOrderIterator returns samples of the wrapped iterator in the order of their arrival, where given fraction of
+// samples is delayed by random duration up to the window. Delayed samples are returned after samples with later
+// timestamps, so timestamps are not monotonic anymore.
+// Prometheus does not support out-of-order native histograms yet, so only float samples are delayed.
+type OutOfOrderIterator struct {
+	pointIterator
+
+	it  SeriesIterator
+	hit HistogramSeriesIterator
+
+	fraction float64
+	window   int64
+
+	// pending are fetched samples ordered by arrival.
+	pending arrivals
+	seq     int
+	// last is the timestamp of the last fetched sample.
+	last int64
+	done bool
+
+	random *rand.Rand
+}
+
+func NewOutOfOrderIterator(random *rand.Rand, it SeriesIterator, ooo OutOfOrder) *OutOfOrderIterator {
+	hit, _ := it.(HistogramSeriesIterator)
+	w := ooo.Window.Milliseconds()
+	if w < 1 {
+		w = 1
+	}
+	return &OutOfOrderIterator{
+		it:       it,
+		hit:      hit,
+		fraction: ooo.Fraction,
+		window:   w,
+		random:   random,
+	}
+}
+
+func (g *OutOfOrderIterator) fetch() bool {
+	p, ok := fetchPoint(g.it, g.hit)
+	if !ok {
+		return false
+	}
+
+	// Always draw the same number of random numbers, so decisions stay the same for all iterators with the same seed.
+	late := g.random.Float64() < g.fraction
+	delay := 1 + g.random.Int63n(g.window)
+
+	a := arrival{p: p, at: p.t, seq: g.seq}
+	if late && p.typ == chunkenc.ValFloat {
+		a.at += delay
+	}
+	heap.Push(&g.pending, a)
+	g.seq++
+	g.last = p.t
+	return true
+}
+
+func (g *OutOfOrderIterator) Next() bool {
+	// Timestamps only grow, so nothing fetched later can arrive before the first pending sample if it arrives
+	// before the last fetched timestamp.
+	for !g.done && (len(g.pending) == 0 || g.pending[0].at > g.last) {
+		g.done = !g.fetch()
+	}
+	if len(g.pending) == 0 {
+		return false
+	}
+	g.curr = heap.Pop(&g.pending).(arrival).p
+	return true
+}
+
+// SeekTo fast-forwards through skipped samples, as every sample draws random numbers. Note that samples are returned in
+// order of arrival, so late samples older than t could follow.
+func (g *OutOfOrderIterator) SeekTo(t int64) bool { return g.seek(g.Next, t) }
+
+func (g *OutOfOrderIterator) Err() error { return g.it.Err() }
+
+type arrival struct {
+	p   point
+	at  int64
+	seq int
+}
+
+// arrivals is a min-heap of samples by arrival time, then by the original order.
+type arrivals []arrival
+
+func (a arrivals) Len() int { return len(a) }
+
+func (a arrivals) Less(i, j int) bool {
+	if a[i].at != a[j].at {
+		return a[i].at < a[j].at
+	}
+	return a[i].seq < a[j].seq
+}
+
+func (a arrivals) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+func (a *arrivals) Push(x interface{}) { *a = append(*a, x.(arrival)) }
+
+func (a *arrivals) Pop() interface{} {
+	old := *a
+	x := old[len(old)-1]
+	*a = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,100 @@
+package seriesgen
+
+import (
+	"math"
+
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// point is a copy of a single sample of any type, used by iterators wrapping other iterators.
+type point struct {
+	t   int64
+	v   float64
+	typ chunkenc.ValueType
+	h   *histogram.Histogram
+	fh  *histogram.FloatHistogram
+	// e is exemplar attached to the sample, if any.
+	e *exemplar.Exemplar
+}
+
+func (p point) stale() point {
+	s := point{t: p.t, typ: p.typ, v: math.Float64frombits(value.StaleNaN)}
+	switch p.typ {
+	case chunkenc.ValHistogram:
+		s.h = &histogram.Histogram{Sum: s.v}
+	case chunkenc.ValFloatHistogram:
+		s.fh = &histogram.FloatHistogram{Sum: s.v}
+	}
+	return s
+}
+
+// fetchPoint advances the iterator and copies its current sample, together with exemplar if iterator attaches those.
+// hit is the same iterator if it supports native histograms, nil otherwise.
+func fetchPoint(it SeriesIterator, hit HistogramSeriesIterator) (point, bool) {
+	if !it.Next() {
+		return point{}, false
+	}
+	return atPoint(it, hit), true
+}
+
+// atPoint copies the current sample of the iterator.
+func atPoint(it SeriesIterator, hit HistogramSeriesIterator) point {
+	p := point{typ: chunkenc.ValFloat}
+	if hit != nil {
+		p.typ = hit.ValueType()
+	}
+	switch p.typ {
+	case chunkenc.ValHistogram:
+		p.t, p.h = hit.AtHistogram()
+		p.v = float64(p.h.Count)
+	case chunkenc.ValFloatHistogram:
+		p.t, p.fh = hit.AtFloatHistogram()
+		p.v = p.fh.Count
+	default:
+		p.t, p.v = it.At()
+	}
+	if eit, ok := it.(ExemplarSeriesIterator); ok {
+		if e, ok := eit.AtExemplar(); ok {
+			p.e = &e
+		}
+	}
+	return p
+}
+
+// pointIterator implements At methods of HistogramSeriesIterator and ExemplarSeriesIterator for the current point.
+type pointIterator struct {
+	curr point
+}
+
+func (p *pointIterator) At() (int64, float64) { return p.curr.t, p.curr.v }
+
+func (p *pointIterator) ValueType() chunkenc.ValueType { return p.curr.typ }
+
+func (p *pointIterator) AtHistogram() (int64, *histogram.Histogram) { return p.curr.t, p.curr.h }
+
+func (p *pointIterator) AtFloatHistogram() (int64, *histogram.FloatHistogram) {
+	return p.curr.t, p.curr.fh
+}
+
+// seek implements SeekTo by calling next until the current point timestamp is at least t.
+func (p *pointIterator) seek(next func() bool, t int64) bool {
+	if p.curr.typ != chunkenc.ValNone && p.curr.t >= t {
+		return true
+	}
+	for next() {
+		if p.curr.t >= t {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *pointIterator) AtExemplar() (exemplar.Exemplar, bool) {
+	if p.curr.e == nil {
+		return exemplar.Exemplar{}, false
+	}
+	return *p.curr.e, true
+}
@@ -0,0 +1,144 @@
+package seriesgen
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// Relation describes values of a series derived from another (base) series, e.g memory limit of a pod derived from its
+// usage, so binary operations between them give meaningful results.
+type Relation struct {
+	// Min and Max bound the ratio of derived values to base values.
+	Min float64 `yaml:"min"`
+	Max float64 `yaml:"max"`
+	// PerSample draws the ratio for every sample, e.g for errors to requests ratio. Otherwise the ratio is drawn
+	// once, e.g for limits. Ratio varying per sample should be used only for gauges, as it breaks monotonicity of
+	// counters.
+	PerSample bool `yaml:"perSample"`
+}
+
+// Enabled returns true if series is derived from the base series.
+func (r Relation) Enabled() bool {
+	return r.Min != 0 || r.Max != 0
+}
+
+func (r Relation) Validate() error {
+	if r.Max < r.Min {
+		return errors.Errorf("relation: max ratio %v lower than min %v", r.Max, r.Min)
+	}
+	return nil
+}
+
+var _ HistogramSeriesIterator = &RelationIterator{}
+
+// RelationIterator multiplies float samples of the base iterator by ratio within bounds of the relation.
+// Native histograms are not changed.
+type RelationIterator struct {
+	pointIterator
+
+	it  SeriesIterator
+	hit HistogramSeriesIterator
+
+	rel   Relation
+	ratio float64
+	init  bool
+
+	random *rand.Rand
+}
+
+func NewRelationIterator(random *rand.Rand, base SeriesIterator, rel Relation) *RelationIterator {
+	hit, _ := base.(HistogramSeriesIterator)
+	return &RelationIterator{
+		it:     base,
+		hit:    hit,
+		rel:    rel,
+		random: random,
+	}
+}
+
+func (g *RelationIterator) Next() bool {
+	p, ok := fetchPoint(g.it, g.hit)
+	if !ok {
+		return false
+	}
+	if !g.init || g.rel.PerSample {
+		g.ratio = g.rel.Min + g.random.Float64()*(g.rel.Max-g.rel.Min)
+		g.init = true
+	}
+	if p.typ == chunkenc.ValFloat {
+		p.v *= g.ratio
+	}
+	g.curr = p
+	return true
+}
+
+func (g *RelationIterator) SeekTo(t int64) bool {
+	if g.rel.PerSample {
+		// Fast-forward, ratio is drawn for every sample.
+		return g.seek(g.Next, t)
+	}
+	if g.curr.typ != chunkenc.ValNone && g.curr.t >= t {
+		return true
+	}
+	if !g.it.SeekTo(t) {
+		return false
+	}
+	if !g.init {
+		g.ratio = g.rel.Min + g.random.Float64()*(g.rel.Max-g.rel.Min)
+		g.init = true
+	}
+	p := atPoint(g.it, g.hit)
+	if p.typ == chunkenc.ValFloat {
+		p.v *= g.ratio
+	}
+	g.curr = p
+	return true
+}
+
+func (g *RelationIterator) Err() error { return g.it.Err() }
+
+var _ SeriesIterator = &ConstGen{}
+
+// ConstGen generates the same value for every scrape, e.g 1 for info metrics.
+type ConstGen struct {
+	interval         time.Duration
+	maxTime, minTime int64
+
+	v    float64
+	init bool
+}
+
+func NewConstGen(mint, maxt int64, v float64, opts Characteristics) *ConstGen {
+	return &ConstGen{
+		interval: opts.ScrapeInterval,
+		minTime:  mint,
+		maxTime:  maxt,
+		v:        v,
+	}
+}
+
+func (g *ConstGen) Next() bool {
+	if g.init {
+		g.minTime += g.interval.Milliseconds()
+	}
+	g.init = true
+	return g.minTime <= g.maxTime
+}
+
+func (g *ConstGen) SeekTo(t int64) bool {
+	if g.init && g.minTime >= t {
+		return true
+	}
+	g.init = true
+	if interval := g.interval.Milliseconds(); g.minTime < t && interval > 0 {
+		g.minTime += (t - g.minTime + interval - 1) / interval * interval
+	}
+	return g.minTime <= g.maxTime
+}
+
+func (g *ConstGen) At() (int64, float64) { return g.minTime, g.v }
+
+func (g *ConstGen) Err() error { return nil }
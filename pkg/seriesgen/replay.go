@@ -0,0 +1,263 @@
+package seriesgen
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// ReplayCharacteristics describes recorded samples to replay.
+type ReplayCharacteristics struct {
+	// File with recorded samples. Supported formats:
+	//   - .json: Prometheus HTTP API query_range response (or just its data section) with matrix result. If there are
+	//     multiple series, targets replay them in turn.
+	//   - .csv: rows of timestamp and value. Timestamp is either Unix time in seconds or RFC3339. Header is optional.
+	File string `yaml:"file"`
+
+	// Shift moves recorded samples in time, so the first one is at the series MinTime. Otherwise samples are replayed at
+	// their original timestamps and samples outside of series time range are dropped.
+	Shift bool `yaml:"shift"`
+	// Loop repeats recorded samples until the series MaxTime.
+	Loop bool `yaml:"loop"`
+
+	// Scale multiplies all recorded values. Defaults to 1.
+	Scale float64 `yaml:"scale"`
+	// ScaleJitter scales every generated series by a random factor from [1-ScaleJitter, 1+ScaleJitter], so targets
+	// replaying the same recording differ.
+	ScaleJitter float64 `yaml:"scaleJitter"`
+
+	// Target is the index of the target replaying the file, set by generators. Target i replays the i-th recorded
+	// series, looping over them if there are more targets than recorded series.
+	Target int `yaml:"-"`
+}
+
+var recordings = struct {
+	sync.Mutex
+	m map[string]model.Matrix
+}{m: map[string]model.Matrix{}}
+
+// LoadRecording returns series recorded in given file. Files are parsed only once, as usually many series replay
+// the same file.
+func LoadRecording(file string) (model.Matrix, error) {
+	recordings.Lock()
+	defer recordings.Unlock()
+
+	if m, ok := recordings.m[file]; ok {
+		return m, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "read recording")
+	}
+
+	var m model.Matrix
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		m, err = parseQueryRange(b)
+	case ".csv":
+		m, err = parseCSV(b)
+	default:
+		return nil, errors.Errorf("unsupported recording format %q, expected .json or .csv", filepath.Ext(file))
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse recording %s", file)
+	}
+	if len(m) == 0 {
+		return nil, errors.Errorf("no series recorded in %s", file)
+	}
+	recordings.m[file] = m
+	return m, nil
+}
+
+func parseQueryRange(b []byte) (model.Matrix, error) {
+	var resp struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Data) > 0 {
+		b = resp.Data
+	}
+
+	var data struct {
+		ResultType model.ValueType `json:"resultType"`
+		Result     model.Matrix    `json:"result"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	if data.ResultType != model.ValMatrix {
+		return nil, errors.Errorf("expected matrix result, got %s", data.ResultType)
+	}
+	return data.Result, nil
+}
+
+func parseCSV(b []byte) (model.Matrix, error) {
+	r := csv.NewReader(bytes.NewReader(b))
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+
+	s := &model.SampleStream{Metric: model.Metric{}}
+	for row := 0; ; row++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		t, terr := parseTimestamp(rec[0])
+		v, verr := strconv.ParseFloat(rec[1], 64)
+		if terr != nil || verr != nil {
+			if row == 0 {
+				// Header.
+				continue
+			}
+			return nil, errors.Errorf("row %d: invalid sample %v", row+1, rec)
+		}
+		s.Values = append(s.Values, model.SamplePair{Timestamp: t, Value: model.SampleValue(v)})
+	}
+	sort.Slice(s.Values, func(i, j int) bool { return s.Values[i].Timestamp < s.Values[j].Timestamp })
+	return model.Matrix{s}, nil
+}
+
+func parseTimestamp(s string) (model.Time, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return model.TimeFromUnixNano(int64(f * float64(time.Second))), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, err
+	}
+	return model.TimeFromUnixNano(t.UnixNano()), nil
+}
+
+var _ SeriesIterator = &ReplayGen{}
+
+// ReplayGen replays recorded samples, optionally shifted in time, looped and scaled.
+type ReplayGen struct {
+	maxTime, minTime int64
+
+	recorded []model.SamplePair
+	loop     bool
+	scale    float64
+	// shift is added to recorded timestamps, period is the duration of single loop.
+	shift, period int64
+
+	i    int
+	curr sample
+}
+
+func NewReplayGen(random *rand.Rand, mint, maxt int64, recorded []model.SamplePair, opts Characteristics) *ReplayGen {
+	g := &ReplayGen{
+		minTime:  mint,
+		maxTime:  maxt,
+		recorded: recorded,
+		loop:     opts.Replay.Loop && len(recorded) > 0,
+		scale:    opts.Replay.Scale,
+		i:        -1,
+	}
+	if g.scale == 0 {
+		g.scale = 1
+	}
+	g.scale *= 1 + (2*random.Float64()-1)*opts.Replay.ScaleJitter
+
+	if len(recorded) > 0 {
+		first, last := int64(recorded[0].Timestamp), int64(recorded[len(recorded)-1].Timestamp)
+		if opts.Replay.Shift {
+			g.shift = mint - first
+		}
+		// Next loop starts one average interval after the last sample.
+		g.period = last - first
+		if len(recorded) > 1 {
+			g.period += (last - first) / int64(len(recorded)-1)
+		}
+		if g.period <= 0 {
+			g.period = opts.ScrapeInterval.Milliseconds()
+		}
+		if g.period <= 0 {
+			g.loop = false
+		}
+		if end := last + g.shift; g.loop && end < mint {
+			// Skip loops that end before the series starts.
+			g.shift += ((mint-end)/g.period + 1) * g.period
+		}
+	}
+	return g
+}
+
+func (g *ReplayGen) Next() bool {
+	for {
+		g.i++
+		if g.i >= len(g.recorded) {
+			if !g.loop {
+				return false
+			}
+			g.i = 0
+			g.shift += g.period
+		}
+
+		s := g.recorded[g.i]
+		t := int64(s.Timestamp) + g.shift
+		if t > g.maxTime {
+			return false
+		}
+		if t < g.minTime {
+			continue
+		}
+		g.curr = sample{T: t, V: float64(s.Value) * g.scale}
+		return true
+	}
+}
+
+// SeekTo finds the sample with binary search, jumping over whole loops if needed.
+func (g *ReplayGen) SeekTo(t int64) bool {
+	if g.i >= 0 && g.i < len(g.recorded) && g.curr.T >= t {
+		return true
+	}
+	if len(g.recorded) == 0 {
+		return false
+	}
+
+	first := int64(g.recorded[0].Timestamp)
+	for {
+		j := sort.Search(len(g.recorded), func(k int) bool { return int64(g.recorded[k].Timestamp)+g.shift >= t })
+		if j < len(g.recorded) {
+			if j-1 > g.i {
+				g.i = j - 1
+			}
+			return g.Next()
+		}
+		if !g.loop {
+			g.i = len(g.recorded)
+			return false
+		}
+
+		// Sample is in one of the next loops.
+		loops := (t - first - g.shift) / g.period
+		if loops < 1 {
+			loops = 1
+		}
+		g.shift += loops * g.period
+		g.i = -1
+	}
+}
+
+func (g *ReplayGen) At() (int64, float64) { return g.curr.T, g.curr.V }
+
+func (g *ReplayGen) Err() error { return nil }
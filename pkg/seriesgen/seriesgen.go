@@ -1,10 +1,12 @@
 package seriesgen
 
 import (
+	"math"
 	"math/rand"
 	"time"
 
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
 )
 
 type sample struct {
@@ -25,25 +27,39 @@ type Series interface {
 	Labels() labels.Labels
 
 	// Iterator returns a new iterator of the data of the series.
-	// TODO(bwplotka): Consider moving this to tsdb.SeriesIterator if required. This means adding `Seek` method.
 	Iterator() SeriesIterator
 }
 
 // SeriesIterator iterates over the data of a time series.
 // Simplified version of github.com/prometheus/prometheus/tsdb.SeriesIterator
+// Iterators that produce native histograms implement HistogramSeriesIterator as well.
 type SeriesIterator interface {
 	// At returns the current timestamp/value pair.
 	At() (t int64, v float64)
 	// Next advances the iterator by one.
 	Next() bool
+	// SeekTo advances the iterator forward to the first sample with the timestamp equal or greater than t.
+	// If the current sample already has this property, SeekTo has no effect. SeekTo returns true if such sample exists.
+	// Generators drawing random numbers for every sample fast-forward through skipped samples, so values do not
+	// depend on whether SeekTo or Next was used.
+	SeekTo(t int64) bool
 	// Err returns current error.
 	Err() error
 }
 
+// MetadataSeries is implemented by series that carry metric metadata (TYPE, HELP and UNIT).
+type MetadataSeries interface {
+	Series
+
+	// Metadata returns metadata of the metric the series belongs to.
+	Metadata() metadata.Metadata
+}
+
 type SeriesGen struct {
 	SeriesIterator
 
 	lset labels.Labels
+	meta metadata.Metadata
 }
 
 func NewSeriesGen(lset labels.Labels, si SeriesIterator) *SeriesGen {
@@ -52,13 +68,25 @@ func NewSeriesGen(lset labels.Labels, si SeriesIterator) *SeriesGen {
 		lset:           lset,
 	}
 }
+
+func NewSeriesGenWithMetadata(lset labels.Labels, si SeriesIterator, meta metadata.Metadata) *SeriesGen {
+	return &SeriesGen{
+		SeriesIterator: si,
+		lset:           lset,
+		meta:           meta,
+	}
+}
+
 func (s *SeriesGen) Labels() labels.Labels { return s.lset }
 
-func (s *SeriesGen) Iterator() SeriesIterator { return s }
+func (s *SeriesGen) Metadata() metadata.Metadata { return s.meta }
+
+func (s *SeriesGen) Iterator() SeriesIterator { return s.SeriesIterator }
 
 var _ SeriesIterator = &GaugeGen{}
 var _ SeriesIterator = &CounterGen{}
 var _ SeriesIterator = &ValGen{}
+var _ SeriesIterator = &histogramComponentIter{}
 
 type Characteristics struct {
 	Jitter         float64       `yaml:"jitter"`
@@ -66,6 +94,118 @@ type Characteristics struct {
 	ChangeInterval time.Duration `yaml:"changeInterval"`
 	Max            float64       `yaml:"max"`
 	Min            float64       `yaml:"min"`
+
+	// Distribution, if set, is used instead of uniform [Min, Max] range. Random generator draws every sample from it,
+	// gauge draws its value every ChangeInterval and counter draws increase of every scrape, e.g Poisson number of
+	// requests.
+	Distribution Distribution `yaml:"distribution"`
+	// Rounding reduces precision of random, gauge and counter values. Together with Distribution it controls entropy
+	// of values and so bytes per sample of XOR chunks.
+	Rounding Rounding `yaml:"rounding"`
+	// BytesPerSample, if positive, calibrates ChangeInterval, Jitter and TimestampJitter of gauge generators, so their
+	// samples take around given number of bytes in XOR chunks. See CalibrateBytesPerSample.
+	BytesPerSample float64 `yaml:"bytesPerSample"`
+
+	// Amplitude, Period and Phase configure periodic generators (sine, sawtooth, square waves).
+	// Period is counted from Unix epoch and shifted by Phase.
+	Amplitude float64       `yaml:"amplitude"`
+	Period    time.Duration `yaml:"period"`
+	Phase     time.Duration `yaml:"phase"`
+	// Slope configures trend generators: change per second for linear trend, relative growth per second for
+	// exponential trend.
+	Slope float64 `yaml:"slope"`
+
+	// Help and Unit are metric metadata. Help defaults to description of the generator type. Unit is not exposed by
+	// the exporter, see exporter.Config.
+	Help string `yaml:"help"`
+	Unit string `yaml:"unit"`
+
+	// ScrapeOffset shifts all timestamps by a stable offset within ScrapeInterval, derived from the series hash, as
+	// Prometheus does for each target.
+	ScrapeOffset bool `yaml:"scrapeOffset"`
+	// TimestampJitter shifts each timestamp randomly by up to given duration in both directions. It is capped below half
+	// of ScrapeInterval.
+	TimestampJitter time.Duration `yaml:"timestampJitter"`
+
+	// Gaps configures missing samples and staleness markers. Used by all generators.
+	Gaps Gaps `yaml:"gaps"`
+	// OutOfOrder configures samples arriving late, ingested through out-of-order path. Used by all generators.
+	OutOfOrder OutOfOrder `yaml:"outOfOrder"`
+	// Exemplars configures exemplars attached to samples. Used by all generators.
+	Exemplars Exemplars `yaml:"exemplars"`
+	// Anomalies are events like spikes or flat-lines injected into float samples. Used by all generators.
+	Anomalies []Anomaly `yaml:"anomalies"`
+	// SpecialValues configures NaN, ±Inf and negative zero replacing float samples. Used by all generators.
+	SpecialValues SpecialValues `yaml:"specialValues"`
+
+	// Resets configures counter resets. Used by counter-like generators only.
+	Resets Resets `yaml:"resets"`
+
+	// Histogram is used only by classic histogram generators.
+	Histogram HistogramCharacteristics `yaml:"histogram"`
+	// NativeHistogram is used only by native histogram generators.
+	NativeHistogram NativeHistogramCharacteristics `yaml:"nativeHistogram"`
+	// Summary is used only by summary generators.
+	Summary SummaryCharacteristics `yaml:"summary"`
+	// Replay is used only by replay generators.
+	Replay ReplayCharacteristics `yaml:"replay"`
+}
+
+// Resets describes when counters drop to zero, e.g because of process restarts.
+type Resets struct {
+	// Interval resets the counter every interval, starting from series MinTime + Offset.
+	Interval time.Duration `yaml:"interval"`
+	Offset   time.Duration `yaml:"offset"`
+	// Aligned makes interval resets aligned to Unix epoch (+ Offset) instead of series MinTime. Set Interval and Offset
+	// to the rollout interval and time of a rollout to reset counters on every rollout e.g of the realistic-k8s profiles.
+	Aligned bool `yaml:"aligned"`
+
+	// Probability is a chance of reset on every scrape.
+	Probability float64 `yaml:"probability"`
+}
+
+type resetter struct {
+	Resets
+
+	origin, prev int64
+	init         bool
+}
+
+func newResetter(mint int64, r Resets) *resetter {
+	origin := mint
+	if r.Aligned {
+		origin = 0
+	}
+	return &resetter{Resets: r, origin: origin + int64(r.Offset.Seconds()*1000)}
+}
+
+// Reset returns true if counter should be reset for the sample with given timestamp. First sample is never reset.
+// Timestamps has to be passed in order.
+func (r *resetter) Reset(random *rand.Rand, t int64) bool {
+	prev := r.prev
+	r.prev = t
+	if !r.init {
+		r.init = true
+		return false
+	}
+
+	if r.Probability > 0 && random.Float64() < r.Probability {
+		return true
+	}
+
+	if interval := int64(r.Interval.Seconds() * 1000); interval > 0 {
+		// Is there any reset point within (prev, t]?
+		return floorDiv(t-r.origin, interval) > floorDiv(prev-r.origin, interval)
+	}
+	return false
+}
+
+func floorDiv(a, b int64) int64 {
+	d := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		d--
+	}
+	return d
 }
 
 type GaugeGen struct {
@@ -74,6 +214,8 @@ type GaugeGen struct {
 	maxTime, minTime int64
 
 	min, max, jitter float64
+	dist             *sampler
+	rounding         Rounding
 
 	v       float64
 	mod     float64
@@ -92,6 +234,8 @@ func NewGaugeGen(random *rand.Rand, mint, maxt int64, opts Characteristics) *Gau
 		minTime:        mint,
 		maxTime:        maxt,
 		jitter:         opts.Jitter,
+		dist:           newSampler(random, opts.Distribution),
+		rounding:       opts.Rounding,
 		random:         random,
 	}
 }
@@ -105,42 +249,99 @@ func (g *GaugeGen) Next() bool {
 		g.elapsed += int64(g.interval.Seconds() * 1000)
 	}()
 
+	change := g.elapsed >= int64(g.changeInterval.Seconds()*1000)
 	if !g.init {
-		g.v = g.min + g.random.Float64()*((g.max-g.min)+1)
+		g.v = g.draw()
 		g.init = true
+	} else if change && g.dist != nil {
+		g.v = g.dist.sample()
 	}
 
-	// Technically only mod changes.
-	if g.jitter > 0 && g.elapsed >= int64(g.changeInterval.Seconds()*1000) {
+	// Without distribution, technically only mod changes.
+	if g.jitter > 0 && change {
 		g.mod = (g.random.Float64() - 0.5) * g.jitter
+	}
+	if change {
 		g.elapsed = 0
 	}
 	return true
 }
 
+func (g *GaugeGen) draw() float64 {
+	if g.dist != nil {
+		return g.dist.sample()
+	}
+	return g.min + g.random.Float64()*((g.max-g.min)+1)
+}
+
+func (g *GaugeGen) SeekTo(t int64) bool {
+	// At returns timestamp already moved to the next sample.
+	if g.init && g.minTime >= t {
+		return true
+	}
+	if !g.init && !g.Next() {
+		return false
+	}
+	if g.jitter > 0 || g.dist != nil {
+		// Fast-forward, jitter and distribution draw random numbers every ChangeInterval.
+		for g.minTime < t {
+			if !g.Next() {
+				return false
+			}
+		}
+		return true
+	}
+
+	interval := int64(g.interval.Seconds() * 1000)
+	if g.minTime < t && interval > 0 {
+		skip := (t - g.minTime + interval - 1) / interval * interval
+		g.minTime += skip
+		g.elapsed += skip
+	}
+	// Sample is at minTime if the previous one was within the range.
+	return g.minTime-interval <= g.maxTime
+}
+
 func (g *GaugeGen) At() (t int64, v float64) {
-	return g.minTime, g.v + g.mod
+	return g.minTime, g.rounding.Round(g.v + g.mod)
 }
 
 func (g *GaugeGen) Err() error { return nil }
 
-// TODO(bwplotka): Improve. Does not work well (: Too naive.
-// Add resets etc.
+// seekNext implements SeekTo by calling Next until the current sample timestamp is at least t. Started tells if the
+// iterator has current sample with timestamp curr.
+func seekNext(it SeriesIterator, started bool, curr int64, t int64) bool {
+	if started && curr >= t {
+		return true
+	}
+	for it.Next() {
+		if curr, _ = it.At(); curr >= t {
+			return true
+		}
+	}
+	return false
+}
+
+// CounterGen generates a counter which per-second rate stays within [Min, Max] over any window of at least one
+// scrape interval, so rate() over e.g 5m window is always within configured bounds.
+// The target rate is chosen randomly within bounds and changed by Jitter every ChangeInterval. Increments of each
+// scrape vary around the target as much as bounds allow.
+// Counter drops to zero according to configured Resets, as if the target was restarted.
 type CounterGen struct {
 	maxTime, minTime int64
 
 	min, max, jitter float64
+	dist             *sampler
+	rounding         Rounding
 	interval         time.Duration
 	changeInterval   time.Duration
-	rateInterval     time.Duration
 
-	v    float64
-	init bool
-	buff []sample
-
-	lastVal float64
-	elapsed int64
+	base, target float64
+	v            float64
+	init         bool
+	elapsed      int64
 
+	resets *resetter
 	random *rand.Rand
 }
 
@@ -153,65 +354,56 @@ func NewCounterGen(random *rand.Rand, mint, maxt int64, opts Characteristics) *C
 		minTime:        mint,
 		maxTime:        maxt,
 		jitter:         opts.Jitter,
+		dist:           newSampler(random, opts.Distribution),
+		rounding:       opts.Rounding,
+		resets:         newResetter(mint, opts.Resets),
 		random:         random,
-		rateInterval:   5 * time.Minute,
 	}
 }
 
 func (g *CounterGen) Next() bool {
-	defer func() { g.elapsed += int64(g.interval.Seconds() * 1000) }()
-
-	if g.init && len(g.buff) == 0 {
-		return false
+	if g.init {
+		g.minTime += int64(g.interval.Seconds() * 1000)
+		g.elapsed += int64(g.interval.Seconds() * 1000)
 	}
-
-	if len(g.buff) > 0 {
-		// Pop front.
-		g.buff = g.buff[1:]
-
-		if len(g.buff) > 0 {
-			return true
-		}
+	if g.minTime > g.maxTime {
+		return false
 	}
 
 	if !g.init {
-		g.v = g.min + g.random.Float64()*((g.max-g.min)+1)
+		g.base = g.min + g.random.Float64()*(g.max-g.min)
+		g.target = g.base
 		g.init = true
 	}
 
-	var mod float64
 	if g.jitter > 0 && g.elapsed >= int64(g.changeInterval.Seconds()*1000) {
-		mod = (g.random.Float64() - 0.5) * g.jitter
-
-		if mod > g.v {
-			mod = g.v
-		}
-
+		g.target = math.Min(g.max, math.Max(g.min, g.base+(g.random.Float64()-0.5)*g.jitter))
 		g.elapsed = 0
 	}
 
-	// Distribute goalV into multiple rateInterval/interval increments.
-	comps := make([]float64, int64(g.rateInterval/g.interval))
-	var sum float64
-	for i := range comps {
-		comps[i] = g.random.Float64()
-		sum += comps[i]
+	if g.resets.Reset(g.random, g.minTime) {
+		g.v = 0
 	}
 
-	// That's the goal for our rate.
-	x := g.v + mod/sum
-	for g.minTime <= g.maxTime && len(comps) > 0 {
-		g.lastVal += x * comps[0]
-		comps = comps[1:]
-
-		g.minTime += int64(g.interval.Seconds() * 1000)
-		g.buff = append(g.buff, sample{T: g.minTime, V: g.lastVal})
+	if g.dist != nil {
+		// Distribution describes increase within a single scrape, counters never decrease.
+		g.v += math.Max(0, g.dist.sample())
+		return true
 	}
 
-	return len(g.buff) > 0
+	// Vary rate of this scrape around the target, as much as we can while staying within [min, max].
+	headroom := math.Min(g.target-g.min, g.max-g.target)
+	rate := g.target + (2*g.random.Float64()-1)*headroom
+	g.v += rate * g.interval.Seconds()
+	return true
 }
 
-func (g *CounterGen) At() (int64, float64) { return g.buff[0].T, g.buff[0].V }
+// SeekTo fast-forwards through skipped samples, as every sample draws random numbers.
+func (g *CounterGen) SeekTo(t int64) bool {
+	return seekNext(g, g.init, g.minTime, t)
+}
+
+func (g *CounterGen) At() (int64, float64) { return g.minTime, g.rounding.Round(g.v) }
 
 func (g *CounterGen) Err() error { return nil }
 
@@ -220,8 +412,11 @@ type ValGen struct {
 	maxTime, minTime int64
 
 	min, max float64
+	dist     *sampler
+	rounding Rounding
 
 	v      float64
+	init   bool
 	random *rand.Rand
 }
 
@@ -230,6 +425,8 @@ func NewValGen(random *rand.Rand, mint, maxt int64, opts Characteristics) *ValGe
 		interval: opts.ScrapeInterval,
 		max:      opts.Max,
 		min:      opts.Min,
+		dist:     newSampler(random, opts.Distribution),
+		rounding: opts.Rounding,
 		minTime:  mint,
 		maxTime:  maxt,
 		random:   random,
@@ -242,11 +439,21 @@ func (g *ValGen) Next() bool {
 	}
 
 	g.minTime += int64(g.interval.Seconds() * 1000)
-	g.v = g.min + g.random.Float64()*((g.max-g.min)+1)
+	if g.dist != nil {
+		g.v = g.rounding.Round(g.dist.sample())
+	} else {
+		g.v = g.rounding.Round(g.min + g.random.Float64()*((g.max-g.min)+1))
+	}
+	g.init = true
 
 	return true
 }
 
+// SeekTo fast-forwards through skipped samples, as every sample draws random numbers.
+func (g *ValGen) SeekTo(t int64) bool {
+	return seekNext(g, g.init, g.minTime, t)
+}
+
 func (g *ValGen) At() (t int64, v float64) {
 	return g.minTime, g.v
 }
@@ -1,11 +1,25 @@
 package seriesgen
 
 import (
+	"context"
+	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"testing"
 	"time"
 
-	"github.com/thanos-io/thanos/pkg/testutil"
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/util/teststorage"
 )
 
 func TestCounterGen(t *testing.T) {
@@ -59,3 +73,1070 @@ func TestGaugeGen(t *testing.T) {
 	}
 	testutil.Equals(t, int64((24*time.Hour)/(15*time.Second)), samples)
 }
+
+func TestHistogramSeries(t *testing.T) {
+	series := NewHistogramSeries(labels.FromStrings("__name__", "http_request_duration_seconds", "job", "a"), 1, 0, int64((24*time.Hour).Seconds())*1000, Characteristics{
+		ScrapeInterval: 15 * time.Second,
+		Histogram: HistogramCharacteristics{
+			BucketStart:     1,
+			BucketWidth:     1,
+			BucketCount:     10,
+			ObservationRate: 10,
+			Observations:    Distribution{Type: Uniform, Min: 0, Max: 10},
+		},
+	})
+	testutil.Equals(t, 13, len(series))
+
+	var (
+		buckets [][]sample
+		bounds  []float64
+		sum     []sample
+		count   []sample
+	)
+	for _, s := range series {
+		var samples []sample
+		it := s.Iterator()
+		for it.Next() {
+			ts, v := it.At()
+			if len(samples) > 0 {
+				testutil.Assert(t, samples[len(samples)-1].V <= v, "counter decreased for %v", s.Labels())
+				testutil.Assert(t, samples[len(samples)-1].T < ts, "")
+			}
+			samples = append(samples, sample{T: ts, V: v})
+		}
+		testutil.Ok(t, it.Err())
+		testutil.Equals(t, int64((24*time.Hour)/(15*time.Second))+1, int64(len(samples)))
+
+		switch s.Labels().Get(labels.MetricName) {
+		case "http_request_duration_seconds_bucket":
+			b, err := strconv.ParseFloat(s.Labels().Get(labels.BucketLabel), 64)
+			testutil.Ok(t, err)
+			bounds = append(bounds, b)
+			buckets = append(buckets, samples)
+		case "http_request_duration_seconds_sum":
+			sum = samples
+		case "http_request_duration_seconds_count":
+			count = samples
+		default:
+			t.Fatalf("unexpected series %v", s.Labels())
+		}
+		testutil.Equals(t, "a", s.Labels().Get("job"))
+	}
+	testutil.Assert(t, math.IsInf(bounds[len(bounds)-1], +1), "last bucket has to be +Inf")
+
+	for i := range count {
+		for b := 1; b < len(buckets); b++ {
+			testutil.Assert(t, buckets[b-1][i].V <= buckets[b][i].V, "buckets are not cumulative")
+		}
+		testutil.Equals(t, count[i].V, buckets[len(buckets)-1][i].V)
+	}
+
+	// Observations are uniform across [0, 10], so median and average should be around 5.
+	last := len(count) - 1
+	total := count[last].V
+	testutil.Assert(t, math.Abs(sum[last].V/total-5) < 0.1, "unexpected average %v", sum[last].V/total)
+	for b := range buckets {
+		if buckets[b][last].V < total/2 {
+			continue
+		}
+		// Linear interpolation within bucket, same as histogram_quantile.
+		lower, below := 0.0, 0.0
+		if b > 0 {
+			lower, below = bounds[b-1], buckets[b-1][last].V
+		}
+		median := lower + (bounds[b]-lower)*(total/2-below)/(buckets[b][last].V-below)
+		testutil.Assert(t, math.Abs(median-5) < 0.1, "unexpected median %v", median)
+		break
+	}
+}
+
+func TestSummarySeries(t *testing.T) {
+	series := NewSummarySeries(labels.FromStrings("__name__", "rpc_duration_seconds", "job", "a"), 1, 0, int64((24*time.Hour).Seconds())*1000, Characteristics{
+		ScrapeInterval: 15 * time.Second,
+		Summary: SummaryCharacteristics{
+			ObservationRate: 10,
+			Observations:    Distribution{Type: Uniform, Min: 0, Max: 10},
+		},
+	})
+	testutil.Equals(t, 5, len(series))
+
+	var (
+		quantiles = map[float64][]sample{}
+		sum       []sample
+		count     []sample
+	)
+	for _, s := range series {
+		var samples []sample
+		it := s.Iterator()
+		for it.Next() {
+			ts, v := it.At()
+			samples = append(samples, sample{T: ts, V: v})
+		}
+		testutil.Ok(t, it.Err())
+		testutil.Equals(t, int64((24*time.Hour)/(15*time.Second))+1, int64(len(samples)))
+
+		switch s.Labels().Get(labels.MetricName) {
+		case "rpc_duration_seconds":
+			q, err := strconv.ParseFloat(s.Labels().Get("quantile"), 64)
+			testutil.Ok(t, err)
+			quantiles[q] = samples
+		case "rpc_duration_seconds_sum":
+			sum = samples
+		case "rpc_duration_seconds_count":
+			count = samples
+		default:
+			t.Fatalf("unexpected series %v", s.Labels())
+		}
+		testutil.Equals(t, "a", s.Labels().Get("job"))
+	}
+	testutil.Equals(t, 3, len(quantiles))
+
+	for i := range count {
+		if i > 0 {
+			testutil.Assert(t, count[i-1].V <= count[i].V, "count decreased")
+			testutil.Assert(t, sum[i-1].V <= sum[i].V, "sum decreased")
+		}
+		testutil.Assert(t, quantiles[0.5][i].V <= quantiles[0.9][i].V && quantiles[0.9][i].V <= quantiles[0.99][i].V, "quantiles are not ordered")
+	}
+
+	// Observations are uniform across [0, 10], so quantiles should be around 5, 9 and 9.9.
+	last := len(count) - 1
+	testutil.Assert(t, math.Abs(sum[last].V/count[last].V-5) < 0.1, "unexpected average %v", sum[last].V/count[last].V)
+	for q, samples := range quantiles {
+		testutil.Assert(t, math.Abs(samples[last].V-10*q) < 0.5, "unexpected %v quantile %v", q, samples[last].V)
+	}
+}
+
+func TestHistogramCharacteristics_Validate(t *testing.T) {
+	testutil.Ok(t, HistogramCharacteristics{}.Validate())
+	testutil.Ok(t, HistogramCharacteristics{Buckets: []float64{1, 0.5, math.Inf(+1)}}.Validate())
+	testutil.NotOk(t, HistogramCharacteristics{Buckets: []float64{1, 0.5, 1}}.Validate())
+	testutil.NotOk(t, HistogramCharacteristics{Buckets: []float64{1, math.Inf(+1), math.Inf(+1)}}.Validate())
+	testutil.NotOk(t, HistogramCharacteristics{BucketFactor: 2, BucketCount: 3}.Validate())
+	testutil.NotOk(t, HistogramCharacteristics{BucketStart: -1, BucketFactor: 2, BucketCount: 3}.Validate())
+	testutil.NotOk(t, HistogramCharacteristics{BucketStart: 1, BucketCount: 3}.Validate())
+	testutil.NotOk(t, HistogramCharacteristics{BucketStart: 1, BucketFactor: 1, BucketCount: 3}.Validate())
+	testutil.NotOk(t, HistogramCharacteristics{BucketStart: 1, BucketWidth: -1, BucketCount: 3}.Validate())
+	testutil.Ok(t, HistogramCharacteristics{BucketStart: 0.1, BucketFactor: 2, BucketCount: 3}.Validate())
+	testutil.Ok(t, HistogramCharacteristics{BucketStart: 0, BucketWidth: 0.5, BucketCount: 3}.Validate())
+	testutil.Ok(t, HistogramCharacteristics{Buckets: []float64{1}, BucketCount: 3}.Validate())
+}
+
+func TestNativeHistogramGen(t *testing.T) {
+	for _, tcase := range []struct {
+		name   string
+		opts   NativeHistogramCharacteristics
+		resets Resets
+	}{
+		{
+			name: "integer",
+			opts: NativeHistogramCharacteristics{
+				Schema:          3,
+				ZeroThreshold:   0.001,
+				ObservationRate: 10,
+				Observations:    Distribution{Type: Normal, Mean: 0, StdDev: 10},
+			},
+		},
+		{
+			name: "float with resets and bucket limit",
+			opts: NativeHistogramCharacteristics{
+				Schema:          8,
+				MaxBuckets:      40,
+				Float:           true,
+				ObservationRate: 10,
+				Observations:    Distribution{Type: Exponential, Mean: 0.5},
+			},
+			resets: Resets{Interval: 1 * time.Hour},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			g := NewNativeHistogramGen(rand.New(rand.NewSource(1)), 0, int64((24*time.Hour).Seconds())*1000, Characteristics{
+				ScrapeInterval:  15 * time.Second,
+				NativeHistogram: tcase.opts,
+				Resets:          tcase.resets,
+			})
+
+			var (
+				prev    *histogram.FloatHistogram
+				resets  int
+				samples int64
+			)
+			for g.Next() {
+				samples++
+
+				var fh *histogram.FloatHistogram
+				if tcase.opts.Float {
+					testutil.Equals(t, chunkenc.ValFloatHistogram, g.ValueType())
+					_, fh = g.AtFloatHistogram()
+				} else {
+					testutil.Equals(t, chunkenc.ValHistogram, g.ValueType())
+					_, h := g.AtHistogram()
+					fh = h.ToFloat()
+				}
+				_, count := g.At()
+				testutil.Equals(t, fh.Count, count)
+
+				buckets := 0
+				sum := fh.ZeroCount
+				for it := fh.AllBucketIterator(); it.Next(); {
+					if b := it.At(); b.Lower != -fh.ZeroThreshold || b.Upper != fh.ZeroThreshold {
+						sum += b.Count
+						buckets++
+					}
+				}
+				testutil.Equals(t, fh.Count, sum)
+				if tcase.opts.MaxBuckets > 0 {
+					testutil.Assert(t, buckets <= tcase.opts.MaxBuckets, "too many buckets %v", buckets)
+					testutil.Assert(t, fh.Schema < tcase.opts.Schema, "schema was not reduced")
+				}
+
+				if prev != nil {
+					if fh.CounterResetHint == histogram.CounterReset {
+						resets++
+					} else {
+						testutil.Assert(t, !fh.DetectReset(prev), "unexpected reset")
+					}
+				}
+				prev = fh
+			}
+			testutil.Equals(t, int64((24*time.Hour)/(15*time.Second))+1, samples)
+			testutil.Assert(t, prev.Count > 0, "no observations")
+			if tcase.resets.Interval > 0 {
+				testutil.Equals(t, 24, resets)
+			} else {
+				testutil.Assert(t, len(prev.NegativeBuckets) > 0 && prev.ZeroCount > 0, "expected negative and zero buckets")
+			}
+		})
+	}
+}
+
+func TestCounterGen_RateWithResets(t *testing.T) {
+	const (
+		minRate = 100.0
+		maxRate = 400.0
+	)
+	mint := timestamp.FromTime(time.Unix(1600000000, 0))
+	maxt := mint + (12 * time.Hour).Milliseconds()
+
+	for _, tcase := range []struct {
+		name           string
+		resets         Resets
+		expectedResets func(int) bool
+	}{
+		{name: "no resets", expectedResets: func(n int) bool { return n == 0 }},
+		{name: "fixed interval", resets: Resets{Interval: 1 * time.Hour}, expectedResets: func(n int) bool { return n == 12 }},
+		{
+			// Rollouts e.g. every 1h, in the middle of 2h block.
+			name: "aligned", resets: Resets{Interval: 1 * time.Hour, Offset: 30 * time.Minute, Aligned: true},
+			expectedResets: func(n int) bool { return n == 12 },
+		},
+		{
+			name: "probability", resets: Resets{Probability: 0.01},
+			// 2880 scrapes, on average 28.8 resets expected.
+			expectedResets: func(n int) bool { return n > 10 && n < 50 },
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			g := NewCounterGen(rand.New(rand.NewSource(1)), mint, maxt, Characteristics{
+				Jitter:         300,
+				ScrapeInterval: 15 * time.Second,
+				ChangeInterval: 1 * time.Hour,
+				Min:            minRate,
+				Max:            maxRate,
+				Resets:         tcase.resets,
+			})
+
+			var (
+				prev    sample
+				resets  int
+				samples []sample
+			)
+			for g.Next() {
+				ts, v := g.At()
+				if len(samples) > 0 && v < prev.V {
+					resets++
+					if tcase.resets.Aligned {
+						// First scrape after rollout.
+						sinceRollout := ts%time.Hour.Milliseconds() - (30 * time.Minute).Milliseconds()
+						testutil.Assert(t, sinceRollout >= 0 && sinceRollout < (15*time.Second).Milliseconds(), "reset not aligned %v", sinceRollout)
+					}
+				}
+				prev = sample{T: ts, V: v}
+				samples = append(samples, prev)
+			}
+			testutil.Assert(t, tcase.expectedResets(resets), "unexpected number of resets %v", resets)
+
+			// Compute rate with PromQL, exactly as users would.
+			s := teststorage.New(t)
+			defer s.Close()
+			testutil.Ok(t, Append(context.Background(), 1, s, &testSeriesSet{series: []Series{
+				NewSeriesGen(labels.FromStrings(labels.MetricName, "requests_total"), &testIterator{samples: samples}),
+			}}))
+
+			engine := promql.NewEngine(promql.EngineOpts{MaxSamples: 1e6, Timeout: time.Minute})
+			q, err := engine.NewRangeQuery(context.Background(), s, nil, "rate(requests_total[5m])", timestamp.Time(mint).Add(5*time.Minute), timestamp.Time(maxt), 15*time.Second)
+			testutil.Ok(t, err)
+			defer q.Close()
+
+			res := q.Exec(context.Background())
+			testutil.Ok(t, res.Err)
+			m, err := res.Matrix()
+			testutil.Ok(t, err)
+			testutil.Equals(t, 1, len(m))
+			testutil.Equals(t, len(samples)-20, len(m[0].Floats))
+			for _, p := range m[0].Floats {
+				testutil.Assert(t, p.F >= minRate*(1-1e-9) && p.F <= maxRate*(1+1e-9), "rate %v at %v out of bounds", p.F, p.T)
+			}
+		})
+	}
+}
+
+type testIterator struct {
+	samples []sample
+	curr    sample
+}
+
+func (it *testIterator) Next() bool {
+	if len(it.samples) == 0 {
+		return false
+	}
+	it.curr, it.samples = it.samples[0], it.samples[1:]
+	return true
+}
+
+func (it *testIterator) SeekTo(t int64) bool {
+	return seekNext(it, it.curr != (sample{}), it.curr.T, t)
+}
+
+func (it *testIterator) At() (int64, float64) { return it.curr.T, it.curr.V }
+
+func (it *testIterator) Err() error { return nil }
+
+func TestWaveGens(t *testing.T) {
+	opts := Characteristics{
+		ScrapeInterval: 15 * time.Second,
+		Min:            100,
+		Max:            100,
+		Amplitude:      50,
+		Period:         24 * time.Hour,
+		Phase:          6 * time.Hour,
+		Slope:          0.001,
+	}
+	day := (24 * time.Hour).Milliseconds()
+	collect := func(g SeriesIterator) map[int64]float64 {
+		res := map[int64]float64{}
+		for g.Next() {
+			ts, v := g.At()
+			res[ts] = v
+		}
+		testutil.Ok(t, g.Err())
+		testutil.Equals(t, int((2*24*time.Hour)/(15*time.Second))+1, len(res))
+		return res
+	}
+
+	t.Run("sine", func(t *testing.T) {
+		res := collect(NewSineGen(rand.New(rand.NewSource(1)), 0, 2*day, opts))
+		testutil.Equals(t, 150.0, res[0])
+		testutil.Assert(t, math.Abs(res[day/2]-50) < 1e-9, "unexpected %v", res[day/2])
+		for ts, v := range res {
+			testutil.Assert(t, v >= 50 && v <= 150, "")
+			if next, ok := res[ts+day]; ok {
+				testutil.Assert(t, math.Abs(next-v) < 1e-9, "not periodic")
+			}
+		}
+	})
+	t.Run("sawtooth", func(t *testing.T) {
+		res := collect(NewSawtoothGen(rand.New(rand.NewSource(1)), 0, 2*day, opts))
+		testutil.Equals(t, 75.0, res[0])
+		testutil.Equals(t, 50.0, res[day-(6*time.Hour).Milliseconds()])
+		testutil.Assert(t, res[day-(6*time.Hour).Milliseconds()-15000] > 149, "")
+	})
+	t.Run("square", func(t *testing.T) {
+		res := collect(NewSquareGen(rand.New(rand.NewSource(1)), 0, 2*day, opts))
+		testutil.Equals(t, 150.0, res[0])
+		testutil.Equals(t, 50.0, res[day/4])
+		for _, v := range res {
+			testutil.Assert(t, v == 50 || v == 150, "")
+		}
+	})
+	t.Run("linear trend", func(t *testing.T) {
+		res := collect(NewLinearTrendGen(rand.New(rand.NewSource(1)), 0, 2*day, opts))
+		testutil.Equals(t, 100.0, res[0])
+		testutil.Assert(t, math.Abs(res[day]-(100+86.4)) < 1e-9, "unexpected %v", res[day])
+	})
+	t.Run("exponential trend", func(t *testing.T) {
+		res := collect(NewExponentialTrendGen(rand.New(rand.NewSource(1)), 0, 2*day, opts))
+		testutil.Equals(t, 100.0, res[0])
+		testutil.Assert(t, math.Abs(res[day]-100*math.Exp(86.4)) < 1e-9*res[day], "unexpected %v", res[day])
+	})
+}
+
+func TestGapsIterator(t *testing.T) {
+	maxt := int64((24 * time.Hour).Seconds()) * 1000
+	newGauge := func() SeriesIterator {
+		return NewGaugeGen(rand.New(rand.NewSource(1)), 0, maxt, Characteristics{
+			ScrapeInterval: 15 * time.Second,
+			Min:            100,
+			Max:            200,
+		})
+	}
+	all := int((24*time.Hour)/(15*time.Second)) + 1
+
+	t.Run("scrape failures with staleness markers", func(t *testing.T) {
+		it := NewGapsIterator(rand.New(rand.NewSource(1)), 0, newGauge(), Gaps{ScrapeFailureProbability: 0.1, StalenessMarkers: true})
+
+		var (
+			samples, stale int
+			lastT          int64 = -1
+			lastStale      bool
+		)
+		for it.Next() {
+			ts, v := it.At()
+			testutil.Equals(t, chunkenc.ValFloat, it.ValueType())
+			if value.IsStaleNaN(v) {
+				testutil.Assert(t, !lastStale, "two staleness markers in a row")
+				// Marker is placed on the first missed scrape.
+				testutil.Equals(t, lastT+15000, ts)
+				stale++
+			}
+			lastStale = value.IsStaleNaN(v)
+			lastT = ts
+			samples++
+		}
+		testutil.Ok(t, it.Err())
+		// Roughly 10% of samples are missing, but each gap starts with staleness marker.
+		testutil.Assert(t, samples-stale > int(0.85*float64(all)) && samples-stale < int(0.95*float64(all)), "unexpected number of samples %v", samples-stale)
+		testutil.Assert(t, stale > int(0.05*float64(all)), "unexpected number of staleness markers %v", stale)
+	})
+	t.Run("outages and stale at end", func(t *testing.T) {
+		it := NewGapsIterator(rand.New(rand.NewSource(1)), 0, newGauge(), Gaps{
+			Outages:    []Outage{{Start: 1 * time.Hour, Duration: 30 * time.Minute}},
+			StaleAtEnd: true,
+		})
+
+		var samples []sample
+		for it.Next() {
+			ts, v := it.At()
+			testutil.Assert(t, ts < (1*time.Hour).Milliseconds() || ts >= (90*time.Minute).Milliseconds(), "sample within outage")
+			samples = append(samples, sample{T: ts, V: v})
+		}
+		testutil.Equals(t, all-int((30*time.Minute)/(15*time.Second)), len(samples))
+		// GaugeGen samples are shifted by one scrape interval.
+		testutil.Equals(t, maxt+15000, samples[len(samples)-1].T)
+		testutil.Assert(t, value.IsStaleNaN(samples[len(samples)-1].V), "expected staleness marker at the end")
+		for _, s := range samples[:len(samples)-1] {
+			testutil.Assert(t, !value.IsStaleNaN(s.V), "unexpected staleness marker")
+		}
+	})
+	t.Run("consistent across iterators and histograms", func(t *testing.T) {
+		gaps := Gaps{OutageProbability: 0.01, OutageDuration: 5 * time.Minute, StalenessMarkers: true}
+		it1 := NewGapsIterator(rand.New(rand.NewSource(2)), 0, NewCounterGen(rand.New(rand.NewSource(1)), 0, maxt, Characteristics{
+			ScrapeInterval: 15 * time.Second,
+			Min:            1,
+			Max:            2,
+		}), gaps)
+		it2 := NewGapsIterator(rand.New(rand.NewSource(2)), 0, NewNativeHistogramGen(rand.New(rand.NewSource(1)), 0, maxt, Characteristics{
+			ScrapeInterval: 15 * time.Second,
+		}), gaps)
+
+		for it1.Next() {
+			testutil.Assert(t, it2.Next(), "")
+			t1, v := it1.At()
+			t2, h := it2.AtHistogram()
+			testutil.Equals(t, chunkenc.ValHistogram, it2.ValueType())
+			testutil.Equals(t, t1, t2)
+			testutil.Equals(t, value.IsStaleNaN(v), value.IsStaleNaN(h.Sum))
+		}
+		testutil.Assert(t, !it2.Next(), "")
+	})
+}
+
+func TestTimestampIterator(t *testing.T) {
+	maxt := int64((24 * time.Hour).Seconds()) * 1000
+	newCounter := func() SeriesIterator {
+		return NewCounterGen(rand.New(rand.NewSource(1)), 0, maxt, Characteristics{
+			ScrapeInterval: 15 * time.Second,
+			Min:            1,
+			Max:            2,
+		})
+	}
+
+	offset := ScrapeOffset(0xc552620224fd8b78, 15*time.Second)
+	testutil.Assert(t, offset > 0 && offset < 15*time.Second, "unexpected offset %v", offset)
+	testutil.Equals(t, offset, ScrapeOffset(0xc552620224fd8b78, 15*time.Second))
+
+	t.Run("offset", func(t *testing.T) {
+		it := NewTimestampIterator(rand.New(rand.NewSource(1)), 0, maxt, newCounter(), offset, 0, 15*time.Second)
+		var samples int64
+		for it.Next() {
+			ts, _ := it.At()
+			testutil.Equals(t, samples*15000+offset.Milliseconds(), ts)
+			samples++
+		}
+		testutil.Ok(t, it.Err())
+		// Last sample was shifted beyond maxt.
+		testutil.Equals(t, int64((24*time.Hour)/(15*time.Second)), samples)
+	})
+	t.Run("jitter", func(t *testing.T) {
+		// Jitter is capped at half of the interval.
+		it := NewTimestampIterator(rand.New(rand.NewSource(1)), 0, maxt, newCounter(), offset, 1*time.Minute, 15*time.Second)
+		var (
+			lastT    int64 = -1
+			distinct       = map[int64]struct{}{}
+		)
+		for it.Next() {
+			ts, _ := it.At()
+			testutil.Assert(t, ts > lastT, "timestamps out of order")
+			testutil.Assert(t, ts >= 0 && ts <= maxt, "timestamp out of range")
+
+			// Distance from the closest scheduled scrape.
+			d := (ts-offset.Milliseconds()+7500)%15000 - 7500
+			testutil.Assert(t, d > -7500 && d < 7500, "jitter %v out of bounds", d)
+			distinct[d] = struct{}{}
+			lastT = ts
+		}
+		testutil.Assert(t, len(distinct) > 100, "expected varying jitter")
+	})
+}
+
+func TestOutOfOrderIterator(t *testing.T) {
+	maxt := int64((24 * time.Hour).Seconds()) * 1000
+	newCounter := func() SeriesIterator {
+		return NewCounterGen(rand.New(rand.NewSource(1)), 0, maxt, Characteristics{
+			ScrapeInterval: 15 * time.Second,
+			Min:            100,
+			Max:            200,
+		})
+	}
+
+	var expected []sample
+	for it := newCounter(); it.Next(); {
+		ts, v := it.At()
+		expected = append(expected, sample{T: ts, V: v})
+	}
+
+	window := 5 * time.Minute
+	it := NewOutOfOrderIterator(rand.New(rand.NewSource(1)), newCounter(), OutOfOrder{Fraction: 0.1, Window: window})
+
+	var (
+		got  []sample
+		ooo  int
+		maxT int64 = -1
+	)
+	for it.Next() {
+		ts, v := it.At()
+		if ts < maxT {
+			ooo++
+			// Sample can't be later than the window.
+			testutil.Assert(t, maxT-ts <= window.Milliseconds(), "sample %v late by %v", ts, maxT-ts)
+		} else {
+			maxT = ts
+		}
+		got = append(got, sample{T: ts, V: v})
+	}
+	testutil.Ok(t, it.Err())
+
+	// Roughly 10% of samples arrive after some newer sample.
+	testutil.Assert(t, ooo > int(0.08*float64(len(expected))) && ooo < int(0.12*float64(len(expected))), "unexpected number of out-of-order samples %v", ooo)
+
+	// No sample is lost or changed, just reordered.
+	sort.Slice(got, func(i, j int) bool { return got[i].T < got[j].T })
+	testutil.Equals(t, expected, got)
+}
+
+func TestExemplarIterator(t *testing.T) {
+	maxt := int64((24 * time.Hour).Seconds()) * 1000
+	it := NewExemplarIterator(rand.New(rand.NewSource(1)), NewCounterGen(rand.New(rand.NewSource(1)), 0, maxt, Characteristics{
+		ScrapeInterval: 15 * time.Second,
+		Min:            100,
+		Max:            200,
+	}), Exemplars{Rate: 0.2, TraceIDCardinality: 10})
+
+	var (
+		samples int
+		traces  = map[string]struct{}{}
+		spans   = map[string]struct{}{}
+	)
+	for it.Next() {
+		samples++
+		e, ok := it.AtExemplar()
+		if !ok {
+			continue
+		}
+		ts, v := it.At()
+		testutil.Equals(t, ts, e.Ts)
+		testutil.Equals(t, v, e.Value)
+		testutil.Equals(t, 32, len(e.Labels.Get("trace_id")))
+		testutil.Equals(t, 16, len(e.Labels.Get("span_id")))
+		traces[e.Labels.Get("trace_id")] = struct{}{}
+		spans[e.Labels.Get("span_id")] = struct{}{}
+	}
+	testutil.Ok(t, it.Err())
+
+	testutil.Assert(t, len(spans) > int(0.18*float64(samples)) && len(spans) < int(0.22*float64(samples)), "unexpected number of exemplars %v", len(spans))
+	testutil.Equals(t, 10, len(traces))
+}
+
+func TestReplayGen(t *testing.T) {
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "incident.csv")
+	testutil.Ok(t, os.WriteFile(csvFile, []byte("time,value\n1600000000,1\n1600000015,2\n2020-09-13T12:27:10Z,3\n"), 0600))
+	jsonFile := filepath.Join(dir, "incident.json")
+	testutil.Ok(t, os.WriteFile(jsonFile, []byte(`{"status":"success","data":{"resultType":"matrix","result":[
+		{"metric":{"__name__":"a"},"values":[[1600000000,"1"],[1600000015,"2"],[1600000030,"3"]]}
+	]}}`), 0600))
+
+	for _, f := range []string{csvFile, jsonFile} {
+		t.Run(filepath.Ext(f), func(t *testing.T) {
+			m, err := LoadRecording(f)
+			testutil.Ok(t, err)
+			testutil.Equals(t, 1, len(m))
+			testutil.Equals(t, []model.SamplePair{
+				{Timestamp: 1600000000000, Value: 1},
+				{Timestamp: 1600000015000, Value: 2},
+				{Timestamp: 1600000030000, Value: 3},
+			}, m[0].Values)
+		})
+	}
+
+	m, err := LoadRecording(csvFile)
+	testutil.Ok(t, err)
+	replay := func(mint, maxt int64, opts ReplayCharacteristics) []sample {
+		var res []sample
+		it := NewReplayGen(rand.New(rand.NewSource(1)), mint, maxt, m[0].Values, Characteristics{Replay: opts})
+		for it.Next() {
+			ts, v := it.At()
+			res = append(res, sample{T: ts, V: v})
+		}
+		testutil.Ok(t, it.Err())
+		return res
+	}
+
+	t.Run("original timestamps", func(t *testing.T) {
+		testutil.Equals(t, []sample{{T: 1600000015000, V: 2}}, replay(1600000010000, 1600000020000, ReplayCharacteristics{}))
+	})
+	t.Run("shifted and scaled", func(t *testing.T) {
+		testutil.Equals(t, []sample{{T: 0, V: 10}, {T: 15000, V: 20}, {T: 30000, V: 30}}, replay(0, 100000, ReplayCharacteristics{Shift: true, Scale: 10}))
+	})
+	t.Run("looped", func(t *testing.T) {
+		testutil.Equals(t, []sample{
+			{T: 0, V: 1}, {T: 15000, V: 2}, {T: 30000, V: 3},
+			{T: 45000, V: 1}, {T: 60000, V: 2}, {T: 75000, V: 3},
+			{T: 90000, V: 1},
+		}, replay(0, 100000, ReplayCharacteristics{Shift: true, Loop: true}))
+	})
+	t.Run("looped from past", func(t *testing.T) {
+		samples := replay(1700000000000, 1700000100000, ReplayCharacteristics{Loop: true})
+		testutil.Equals(t, 7, len(samples))
+		for i := 1; i < len(samples); i++ {
+			testutil.Equals(t, int64(15000), samples[i].T-samples[i-1].T)
+		}
+	})
+	t.Run("scale jitter", func(t *testing.T) {
+		samples := replay(0, 100000, ReplayCharacteristics{Shift: true, ScaleJitter: 0.5})
+		testutil.Equals(t, 3, len(samples))
+		testutil.Assert(t, samples[0].V != 1 && samples[0].V >= 0.5 && samples[0].V <= 1.5, "unexpected scale %v", samples[0].V)
+		testutil.Equals(t, samples[0].V*2, samples[1].V)
+	})
+}
+
+// seekSample is a sample with optional histogram, as returned by any SeriesIterator.
+type seekSample struct {
+	T   int64
+	V   float64
+	NaN uint64
+	FH  *histogram.FloatHistogram
+}
+
+func atSeekSample(it SeriesIterator) seekSample {
+	ts, v := it.At()
+	s := seekSample{T: ts, V: v}
+	if hit, ok := it.(HistogramSeriesIterator); ok {
+		switch hit.ValueType() {
+		case chunkenc.ValHistogram:
+			_, h := hit.AtHistogram()
+			s.FH = h.ToFloat()
+		case chunkenc.ValFloatHistogram:
+			_, fh := hit.AtFloatHistogram()
+			s.FH = fh.Copy()
+		}
+	}
+	if math.IsNaN(s.V) {
+		// NaN is not equal to itself, compare bits instead, so staleness markers are distinguished.
+		s.V, s.NaN = 0, math.Float64bits(s.V)
+	}
+	return s
+}
+
+func TestSeek(t *testing.T) {
+	maxt := int64((6 * time.Hour).Seconds()) * 1000
+	opts := Characteristics{
+		ScrapeInterval: 15 * time.Second,
+		ChangeInterval: 1 * time.Hour,
+		Min:            100,
+		Max:            200,
+		Jitter:         20,
+		Period:         1 * time.Hour,
+		Amplitude:      10,
+		Slope:          0.001,
+	}
+	noJitter := opts
+	noJitter.Jitter = 0
+
+	recorded := []model.SamplePair{{Timestamp: 0, Value: 1}, {Timestamp: 15000, Value: 2}, {Timestamp: 30000, Value: 3}}
+
+	for _, tcase := range []struct {
+		name string
+		new  func() SeriesIterator
+	}{
+		{name: "gauge", new: func() SeriesIterator { return NewGaugeGen(rand.New(rand.NewSource(1)), 0, maxt, opts) }},
+		{name: "gauge without jitter", new: func() SeriesIterator { return NewGaugeGen(rand.New(rand.NewSource(1)), 0, maxt, noJitter) }},
+		{name: "counter", new: func() SeriesIterator { return NewCounterGen(rand.New(rand.NewSource(1)), 0, maxt, opts) }},
+		{name: "random", new: func() SeriesIterator { return NewValGen(rand.New(rand.NewSource(1)), 0, maxt, opts) }},
+		{name: "sine", new: func() SeriesIterator { return NewSineGen(rand.New(rand.NewSource(1)), 0, maxt, opts) }},
+		{name: "sine without jitter", new: func() SeriesIterator { return NewSineGen(rand.New(rand.NewSource(1)), 0, maxt, noJitter) }},
+		{name: "linear trend without jitter", new: func() SeriesIterator {
+			return NewLinearTrendGen(rand.New(rand.NewSource(1)), 0, maxt, noJitter)
+		}},
+		{name: "histogram bucket", new: func() SeriesIterator {
+			return NewHistogramSeries(labels.FromStrings(labels.MetricName, "a"), 1, 0, maxt, opts)[2].Iterator()
+		}},
+		{name: "summary quantile", new: func() SeriesIterator {
+			return NewSummarySeries(labels.FromStrings(labels.MetricName, "a"), 1, 0, maxt, Characteristics{
+				ScrapeInterval: 15 * time.Second,
+				Summary:        SummaryCharacteristics{ObservationRate: 1},
+			})[0].Iterator()
+		}},
+		{name: "native histogram", new: func() SeriesIterator {
+			return NewNativeHistogramGen(rand.New(rand.NewSource(1)), 0, maxt, Characteristics{
+				ScrapeInterval:  15 * time.Second,
+				NativeHistogram: NativeHistogramCharacteristics{Schema: 3, ObservationRate: 1},
+				Resets:          Resets{Interval: 1 * time.Hour},
+			})
+		}},
+		{name: "replay", new: func() SeriesIterator {
+			return NewReplayGen(rand.New(rand.NewSource(1)), 20000, maxt, recorded, Characteristics{Replay: ReplayCharacteristics{Loop: true}})
+		}},
+		{name: "timestamps", new: func() SeriesIterator {
+			return NewTimestampIterator(rand.New(rand.NewSource(1)), 0, maxt, NewGaugeGen(rand.New(rand.NewSource(1)), 0, maxt, noJitter), 3*time.Second, 0, 15*time.Second)
+		}},
+		{name: "timestamps with jitter", new: func() SeriesIterator {
+			return NewTimestampIterator(rand.New(rand.NewSource(1)), 0, maxt, NewGaugeGen(rand.New(rand.NewSource(1)), 0, maxt, noJitter), 3*time.Second, time.Second, 15*time.Second)
+		}},
+		{name: "gaps", new: func() SeriesIterator {
+			return NewGapsIterator(rand.New(rand.NewSource(1)), 0, NewCounterGen(rand.New(rand.NewSource(1)), 0, maxt, opts), Gaps{
+				ScrapeFailureProbability: 0.1,
+				StalenessMarkers:         true,
+			})
+		}},
+		{name: "out of order", new: func() SeriesIterator {
+			return NewOutOfOrderIterator(rand.New(rand.NewSource(1)), NewCounterGen(rand.New(rand.NewSource(1)), 0, maxt, opts), OutOfOrder{
+				Fraction: 0.1,
+				Window:   5 * time.Minute,
+			})
+		}},
+		{name: "const", new: func() SeriesIterator { return NewConstGen(0, maxt, 1, opts) }},
+		{name: "relation", new: func() SeriesIterator {
+			return NewRelationIterator(rand.New(rand.NewSource(1)), NewGaugeGen(rand.New(rand.NewSource(1)), 0, maxt, noJitter), Relation{Min: 1, Max: 2})
+		}},
+		{name: "relation per sample", new: func() SeriesIterator {
+			return NewRelationIterator(rand.New(rand.NewSource(1)), NewGaugeGen(rand.New(rand.NewSource(1)), 0, maxt, noJitter), Relation{Min: 1, Max: 2, PerSample: true})
+		}},
+		{name: "anomalies", new: func() SeriesIterator {
+			return NewAnomalyIterator(rand.New(rand.NewSource(1)), 0, NewCounterGen(rand.New(rand.NewSource(1)), 0, maxt, opts), []Anomaly{
+				{Type: Flatline, Start: 1 * time.Hour, Duration: 30 * time.Minute},
+				{Type: Spike, Probability: 0.01, Magnitude: 1000},
+			})
+		}},
+		{name: "exemplars", new: func() SeriesIterator {
+			return NewExemplarIterator(rand.New(rand.NewSource(1)), NewCounterGen(rand.New(rand.NewSource(1)), 0, maxt, opts), Exemplars{Rate: 0.5})
+		}},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			var expected []seekSample
+			for it := tcase.new(); it.Next(); {
+				expected = append(expected, atSeekSample(it))
+			}
+			testutil.Assert(t, len(expected) > 0, "no samples")
+
+			for _, targets := range [][]int64{
+				{-1000},
+				{expected[0].T, expected[0].T},
+				{1, 1000000, 1000001, 1000001, 2 * 3600 * 1000, 2*3600*1000 - 1},
+				{3 * 3600 * 1000, expected[len(expected)-1].T},
+				{maxt + 1},
+			} {
+				it := tcase.new()
+				pos := -1
+				for _, target := range targets {
+					// SeekTo returns the first sample from the current position at or after target.
+					if pos < 0 || expected[pos].T < target {
+						pos++
+						for pos < len(expected) && expected[pos].T < target {
+							pos++
+						}
+					}
+					if pos >= len(expected) {
+						testutil.Assert(t, !it.SeekTo(target), "seek to %v should be exhausted", target)
+						break
+					}
+					testutil.Assert(t, it.SeekTo(target), "seek to %v should succeed", target)
+					testutil.Equals(t, expected[pos], atSeekSample(it))
+				}
+				if pos >= len(expected) {
+					continue
+				}
+				// Iteration continues after seek as if it was never used.
+				for pos++; pos < len(expected) && pos < 50; pos++ {
+					testutil.Assert(t, it.Next(), "next after seek should succeed")
+					testutil.Equals(t, expected[pos], atSeekSample(it))
+				}
+				testutil.Ok(t, it.Err())
+			}
+		})
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	for _, d := range []Distribution{
+		{Type: Uniform, Min: 10, Max: 20},
+		{Type: Normal, Mean: 5, StdDev: 2},
+		{Type: Exponential, Mean: 3},
+		{Type: LogNormal, Mean: 1, StdDev: 0.5},
+		{Type: Poisson, Mean: 4},
+		{Type: Poisson, Mean: 100},
+		{Type: Zipf, Min: 1, Max: 100, Exponent: 1.5},
+		{Type: Zipf, Min: 0, Max: 1e6, Exponent: 2},
+	} {
+		t.Run(string(d.Type), func(t *testing.T) {
+			testutil.Ok(t, d.Validate())
+
+			const n = 100000
+			random := rand.New(rand.NewSource(1))
+			samples := make([]float64, n)
+			for i := range samples {
+				samples[i] = d.Sample(random)
+			}
+			sort.Float64s(samples)
+
+			lo, hi := d.Range()
+			testutil.Assert(t, samples[0] >= lo && samples[n-1] <= hi, "samples [%v, %v] out of range [%v, %v]", samples[0], samples[n-1], lo, hi)
+
+			// Empirical quantiles match the CDF.
+			discrete := d.Type == Poisson || d.Type == Zipf
+			for _, q := range []float64{0.1, 0.5, 0.9} {
+				x := samples[int(q*n)]
+				if discrete {
+					testutil.Assert(t, d.CDF(x) >= q-0.01 && d.CDF(x-1) <= q+0.01, "CDF(%v) = %v, CDF(%v) = %v, expected around %v", x-1, d.CDF(x-1), x, d.CDF(x), q)
+					continue
+				}
+				testutil.Assert(t, math.Abs(d.CDF(x)-q) < 0.01, "CDF(%v) = %v, expected around %v", x, d.CDF(x), q)
+			}
+			if discrete {
+				for _, s := range samples {
+					testutil.Equals(t, math.Round(s), s)
+				}
+			}
+
+			// Sampler with precomputed constants draws the same values.
+			random, samplerRandom := rand.New(rand.NewSource(2)), rand.New(rand.NewSource(2))
+			smp := newSampler(samplerRandom, d)
+			for i := 0; i < 1000; i++ {
+				testutil.Equals(t, d.Sample(random), smp.sample())
+			}
+		})
+	}
+
+	testutil.NotOk(t, Distribution{Type: Zipf, Max: 10, Exponent: 1}.Validate())
+	testutil.NotOk(t, Distribution{Type: Poisson, Mean: -1}.Validate())
+	testutil.NotOk(t, Distribution{Type: LogNormal, StdDev: -1}.Validate())
+}
+
+func TestRounding(t *testing.T) {
+	testutil.Equals(t, 123.0, Rounding{Integer: true}.Round(123.456))
+	testutil.Equals(t, 123.5, Rounding{SignificantDigits: 4}.Round(123.456))
+	testutil.Equals(t, 0.0012, Rounding{SignificantDigits: 2}.Round(0.0012345))
+	testutil.Equals(t, -120000.0, Rounding{SignificantDigits: 2}.Round(-123456))
+	testutil.Equals(t, 0.0, Rounding{Integer: true}.Round(0.3))
+	testutil.Equals(t, 0.123456, Rounding{}.Round(0.123456))
+	testutil.Assert(t, math.IsNaN(Rounding{SignificantDigits: 2, Integer: true}.Round(math.NaN())), "NaN should stay NaN")
+}
+
+func TestGenerators_Distribution(t *testing.T) {
+	maxt := int64((6 * time.Hour).Seconds()) * 1000
+	opts := Characteristics{
+		ScrapeInterval: 15 * time.Second,
+		ChangeInterval: 5 * time.Minute,
+		Distribution:   Distribution{Type: Poisson, Mean: 10},
+	}
+
+	t.Run("random", func(t *testing.T) {
+		var sum, n float64
+		for g := NewValGen(rand.New(rand.NewSource(1)), 0, maxt, opts); g.Next(); n++ {
+			_, v := g.At()
+			testutil.Equals(t, math.Round(v), v)
+			sum += v
+		}
+		testutil.Assert(t, math.Abs(sum/n-10) < 0.5, "unexpected mean %v", sum/n)
+	})
+	t.Run("gauge", func(t *testing.T) {
+		changes := 0
+		prev := math.NaN()
+		g := NewGaugeGen(rand.New(rand.NewSource(1)), 0, maxt, Characteristics{
+			ScrapeInterval: 15 * time.Second,
+			ChangeInterval: 5 * time.Minute,
+			Distribution:   Distribution{Type: LogNormal, Mean: 3, StdDev: 1},
+			Rounding:       Rounding{SignificantDigits: 2},
+		})
+		for g.Next() {
+			_, v := g.At()
+			testutil.Equals(t, Rounding{SignificantDigits: 2}.Round(v), v)
+			if v != prev {
+				changes++
+			}
+			prev = v
+		}
+		// Value is drawn again every 5 minutes, rarely the same.
+		testutil.Assert(t, changes > 60 && changes <= 72, "unexpected number of changes %v", changes)
+	})
+	t.Run("counter", func(t *testing.T) {
+		var (
+			g    = NewCounterGen(rand.New(rand.NewSource(1)), 0, maxt, opts)
+			prev float64
+			n    float64
+		)
+		for ; g.Next(); n++ {
+			_, v := g.At()
+			testutil.Equals(t, math.Round(v), v)
+			testutil.Assert(t, v >= prev, "counter decreased from %v to %v", prev, v)
+			prev = v
+		}
+		// Poisson increase of every scrape.
+		testutil.Assert(t, math.Abs(prev/n-10) < 0.5, "unexpected average increase %v", prev/n)
+	})
+}
+
+func TestCalibrateBytesPerSample(t *testing.T) {
+	for _, target := range []float64{0.5, 1.3, 3, 6} {
+		t.Run(strconv.FormatFloat(target, 'f', -1, 64), func(t *testing.T) {
+			opts, estimate := CalibrateBytesPerSample(Characteristics{
+				ScrapeInterval: 15 * time.Second,
+				Min:            100,
+				Max:            200,
+				BytesPerSample: target,
+			})
+			testutil.Assert(t, math.Abs(estimate-target) < 0.05*target, "estimate %v too far from target %v", estimate, target)
+			testutil.Assert(t, opts.ChangeInterval > 0, "change interval should be set")
+
+			// Cached result is the same.
+			cached, cachedEstimate := CalibrateBytesPerSample(Characteristics{
+				ScrapeInterval: 15 * time.Second,
+				Min:            100,
+				Max:            200,
+				BytesPerSample: target,
+			})
+			testutil.Equals(t, opts, cached)
+			testutil.Equals(t, estimate, cachedEstimate)
+		})
+	}
+
+	// Regular timestamps and constant values can't be encoded any better than that.
+	_, estimate := CalibrateBytesPerSample(Characteristics{ScrapeInterval: 15 * time.Second, Min: 100, Max: 200, BytesPerSample: 0.1})
+	testutil.Assert(t, estimate > 0.3 && estimate < 0.4, "unexpected estimate for unreachable target %v", estimate)
+}
+
+func TestAnomalyIterator(t *testing.T) {
+	// Series increasing by 1 every 10s, from 0 to 20.
+	newSeries := func() *testIterator {
+		it := &testIterator{}
+		for i := 0; i <= 20; i++ {
+			it.samples = append(it.samples, sample{T: int64(i) * 10000, V: float64(i)})
+		}
+		return it
+	}
+	values := func(it SeriesIterator) []float64 {
+		var res []float64
+		for it.Next() {
+			_, v := it.At()
+			res = append(res, v)
+		}
+		testutil.Ok(t, it.Err())
+		return res
+	}
+
+	t.Run("fixed", func(t *testing.T) {
+		for _, a := range []Anomaly{
+			{Type: Spike, Start: 25 * time.Second, Magnitude: 100},
+			{Type: Step, Start: 50 * time.Second, Duration: 30 * time.Second, Magnitude: -10},
+			{Type: Drop, Start: 150 * time.Second, Magnitude: 0},
+			{Type: Flatline, Start: 100 * time.Second, Duration: 20 * time.Second},
+		} {
+			testutil.Ok(t, a.Validate())
+		}
+		it := NewAnomalyIterator(rand.New(rand.NewSource(1)), 0, newSeries(), []Anomaly{
+			{Type: Spike, Start: 25 * time.Second, Magnitude: 100},
+			{Type: Step, Start: 50 * time.Second, Duration: 30 * time.Second, Magnitude: -10},
+			{Type: Drop, Start: 150 * time.Second, Magnitude: 0},
+			{Type: Flatline, Start: 100 * time.Second, Duration: 20 * time.Second},
+		})
+		testutil.Equals(t, []float64{
+			0, 1, 2, 103, 4,
+			-5, -4, -3, 8, 9,
+			9, 9, 12, 13, 14,
+			0, 0, 0, 0, 0, 0,
+		}, values(it))
+	})
+	t.Run("random", func(t *testing.T) {
+		anomalies := []Anomaly{{Type: Spike, Probability: 0.2, Magnitude: 100}}
+		got := values(NewAnomalyIterator(rand.New(rand.NewSource(1)), 0, newSeries(), anomalies))
+
+		spikes := 0
+		for i, v := range got {
+			if v != float64(i) {
+				testutil.Equals(t, float64(i+100), v)
+				spikes++
+			}
+		}
+		testutil.Assert(t, spikes > 0 && spikes < 10, "unexpected number of spikes %v", spikes)
+
+		// The same seed gives the same events.
+		testutil.Equals(t, got, values(NewAnomalyIterator(rand.New(rand.NewSource(1)), 0, newSeries(), anomalies)))
+	})
+
+	testutil.NotOk(t, Anomaly{Type: "unknown"}.Validate())
+	testutil.NotOk(t, Anomaly{Type: Spike, Probability: 2}.Validate())
+}
+
+func TestSpecialValuesIterator(t *testing.T) {
+	maxt := int64((6 * time.Hour).Seconds()) * 1000
+	it := NewSpecialValuesIterator(rand.New(rand.NewSource(1)), NewGaugeGen(rand.New(rand.NewSource(1)), 0, maxt, Characteristics{
+		ScrapeInterval: 15 * time.Second,
+		Min:            100,
+		Max:            200,
+	}), SpecialValues{Probability: 0.1})
+
+	var samples, nan, posInf, negInf, negZero int
+	for it.Next() {
+		samples++
+		_, v := it.At()
+		switch {
+		case math.IsNaN(v):
+			testutil.Assert(t, !value.IsStaleNaN(v), "special NaN should not be staleness marker")
+			nan++
+		case math.IsInf(v, 1):
+			posInf++
+		case math.IsInf(v, -1):
+			negInf++
+		case v == 0 && math.Signbit(v):
+			negZero++
+		}
+	}
+	testutil.Ok(t, it.Err())
+
+	special := nan + posInf + negInf + negZero
+	testutil.Assert(t, special > int(0.08*float64(samples)) && special < int(0.12*float64(samples)), "unexpected number of special values %v", special)
+	testutil.Assert(t, nan > 0 && posInf > 0 && negInf > 0 && negZero > 0, "expected all kinds of special values")
+
+	testutil.NotOk(t, SpecialValues{Probability: 0.1, Kinds: []SpecialValue{"0"}}.Validate())
+	testutil.Ok(t, SpecialValues{Probability: 0.1, Kinds: []SpecialValue{NaN, NegZero}}.Validate())
+}
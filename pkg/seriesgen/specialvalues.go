@@ -0,0 +1,115 @@
+package seriesgen
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+type SpecialValue string
+
+const (
+	NaN     SpecialValue = "nan"
+	PosInf  SpecialValue = "+inf"
+	NegInf  SpecialValue = "-inf"
+	NegZero SpecialValue = "-0"
+)
+
+var allSpecialValues = []SpecialValue{NaN, PosInf, NegInf, NegZero}
+
+// Float returns the float value. NaN is the regular NaN, not StaleNaN used for staleness markers.
+func (v SpecialValue) Float() float64 {
+	switch v {
+	case NaN:
+		return math.NaN()
+	case PosInf:
+		return math.Inf(1)
+	case NegInf:
+		return math.Inf(-1)
+	case NegZero:
+		return math.Copysign(0, -1)
+	}
+	return 0
+}
+
+// SpecialValues describes float values that real series contain rarely, e.g results of division by zero pushed by
+// exporters, but which historically broke chunk encoders and aggregations.
+type SpecialValues struct {
+	// Probability of replacing each float sample with a special value.
+	Probability float64 `yaml:"probability"`
+	// Kinds of special values, chosen randomly with the same probability. All kinds are used if empty.
+	Kinds []SpecialValue `yaml:"kinds"`
+}
+
+// Enabled returns true if any sample could be replaced.
+func (s SpecialValues) Enabled() bool {
+	return s.Probability > 0
+}
+
+func (s SpecialValues) Validate() error {
+	if s.Probability < 0 || s.Probability > 1 {
+		return errors.Errorf("special values: probability %v out of [0, 1] range", s.Probability)
+	}
+	for _, k := range s.Kinds {
+		switch k {
+		case NaN, PosInf, NegInf, NegZero:
+		default:
+			return errors.Errorf("unknown special value: %s", k)
+		}
+	}
+	return nil
+}
+
+var _ HistogramSeriesIterator = &SpecialValuesIterator{}
+
+// SpecialValuesIterator replaces float samples of the wrapped iterator with special values like NaN or ±Inf with given
+// probability. Native histograms are not changed.
+type SpecialValuesIterator struct {
+	pointIterator
+
+	it  SeriesIterator
+	hit HistogramSeriesIterator
+
+	probability float64
+	kinds       []SpecialValue
+
+	random *rand.Rand
+}
+
+func NewSpecialValuesIterator(random *rand.Rand, it SeriesIterator, s SpecialValues) *SpecialValuesIterator {
+	hit, _ := it.(HistogramSeriesIterator)
+	kinds := s.Kinds
+	if len(kinds) == 0 {
+		kinds = allSpecialValues
+	}
+	return &SpecialValuesIterator{
+		it:          it,
+		hit:         hit,
+		probability: s.Probability,
+		kinds:       kinds,
+		random:      random,
+	}
+}
+
+func (g *SpecialValuesIterator) Next() bool {
+	p, ok := fetchPoint(g.it, g.hit)
+	if !ok {
+		return false
+	}
+
+	// Always draw the same number of random numbers, so iterators with the same seed replace the same samples.
+	replace := g.random.Float64() < g.probability
+	kind := g.kinds[g.random.Intn(len(g.kinds))]
+	if replace && p.typ == chunkenc.ValFloat {
+		p.v = kind.Float()
+	}
+	g.curr = p
+	return true
+}
+
+// SeekTo fast-forwards through skipped samples, as every sample draws random numbers.
+func (g *SpecialValuesIterator) SeekTo(t int64) bool { return g.seek(g.Next, t) }
+
+func (g *SpecialValuesIterator) Err() error { return g.it.Err() }
@@ -0,0 +1,92 @@
+package seriesgen
+
+import (
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// NewStorageSeriesSet exposes given series set as Prometheus storage.SeriesSet, so generated series can be used e.g by
+// storage.Querier implementations or PromQL. Series are returned in the same order, so the set has to be sorted
+// by labels if caller requires it. Series are generated lazily, each of them can be iterated only once.
+func NewStorageSeriesSet(set SeriesSet) storage.SeriesSet {
+	return &storageSeriesSet{set: set}
+}
+
+type storageSeriesSet struct {
+	set SeriesSet
+}
+
+func (s *storageSeriesSet) Next() bool { return s.set.Next() }
+
+func (s *storageSeriesSet) At() storage.Series { return &storageSeries{s: s.set.At()} }
+
+func (s *storageSeriesSet) Err() error { return s.set.Err() }
+
+func (s *storageSeriesSet) Warnings() storage.Warnings { return nil }
+
+type storageSeries struct {
+	s Series
+}
+
+func (s *storageSeries) Labels() labels.Labels { return s.s.Labels() }
+
+func (s *storageSeries) Iterator(chunkenc.Iterator) chunkenc.Iterator {
+	it := s.s.Iterator()
+	hit, _ := it.(HistogramSeriesIterator)
+	return &chunkIterator{it: it, hit: hit}
+}
+
+// chunkIterator adapts SeriesIterator to chunkenc.Iterator.
+type chunkIterator struct {
+	it  SeriesIterator
+	hit HistogramSeriesIterator
+
+	done bool
+}
+
+func (c *chunkIterator) valueType() chunkenc.ValueType {
+	if c.hit != nil {
+		return c.hit.ValueType()
+	}
+	return chunkenc.ValFloat
+}
+
+func (c *chunkIterator) Next() chunkenc.ValueType {
+	if c.done || !c.it.Next() {
+		c.done = true
+		return chunkenc.ValNone
+	}
+	return c.valueType()
+}
+
+// seekTime is int64 under another name, so vet does not mistake chunkenc.Iterator Seek for a malformed io.Seeker.
+type seekTime = int64
+
+func (c *chunkIterator) Seek(t seekTime) chunkenc.ValueType {
+	if c.done || !c.it.SeekTo(t) {
+		c.done = true
+		return chunkenc.ValNone
+	}
+	return c.valueType()
+}
+
+func (c *chunkIterator) At() (int64, float64) { return c.it.At() }
+
+func (c *chunkIterator) AtHistogram() (int64, *histogram.Histogram) { return c.hit.AtHistogram() }
+
+func (c *chunkIterator) AtFloatHistogram() (int64, *histogram.FloatHistogram) {
+	if c.hit.ValueType() == chunkenc.ValHistogram {
+		t, h := c.hit.AtHistogram()
+		return t, h.ToFloat()
+	}
+	return c.hit.AtFloatHistogram()
+}
+
+func (c *chunkIterator) AtT() int64 {
+	t, _ := c.it.At()
+	return t
+}
+
+func (c *chunkIterator) Err() error { return c.it.Err() }
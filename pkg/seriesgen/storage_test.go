@@ -0,0 +1,70 @@
+package seriesgen
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+func TestStorageSeriesSet(t *testing.T) {
+	maxt := int64((1 * time.Hour).Seconds()) * 1000
+	newSet := func() *testSeriesSet {
+		return &testSeriesSet{series: []Series{
+			NewSeriesGen(labels.FromStrings(labels.MetricName, "gauge"), NewGaugeGen(rand.New(rand.NewSource(1)), 0, maxt, Characteristics{
+				ScrapeInterval: 15 * time.Second,
+				Min:            10,
+				Max:            20,
+			})),
+			NewSeriesGen(labels.FromStrings(labels.MetricName, "histogram"), NewNativeHistogramGen(rand.New(rand.NewSource(1)), 0, maxt, Characteristics{
+				ScrapeInterval:  15 * time.Second,
+				NativeHistogram: NativeHistogramCharacteristics{Schema: 3, ObservationRate: 1},
+			})),
+		}}
+	}
+
+	set := NewStorageSeriesSet(newSet())
+	gen := newSet()
+	for set.Next() {
+		testutil.Assert(t, gen.Next(), "unexpected series")
+		testutil.Equals(t, gen.At().Labels(), set.At().Labels())
+
+		expected := gen.At().Iterator()
+		it := set.At().Iterator(nil)
+
+		// Seek to the middle, then iterate the rest.
+		testutil.Assert(t, expected.SeekTo(maxt/2), "seek should succeed")
+		typ := it.Seek(maxt / 2)
+		for {
+			testutil.Assert(t, typ != chunkenc.ValNone, "missing samples")
+			ets, ev := expected.At()
+			testutil.Equals(t, ets, it.AtT())
+			switch typ {
+			case chunkenc.ValFloat:
+				_, v := it.At()
+				testutil.Equals(t, ev, v)
+			case chunkenc.ValHistogram:
+				_, h := it.AtHistogram()
+				_, eh := expected.(HistogramSeriesIterator).AtHistogram()
+				testutil.Equals(t, eh, h)
+
+				_, fh := it.AtFloatHistogram()
+				testutil.Equals(t, eh.ToFloat(), fh)
+			default:
+				t.Fatalf("unexpected value type %v", typ)
+			}
+			if !expected.Next() {
+				break
+			}
+			typ = it.Next()
+		}
+		testutil.Equals(t, chunkenc.ValNone, it.Next())
+		testutil.Equals(t, chunkenc.ValNone, it.Seek(0))
+		testutil.Ok(t, it.Err())
+	}
+	testutil.Assert(t, !gen.Next(), "missing series")
+	testutil.Ok(t, set.Err())
+}
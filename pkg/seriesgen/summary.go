@@ -0,0 +1,233 @@
+package seriesgen
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// DefObjectives are the default summary quantiles.
+var DefObjectives = []float64{0.5, 0.9, 0.99}
+
+// summaryMaxKept is maximum number of observations kept per scrape to estimate quantiles. If there are more
+// observations, the kept ones are weighted accordingly.
+const summaryMaxKept = 100
+
+// SummaryCharacteristics describes summary quantiles and observations.
+type SummaryCharacteristics struct {
+	// Objectives are quantiles exposed as separate series. Defaults to DefObjectives.
+	Objectives []float64 `yaml:"objectives"`
+	// MaxAge is the duration of the sliding window observations are kept for quantiles. Defaults to 10m,
+	// same as in Prometheus client_golang.
+	MaxAge time.Duration `yaml:"maxAge"`
+
+	// ObservationRate is an average number of observations per second. Defaults to 1.
+	ObservationRate float64 `yaml:"observationRate"`
+	// Observations is the distribution of observed values. Defaults to uniform distribution between 0 and 1.
+	Observations Distribution `yaml:"observations"`
+}
+
+// Quantiles returns sorted quantiles of the summary.
+func (s SummaryCharacteristics) Quantiles() []float64 {
+	if len(s.Objectives) == 0 {
+		return DefObjectives
+	}
+	q := append([]float64{}, s.Objectives...)
+	sort.Float64s(q)
+	return q
+}
+
+type weightedObservation struct {
+	t    int64
+	v, w float64
+}
+
+// SummaryGen generates a summary: quantiles over observations from the last MaxAge, sum and count.
+// Every scrape the number of observations is drawn from Poisson distribution with mean derived from observation rate.
+// Sum and count are monotonic and reset together according to configured Resets. Quantiles are NaN if there were no
+// observations within MaxAge, same as in Prometheus client_golang.
+type SummaryGen struct {
+	interval         time.Duration
+	maxTime, minTime int64
+	maxAge           int64
+	resets           *resetter
+
+	quantiles []float64
+	obs       *sampler
+	rate      float64
+
+	// window are observations from the last MaxAge, sorted by value.
+	window []weightedObservation
+	// scrape and buf are reused buffers for new observations and merging.
+	scrape, buf []weightedObservation
+	values      []float64
+	sum, count  float64
+	init        bool
+
+	random *rand.Rand
+}
+
+func NewSummaryGen(random *rand.Rand, mint, maxt int64, opts Characteristics) *SummaryGen {
+	g := &SummaryGen{
+		interval:  opts.ScrapeInterval,
+		minTime:   mint,
+		maxTime:   maxt,
+		maxAge:    opts.Summary.MaxAge.Milliseconds(),
+		quantiles: opts.Summary.Quantiles(),
+		rate:      opts.Summary.ObservationRate,
+		resets:    newResetter(mint, opts.Resets),
+		random:    random,
+	}
+	if g.maxAge <= 0 {
+		g.maxAge = (10 * time.Minute).Milliseconds()
+	}
+	if g.rate <= 0 {
+		g.rate = 1
+	}
+	obs := opts.Summary.Observations
+	if obs == (Distribution{}) {
+		obs = Distribution{Type: Uniform, Min: 0, Max: 1}
+	}
+	g.obs = newSampler(random, obs)
+	g.values = make([]float64, len(g.quantiles))
+	return g
+}
+
+// Quantiles returns quantiles of the generated summary.
+func (g *SummaryGen) Quantiles() []float64 { return g.quantiles }
+
+func (g *SummaryGen) Next() bool {
+	if g.init {
+		g.minTime += int64(g.interval.Seconds() * 1000)
+	}
+	if g.minTime > g.maxTime {
+		return false
+	}
+	g.init = true
+
+	if g.resets.Reset(g.random, g.minTime) {
+		g.sum, g.count, g.window = 0, 0, g.window[:0]
+	}
+
+	n := poisson(g.random, g.rate*g.interval.Seconds())
+	kept := math.Min(n, summaryMaxKept)
+	g.scrape = g.scrape[:0]
+	for i := 0; i < int(kept); i++ {
+		v := g.obs.sample()
+		g.scrape = append(g.scrape, weightedObservation{t: g.minTime, v: v, w: n / kept})
+		g.sum += v * n / kept
+	}
+	g.count += n
+	sort.Slice(g.scrape, func(i, j int) bool { return g.scrape[i].v < g.scrape[j].v })
+
+	g.window, g.buf = mergeObservations(g.buf[:0], g.window, g.scrape, g.minTime-g.maxAge), g.window
+	g.computeQuantiles()
+	return true
+}
+
+// mergeObservations merges sorted observations into dst, dropping ones not newer than given cutoff.
+func mergeObservations(dst, a, b []weightedObservation, cutoff int64) []weightedObservation {
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		var o weightedObservation
+		if j >= len(b) || (i < len(a) && a[i].v <= b[j].v) {
+			o, i = a[i], i+1
+		} else {
+			o, j = b[j], j+1
+		}
+		if o.t > cutoff {
+			dst = append(dst, o)
+		}
+	}
+	return dst
+}
+
+func (g *SummaryGen) computeQuantiles() {
+	if len(g.window) == 0 {
+		for i := range g.values {
+			g.values[i] = math.NaN()
+		}
+		return
+	}
+
+	var total float64
+	for _, o := range g.window {
+		total += o.w
+	}
+
+	var (
+		cumulative float64
+		j          int
+	)
+	for i, q := range g.quantiles {
+		for ; j < len(g.window)-1; j++ {
+			if cumulative+g.window[j].w >= q*total {
+				break
+			}
+			cumulative += g.window[j].w
+		}
+		g.values[i] = g.window[j].v
+	}
+}
+
+// Quantile returns the current value of i-th quantile.
+func (g *SummaryGen) Quantile(i int) (t int64, v float64) { return g.minTime, g.values[i] }
+
+// Sum returns the current sum of observations.
+func (g *SummaryGen) Sum() (t int64, v float64) { return g.minTime, g.sum }
+
+// Count returns the current count of observations.
+func (g *SummaryGen) Count() (t int64, v float64) { return g.minTime, g.count }
+
+func (g *SummaryGen) Err() error { return nil }
+
+type summaryComponentIter struct {
+	g         *SummaryGen
+	component int
+}
+
+func (it *summaryComponentIter) Next() bool { return it.g.Next() }
+
+func (it *summaryComponentIter) At() (int64, float64) {
+	switch it.component {
+	case histogramSum:
+		return it.g.Sum()
+	case histogramCount:
+		return it.g.Count()
+	default:
+		return it.g.Quantile(it.component)
+	}
+}
+
+// SeekTo fast-forwards through skipped samples, as every sample draws random numbers.
+func (it *summaryComponentIter) SeekTo(t int64) bool {
+	return seekNext(it, it.g.init, it.g.minTime, t)
+}
+
+func (it *summaryComponentIter) Err() error { return it.g.Err() }
+
+// NewSummarySeries expands given labels into summary series: <name> for each quantile, <name>_sum and <name>_count.
+// Every series has its own generator created with the same seed, so all of them replay exactly the same observations.
+func NewSummarySeries(lset labels.Labels, seed int64, mint, maxt int64, opts Characteristics) []Series {
+	newGen := func() *SummaryGen {
+		return NewSummaryGen(rand.New(rand.NewSource(seed)), mint, maxt, opts)
+	}
+
+	name := lset.Get(labels.MetricName)
+	quantiles := opts.Summary.Quantiles()
+	series := make([]Series, 0, len(quantiles)+2)
+	for i, q := range quantiles {
+		series = append(series, NewSeriesGen(
+			labels.NewBuilder(lset).Set(model.QuantileLabel, formatFloat(q)).Labels(),
+			&summaryComponentIter{g: newGen(), component: i},
+		))
+	}
+	series = append(series,
+		NewSeriesGen(labels.NewBuilder(lset).Set(labels.MetricName, name+"_sum").Labels(), &summaryComponentIter{g: newGen(), component: histogramSum}),
+		NewSeriesGen(labels.NewBuilder(lset).Set(labels.MetricName, name+"_count").Labels(), &summaryComponentIter{g: newGen(), component: histogramCount}),
+	)
+	return series
+}
@@ -0,0 +1,107 @@
+package seriesgen
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+var _ HistogramSeriesIterator = &TimestampIterator{}
+
+// TimestampIterator shifts timestamps of the wrapped iterator by a stable offset and random per-sample jitter, as
+// Prometheus scrapes each target with an offset derived from the target hash and actual scrape times vary slightly.
+// Samples shifted outside of [mint, maxt] are dropped, so series do not leak outside of its block.
+type TimestampIterator struct {
+	pointIterator
+
+	it  SeriesIterator
+	hit HistogramSeriesIterator
+
+	minTime int64
+	maxTime int64
+	offset  int64
+	jitter  int64
+
+	random *rand.Rand
+}
+
+// NewTimestampIterator wraps given iterator. Offset is expected to be stable per target, e.g derived from the series
+// hash. Jitter is capped to below half of the scrape interval, so samples stay in order.
+func NewTimestampIterator(random *rand.Rand, mint, maxt int64, it SeriesIterator, offset, jitter, interval time.Duration) *TimestampIterator {
+	hit, _ := it.(HistogramSeriesIterator)
+	j := jitter.Milliseconds()
+	if max := interval.Milliseconds()/2 - 1; j > max {
+		j = max
+	}
+	if j < 0 {
+		j = 0
+	}
+	return &TimestampIterator{
+		it:      it,
+		hit:     hit,
+		minTime: mint,
+		maxTime: maxt,
+		offset:  offset.Milliseconds(),
+		jitter:  j,
+		random:  random,
+	}
+}
+
+// ScrapeOffset returns stable offset within scrape interval for given series hash.
+func ScrapeOffset(hash uint64, interval time.Duration) time.Duration {
+	ms := interval.Milliseconds()
+	if ms <= 0 {
+		return 0
+	}
+	return time.Duration(hash%uint64(ms)) * time.Millisecond
+}
+
+func (g *TimestampIterator) Next() bool {
+	for {
+		p, ok := fetchPoint(g.it, g.hit)
+		if !ok {
+			return false
+		}
+
+		p.t += g.offset
+		if g.jitter > 0 {
+			p.t += g.random.Int63n(2*g.jitter+1) - g.jitter
+		}
+		if p.t > g.maxTime {
+			// Timestamps only grow, nothing more to return.
+			return false
+		}
+		if p.t < g.minTime {
+			continue
+		}
+		g.curr = p
+		return true
+	}
+}
+
+func (g *TimestampIterator) SeekTo(t int64) bool {
+	if g.curr.typ != chunkenc.ValNone && g.curr.t >= t {
+		return true
+	}
+	if g.jitter > 0 {
+		// Fast-forward, jitter draws random number for every sample.
+		return g.seek(g.Next, t)
+	}
+
+	if !g.it.SeekTo(t - g.offset) {
+		return false
+	}
+	p := atPoint(g.it, g.hit)
+	p.t += g.offset
+	if p.t > g.maxTime {
+		return false
+	}
+	if p.t < g.minTime {
+		return g.seek(g.Next, t)
+	}
+	g.curr = p
+	return true
+}
+
+func (g *TimestampIterator) Err() error { return g.it.Err() }
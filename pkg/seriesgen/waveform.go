@@ -0,0 +1,144 @@
+package seriesgen
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+var _ SeriesIterator = &WaveGen{}
+
+// WaveGen generates gauge that follows given shape, e.g seasonality or trend, on top of random base value from
+// [Min, Max]. Similar to GaugeGen, random offset up to Jitter is added every ChangeInterval.
+type WaveGen struct {
+	changeInterval   time.Duration
+	interval         time.Duration
+	maxTime, minTime int64
+
+	min, max, jitter float64
+
+	// shape returns value for the given timestamp and base.
+	shape func(base float64, t int64) float64
+
+	base    float64
+	mod     float64
+	init    bool
+	elapsed int64
+
+	random *rand.Rand
+}
+
+func newWaveGen(random *rand.Rand, mint, maxt int64, opts Characteristics, shape func(base float64, t int64) float64) *WaveGen {
+	return &WaveGen{
+		changeInterval: opts.ChangeInterval,
+		interval:       opts.ScrapeInterval,
+		max:            opts.Max,
+		min:            opts.Min,
+		minTime:        mint,
+		maxTime:        maxt,
+		jitter:         opts.Jitter,
+		shape:          shape,
+		random:         random,
+	}
+}
+
+// phaseAt returns position within the period for the given timestamp, in [0, 1).
+// Period is counted from Unix epoch, so e.g daily seasonality peaks at the same time of day for all series.
+func phaseAt(opts Characteristics, t int64) float64 {
+	period := opts.Period.Milliseconds()
+	if period <= 0 {
+		return 0
+	}
+	p := (t + opts.Phase.Milliseconds()) % period
+	if p < 0 {
+		p += period
+	}
+	return float64(p) / float64(period)
+}
+
+// NewSineGen returns gauge with sinusoidal seasonality, e.g daily or weekly traffic pattern.
+func NewSineGen(random *rand.Rand, mint, maxt int64, opts Characteristics) *WaveGen {
+	return newWaveGen(random, mint, maxt, opts, func(base float64, t int64) float64 {
+		return base + opts.Amplitude*math.Sin(2*math.Pi*phaseAt(opts, t))
+	})
+}
+
+// NewSawtoothGen returns gauge that rises linearly from base-Amplitude to base+Amplitude every period and drops.
+func NewSawtoothGen(random *rand.Rand, mint, maxt int64, opts Characteristics) *WaveGen {
+	return newWaveGen(random, mint, maxt, opts, func(base float64, t int64) float64 {
+		return base + opts.Amplitude*(2*phaseAt(opts, t)-1)
+	})
+}
+
+// NewSquareGen returns gauge that switches between base+Amplitude and base-Amplitude every half of the period.
+func NewSquareGen(random *rand.Rand, mint, maxt int64, opts Characteristics) *WaveGen {
+	return newWaveGen(random, mint, maxt, opts, func(base float64, t int64) float64 {
+		if phaseAt(opts, t) < 0.5 {
+			return base + opts.Amplitude
+		}
+		return base - opts.Amplitude
+	})
+}
+
+// NewLinearTrendGen returns gauge that changes by Slope every second since mint, e.g disk filling up.
+func NewLinearTrendGen(random *rand.Rand, mint, maxt int64, opts Characteristics) *WaveGen {
+	return newWaveGen(random, mint, maxt, opts, func(base float64, t int64) float64 {
+		return base + opts.Slope*float64(t-mint)/1000
+	})
+}
+
+// NewExponentialTrendGen returns gauge that grows exponentially with Slope relative growth per second since mint.
+func NewExponentialTrendGen(random *rand.Rand, mint, maxt int64, opts Characteristics) *WaveGen {
+	return newWaveGen(random, mint, maxt, opts, func(base float64, t int64) float64 {
+		return base * math.Exp(opts.Slope*float64(t-mint)/1000)
+	})
+}
+
+func (g *WaveGen) Next() bool {
+	if g.init {
+		g.minTime += int64(g.interval.Seconds() * 1000)
+		g.elapsed += int64(g.interval.Seconds() * 1000)
+	}
+	if g.minTime > g.maxTime {
+		return false
+	}
+
+	if !g.init {
+		g.base = g.min + g.random.Float64()*(g.max-g.min)
+		g.init = true
+	}
+
+	if g.jitter > 0 && g.elapsed >= int64(g.changeInterval.Seconds()*1000) {
+		g.mod = (g.random.Float64() - 0.5) * g.jitter
+		g.elapsed = 0
+	}
+	return true
+}
+
+func (g *WaveGen) SeekTo(t int64) bool {
+	if g.jitter > 0 {
+		// Fast-forward, jitter draws random numbers every ChangeInterval.
+		return seekNext(g, g.init, g.minTime, t)
+	}
+	if g.init && g.minTime >= t {
+		return true
+	}
+	if !g.init && !g.Next() {
+		return false
+	}
+
+	// No random numbers are drawn after the first sample, jump directly.
+	interval := int64(g.interval.Seconds() * 1000)
+	if g.minTime < t && interval > 0 {
+		skip := (t - g.minTime + interval - 1) / interval * interval
+		g.minTime += skip
+		g.elapsed += skip
+	}
+	return g.minTime <= g.maxTime
+}
+
+func (g *WaveGen) At() (int64, float64) {
+	return g.minTime, g.shape(g.base, g.minTime) + g.mod
+}
+
+func (g *WaveGen) Err() error { return nil }
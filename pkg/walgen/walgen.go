@@ -2,9 +2,11 @@ package walgen
 
 import (
 	"context"
+	"encoding/json"
 	"math/rand"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,6 +18,7 @@ import (
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/thanosbench/pkg/blockgen"
 	"github.com/thanos-io/thanosbench/pkg/seriesgen"
 )
 
@@ -24,23 +27,65 @@ type Config struct {
 	InputSeries    []Series
 	Retention      time.Duration
 	ScrapeInterval time.Duration
+	// MaxExemplars is the size of circular exemplar storage, used if any series has exemplars. Defaults to 100000,
+	// same as Prometheus.
+	MaxExemplars int64
 }
 
 type Series struct {
-	Type string // gauge, counter (if counter we treat below as rate aim).
+	// Type is case-insensitive blockgen.GenType e.g gauge, counter (if counter we treat below as rate aim), sine, histogram, summary.
+	Type string
 
 	Characteristics seriesgen.Characteristics
 
 	// Result is an exact Prometheus HTTP query result that would be used to generate series' metrics labels.
+	// If result is a matrix and type is replay, recorded values are replayed as well.
 	Result QueryData
 	// Replicate multiples this set given number of times. For example if result has 10 metrics and replicate is 10 we will
 	// have 100 unique series.
 	Replicate int
+	// Churn replaces every replica over time. Replaced replicas get fresh blockgen_fake_start label with start time
+	// of the replica.
+	Churn blockgen.Churn
 }
 
 type QueryData struct {
 	ResultType model.ValueType `json:"resultType"`
 	Result     model.Vector    `json:"result"`
+	// Matrix is a range query result, used instead of Result if ResultType is matrix.
+	Matrix model.Matrix `json:"-"`
+}
+
+// UnmarshalJSON decodes data section of Prometheus HTTP query or query_range response.
+func (q *QueryData) UnmarshalJSON(b []byte) error {
+	var data struct {
+		ResultType model.ValueType `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	*q = QueryData{ResultType: data.ResultType}
+	if len(data.Result) == 0 {
+		return nil
+	}
+	if data.ResultType == model.ValMatrix {
+		return json.Unmarshal(data.Result, &q.Matrix)
+	}
+	return json.Unmarshal(data.Result, &q.Result)
+}
+
+// streams returns all series of the result. Series of vector result have no samples.
+func (q QueryData) streams() []*model.SampleStream {
+	if q.ResultType == model.ValMatrix {
+		return q.Matrix
+	}
+	res := make([]*model.SampleStream, 0, len(q.Result))
+	for _, s := range q.Result {
+		res = append(res, &model.SampleStream{Metric: s.Metric})
+	}
+	return res
 }
 
 func GenerateTSDBWAL(logger log.Logger, dir string, config Config) error {
@@ -49,6 +94,22 @@ func GenerateTSDBWAL(logger log.Logger, dir string, config Config) error {
 	}
 
 	maxBlockDuration := config.Retention / 10
+
+	// Enable out-of-order ingestion and exemplar storage only if needed. Late samples have to be within out-of-order
+	// window, so they are written to WBL and out-of-order head chunks.
+	var (
+		oooWindow time.Duration
+		exemplars bool
+	)
+	for _, in := range config.InputSeries {
+		if in.Characteristics.OutOfOrder.Enabled() && in.Characteristics.OutOfOrder.Window > oooWindow {
+			oooWindow = in.Characteristics.OutOfOrder.Window
+		}
+		exemplars = exemplars || in.Characteristics.Exemplars.Enabled()
+	}
+	if config.MaxExemplars == 0 {
+		config.MaxExemplars = 100000
+	}
 	// TODO(bwplotka): Moved to something like https://github.com/thanos-io/thanos/blob/master/pkg/testutil/prometheus.go#L289
 	//  to actually generate blocks! It will be fine for TSDB use cases as well.
 	db, err := tsdb.Open(dir, nil, nil, &tsdb.Options{
@@ -56,6 +117,11 @@ func GenerateTSDBWAL(logger log.Logger, dir string, config Config) error {
 		MaxBlockDuration:  maxBlockDuration.Milliseconds(),
 		RetentionDuration: config.Retention.Milliseconds(),
 		NoLockfile:        true,
+		// Allow native histograms, only generated if any series asks for them.
+		EnableNativeHistograms: true,
+		OutOfOrderTimeWindow:   oooWindow.Milliseconds(),
+		EnableExemplarStorage:  exemplars,
+		MaxExemplars:           config.MaxExemplars,
 	}, nil)
 	if err != nil {
 		level.Error(logger).Log("err", err)
@@ -72,7 +138,13 @@ func GenerateTSDBWAL(logger log.Logger, dir string, config Config) error {
 
 	set := &Set{}
 	for _, in := range config.InputSeries {
-		for _, r := range in.Result.Result {
+		typ := blockgen.GenType(strings.ToUpper(in.Type))
+		if in.Churn.Enabled() {
+			if err := in.Churn.Validate(); err != nil {
+				return errors.Wrapf(err, "metric type: %s", in.Type)
+			}
+		}
+		for _, r := range in.Result.streams() {
 			for i := 0; i < in.Replicate; i++ {
 				lset := labels.New()
 				for n, v := range r.Metric {
@@ -81,13 +153,30 @@ func GenerateTSDBWAL(logger log.Logger, dir string, config Config) error {
 				if i > 0 {
 					lset = append(lset, labels.Label{Name: "blockgen_fake_replica", Value: strconv.Itoa(i)})
 				}
-				switch strings.ToLower(in.Type) {
-				case "counter":
-					set.s = append(set.s, seriesgen.NewSeriesGen(lset, seriesgen.NewCounterGen(random, minTime, maxTime, in.Characteristics)))
-				case "gauge":
-					set.s = append(set.s, seriesgen.NewSeriesGen(lset, seriesgen.NewGaugeGen(random, minTime, maxTime, in.Characteristics)))
-				default:
-					return errors.Errorf("failed to parse series, unknown metric type: %s", in.Type)
+				sort.Sort(lset)
+
+				for _, life := range in.Churn.Lifetimes(int64(lset.Hash()), minTime, maxTime, config.ScrapeInterval) {
+					lset := lset
+					if in.Churn.Enabled() {
+						lset = labels.NewBuilder(lset).Set("blockgen_fake_start", strconv.FormatInt(life.Start, 10)).Labels()
+					}
+					mint, maxt, opts := life.Window(minTime, maxTime, in.Characteristics)
+					opts.Replay.Target = i
+
+					// Each series gets its own seed, so series expanded from one input (e.g histogram buckets) stay consistent.
+					seed := random.Int63()
+					if typ == blockgen.Replay && len(r.Values) > 0 {
+						// Replay values from the query result instead of a file.
+						iter := seriesgen.NewReplayGen(rand.New(rand.NewSource(seed)), mint, maxt, r.Values, opts)
+						set.s = append(set.s, typ.Wrap([]seriesgen.Series{seriesgen.NewSeriesGen(lset, iter)}, seed, mint, maxt, opts)...)
+						continue
+					}
+
+					s, err := typ.CreateSeries(lset, seed, mint, maxt, opts)
+					if err != nil {
+						return errors.Wrapf(err, "failed to parse series, metric type: %s", in.Type)
+					}
+					set.s = append(set.s, s...)
 				}
 			}
 		}
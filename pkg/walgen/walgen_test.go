@@ -0,0 +1,102 @@
+package walgen
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/efficientgo/core/testutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanosbench/pkg/blockgen"
+	"github.com/thanos-io/thanosbench/pkg/seriesgen"
+)
+
+func TestGenerateTSDBWAL_ReplayMatrix(t *testing.T) {
+	var result QueryData
+	testutil.Ok(t, json.Unmarshal([]byte(`{"resultType":"matrix","result":[
+		{"metric":{"__name__":"a","pod":"1"},"values":[[1600000000,"1"],[1600000030,"2"],[1600000060,"3"]]}
+	]}`), &result))
+	testutil.Equals(t, 1, len(result.Matrix))
+
+	dir := t.TempDir()
+	testutil.Ok(t, GenerateTSDBWAL(log.NewNopLogger(), dir, Config{
+		Retention: time.Hour,
+		InputSeries: []Series{{
+			Type:            "replay",
+			Characteristics: seriesgen.Characteristics{Replay: seriesgen.ReplayCharacteristics{Shift: true, Loop: true, Scale: 2}},
+			Result:          result,
+			Replicate:       2,
+		}},
+	}))
+
+	db, err := tsdb.OpenDBReadOnly(dir, log.NewNopLogger())
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, db.Close()) }()
+
+	q, err := db.Querier(context.Background(), math.MinInt64, math.MaxInt64)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	var series int
+	set := q.Select(false, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "a"))
+	for set.Next() {
+		series++
+		testutil.Equals(t, "1", set.At().Labels().Get("pod"))
+
+		var samples []float64
+		it := set.At().Iterator(nil)
+		for it.Next() != chunkenc.ValNone {
+			_, v := it.At()
+			samples = append(samples, v)
+		}
+		testutil.Ok(t, it.Err())
+		// Recording is looped every 90s across the retention.
+		testutil.Equals(t, int(time.Hour/(30*time.Second))+1, len(samples))
+		testutil.Equals(t, []float64{2, 4, 6, 2}, samples[:4])
+	}
+	testutil.Ok(t, set.Err())
+	testutil.Equals(t, 2, series)
+}
+
+func TestGenerateTSDBWAL_Churn(t *testing.T) {
+	var result QueryData
+	testutil.Ok(t, json.Unmarshal([]byte(`{"resultType":"vector","result":[
+		{"metric":{"__name__":"a","job":"batch"},"value":[1600000000,"1"]}
+	]}`), &result))
+
+	dir := t.TempDir()
+	testutil.Ok(t, GenerateTSDBWAL(log.NewNopLogger(), dir, Config{
+		Retention: 4 * time.Hour,
+		InputSeries: []Series{{
+			Type:            "gauge",
+			Characteristics: seriesgen.Characteristics{Min: 1, Max: 10, ScrapeInterval: 15 * time.Second},
+			Result:          result,
+			Replicate:       2,
+			Churn:           blockgen.Churn{Lifetime: seriesgen.Distribution{Min: 1800, Max: 3600}},
+		}},
+	}))
+
+	db, err := tsdb.OpenDBReadOnly(dir, log.NewNopLogger())
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, db.Close()) }()
+
+	q, err := db.Querier(context.Background(), math.MinInt64, math.MaxInt64)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	starts := map[string]struct{}{}
+	set := q.Select(false, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "a"))
+	for set.Next() {
+		start := set.At().Labels().Get("blockgen_fake_start")
+		testutil.Assert(t, start != "", "replica without start label: %v", set.At().Labels())
+		starts[start] = struct{}{}
+	}
+	testutil.Ok(t, set.Err())
+	// Each replica is replaced at least every hour.
+	testutil.Assert(t, len(starts) >= 8, "expected churned replicas, got %v", len(starts))
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/common/model"
 	"github.com/thanos-io/thanosbench/pkg/blockgen"
+	"github.com/thanos-io/thanosbench/pkg/exporter"
+	"github.com/thanos-io/thanosbench/pkg/remotewrite"
 	"github.com/thanos-io/thanosbench/pkg/walgen"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 	yaml "gopkg.in/yaml.v2"
@@ -43,6 +45,24 @@ func main() {
 		level.Error(logger).Log("msg", "failed to generate", "type", typ, "err", err)
 		os.Exit(1)
 	}
+
+	typ = "serve"
+	if err := generate(exporter.Config{Series: []blockgen.SeriesSpec{{}}}, typ, *outputDir); err != nil {
+		level.Error(logger).Log("msg", "failed to generate", "type", typ, "err", err)
+		os.Exit(1)
+	}
+
+	typ = "fleet"
+	if err := generate(exporter.FleetConfig{Config: exporter.Config{Series: []blockgen.SeriesSpec{{}}}}, typ, *outputDir); err != nil {
+		level.Error(logger).Log("msg", "failed to generate", "type", typ, "err", err)
+		os.Exit(1)
+	}
+
+	typ = "remote-write"
+	if err := generate(remotewrite.Config{Series: []blockgen.SeriesSpec{{}}}, typ, *outputDir); err != nil {
+		level.Error(logger).Log("msg", "failed to generate", "type", typ, "err", err)
+		os.Exit(1)
+	}
 	logger.Log("msg", "success")
 }
 
@@ -38,7 +38,7 @@ CHECK=${1:-}
 # Auto update flags.
 mkdir -p autogendocs
 
-commands=("walgen" "stress")
+commands=("walgen" "stress" "serve" "fleet" "remote-write")
 for x in "${commands[@]}"; do
     ${THANOSBENCH_BIN} "${x}" --help &> "autogendocs/flags_${x}.txt"
 done